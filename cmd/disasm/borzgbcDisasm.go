@@ -0,0 +1,84 @@
+package main
+
+import (
+	"borzGBC/pkg/disasm"
+	"borzGBC/pkg/gbc"
+	"borzGBC/pkg/romfile"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Disassembles a whole ROM in RGBDS syntax. The labels of the symbol file
+// next to the ROM are used, if present, and a coverage log recorded with
+// borzgbcHeadless -coverage adds the code that is not found statically
+
+func main() {
+	entry := flag.String("entry", "", "ROM to load from a zip archive (default: the first .gb/.gbc)")
+	outPath := flag.String("o", "", "write the disassembly to a file, instead of stdout")
+	symPath := flag.String("sym", "", "symbol file (default: the .sym next to the ROM)")
+	coveragePath := flag.String("coverage", "", "coverage log with the executed code")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] rom\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	romPath := flag.Arg(0)
+	rom, err := romfile.Load(romPath, *entry)
+	if err != nil {
+		log.Fatalf("invalid rom: %s\n", err)
+	}
+	d, err := disasm.MakeDisassembler(rom)
+	if err != nil {
+		log.Fatalf("unable to disassemble the rom: %s\n", err)
+	}
+
+	var syms []byte
+	if *symPath != "" {
+		syms, err = os.ReadFile(*symPath)
+	} else {
+		syms, err = romfile.LoadSymbols(romPath)
+	}
+	if err != nil {
+		log.Fatalf("unable to read the symbols: %s\n", err)
+	}
+	if syms != nil {
+		if d.Symbols, err = gbc.ParseSymbols(syms); err != nil {
+			log.Fatalf("invalid symbols: %s\n", err)
+		}
+	}
+
+	if *coveragePath != "" {
+		data, err := os.ReadFile(*coveragePath)
+		if err != nil {
+			log.Fatalf("unable to read the coverage log: %s\n", err)
+		}
+		coverage, err := disasm.ParseCoverage(data)
+		if err != nil {
+			log.Fatalf("invalid coverage log: %s\n", err)
+		}
+		for _, loc := range coverage {
+			d.AddEntry(loc)
+		}
+	}
+
+	d.Analyze()
+	out := os.Stdout
+	if *outPath != "" {
+		if out, err = os.Create(*outPath); err != nil {
+			log.Fatalf("unable to create the output: %s\n", err)
+		}
+		defer out.Close()
+	}
+	if err := d.Write(out); err != nil {
+		log.Fatalf("unable to write the disassembly: %s\n", err)
+	}
+	log.Printf("%d bytes of code out of %d\n", d.CodeSize(), len(rom))
+}
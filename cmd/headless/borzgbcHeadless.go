@@ -0,0 +1,225 @@
+package main
+
+import (
+	"borzGBC/pkg/gbc"
+	"borzGBC/pkg/gdbstub"
+	"borzGBC/pkg/romfile"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+)
+
+// Runs a ROM without video and audio output, e.g., for test ROMs and
+// scripted runs. GBS files are played, optionally rendering the audio to
+// a WAV file
+
+type headlessFrontend struct {
+	screen *image.RGBA
+	serial bool
+	wav    *wavWriter
+}
+
+func makeHeadlessFrontend() *headlessFrontend {
+	return &headlessFrontend{
+		screen: image.NewRGBA(image.Rect(0, 0, 160, 144)),
+	}
+}
+
+func (f *headlessFrontend) SetPixel(x, y int, c uint32) {
+	f.screen.SetRGBA(x, y, color.RGBA{
+		uint8(c >> 24), uint8(c >> 16), uint8(c >> 8), uint8(c)})
+}
+
+func (f *headlessFrontend) CommitScreen() {}
+
+func (f *headlessFrontend) NotifyAudioSample(l, r int8) {
+	if f.wav != nil {
+		f.wav.addSample(l, r)
+	}
+}
+
+func (f *headlessFrontend) ExchangeSerial(sb, sc uint8) (uint8, uint8) {
+	// Transfers using the internal clock are printed on stdout, as
+	// expected by most test ROMs
+	if f.serial && sc&0x81 == 0x81 {
+		fmt.Printf("%c", sb)
+	}
+	return 0, 0
+}
+
+func (f *headlessFrontend) CPULocked(pc uint16, opcode uint8) {
+	log.Printf("CPU locked by the illegal opcode %02x at %04x\n", opcode, pc)
+}
+
+func (f *headlessFrontend) saveScreen(path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(out, f.screen); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func saveWav(fe *headlessFrontend, path string) {
+	if fe.wav == nil {
+		return
+	}
+	if err := fe.wav.save(path); err != nil {
+		log.Fatalf("unable to save the WAV: %s\n", err)
+	}
+}
+
+func playGBS(data []byte, track, frames int, fe *headlessFrontend) error {
+	gbs, err := gbc.LoadGBS(data)
+	if err != nil {
+		return err
+	}
+	player, err := gbc.MakeGBSPlayer(gbs, fe)
+	if err != nil {
+		return err
+	}
+	if track != 0 {
+		if err := player.PlayTrack(track - 1); err != nil {
+			return err
+		}
+	}
+	log.Printf("%s - %s (%s), track %d/%d\n", gbs.Header.Title, gbs.Header.Author,
+		gbs.Header.Copyright, player.Track+1, player.NumTracks())
+
+	for i := 0; i < frames; i++ {
+		player.Step()
+	}
+	return nil
+}
+
+func serveGDB(console *gbc.Console, port int) error {
+	addr := fmt.Sprintf("localhost:%d", port)
+	log.Printf("waiting for GDB on %s\n", addr)
+	dbg := gbc.MakeDebugger(console)
+	defer dbg.Detach()
+	server, err := gdbstub.Listen(addr, dbg)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+	return server.Run()
+}
+
+func main() {
+	entry := flag.String("entry", "", "ROM to load from a zip archive (default: the first .gb/.gbc)")
+	frames := flag.Int("frames", 600, "number of frames to run")
+	screenshot := flag.String("screenshot", "", "save the last frame as a PNG")
+	serial := flag.Bool("serial", false, "print the bytes sent on the serial port")
+	useSav := flag.Bool("sav", false, "load and store the .sav next to the ROM")
+	track := flag.Int("track", 0, "track of a GBS file to play, starting from 1 (default: the first track of the file)")
+	wavPath := flag.String("wav", "", "render the audio to a WAV file")
+	seconds := flag.Float64("seconds", 0, "seconds to run, instead of a number of frames")
+	gdbPort := flag.Int("gdb", 0, "wait for GDB on this port of localhost, and run until it detaches")
+	coveragePath := flag.String("coverage", "", "record the executed code in a coverage log, for borzgbcDisasm")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] rom\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	romPath := flag.Arg(0)
+	rom, err := romfile.Load(romPath, *entry)
+	if err != nil {
+		log.Fatalf("invalid rom: %s\n", err)
+	}
+	fe := makeHeadlessFrontend()
+	fe.serial = *serial
+	if *wavPath != "" {
+		fe.wav = &wavWriter{rate: gbc.AudioSampleRate}
+	}
+	if *seconds > 0 {
+		// 59.7 frames per second
+		*frames = int(*seconds * gbc.GBCPU_FREQ / 70224)
+	}
+
+	if gbc.IsGBS(rom) {
+		if err := playGBS(rom, *track, *frames, fe); err != nil {
+			log.Fatalf("unable to play the GBS: %s\n", err)
+		}
+		saveWav(fe, *wavPath)
+		return
+	}
+
+	console, err := gbc.MakeConsole(rom, fe)
+	if err != nil {
+		log.Fatalf("unable to create the console: %s\n", err)
+	}
+	for _, warning := range console.LoadReport.Warnings {
+		log.Printf("warning: %s\n", warning)
+	}
+
+	// Labels for the debug traces and GDB, next to the ROM
+	syms, err := romfile.LoadSymbols(romPath)
+	if err == nil && syms != nil {
+		err = console.LoadSymbols(syms)
+	}
+	if err != nil {
+		log.Printf("unable to load the symbols: %s\n", err)
+	}
+
+	// With archives, the .sav is stored next to the archive
+	savFile := fmt.Sprintf("%s.sav", romPath)
+	if *useSav {
+		if sav, err := os.ReadFile(savFile); err == nil {
+			if err := console.LoadSav(sav); err != nil {
+				log.Fatalf("unable to load sav: %s\n", err)
+			}
+		}
+	}
+
+	if *gdbPort != 0 {
+		if err := serveGDB(console, *gdbPort); err != nil {
+			log.Fatalf("unable to serve GDB: %s\n", err)
+		}
+	} else {
+		var coverage coverageLog
+		if *coveragePath != "" {
+			coverage = make(coverageLog)
+		}
+		for i := 0; i < *frames; i++ {
+			if coverage != nil {
+				coverage.step(console)
+			} else {
+				console.Step()
+			}
+		}
+		if coverage != nil {
+			if err := coverage.save(*coveragePath); err != nil {
+				log.Fatalf("unable to save the coverage log: %s\n", err)
+			}
+		}
+	}
+
+	saveWav(fe, *wavPath)
+	if *screenshot != "" {
+		if err := fe.saveScreen(*screenshot); err != nil {
+			log.Fatalf("unable to save the screenshot: %s\n", err)
+		}
+	}
+	if *useSav {
+		sav, err := console.StoreSav()
+		if err != nil {
+			log.Fatalf("unable to store sav: %s\n", err)
+		}
+		if err := os.WriteFile(savFile, sav, 0644); err != nil {
+			log.Fatalf("unable to store sav: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"borzGBC/pkg/disasm"
+	"borzGBC/pkg/gbc"
+	"os"
+)
+
+// coverageLog records the ROM addresses executed by the CPU, as the
+// entries of the disassembler
+type coverageLog map[disasm.Location]bool
+
+// step runs a frame, recording the instructions executed (except the ones
+// of the boot ROM)
+func (cov coverageLog) step(console *gbc.Console) {
+	frame := console.PPU.FrameCount
+	console.StepUntil(func(c *gbc.Console) bool {
+		if pc := c.CPU.PC; pc < 0x8000 && !c.InBootROM {
+			cov[disasm.Location{Bank: c.Bank(pc), Addr: pc}] = true
+		}
+		return c.PPU.FrameCount != frame
+	})
+}
+
+func (cov coverageLog) save(path string) error {
+	locs := make([]disasm.Location, 0, len(cov))
+	for loc := range cov {
+		locs = append(locs, loc)
+	}
+	return os.WriteFile(path, disasm.FormatCoverage(locs), 0644)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+// wavWriter collects the audio samples and stores them as a 16 bit stereo
+// PCM WAV file
+type wavWriter struct {
+	rate    int
+	samples []int16
+}
+
+func (w *wavWriter) addSample(l, r int8) {
+	w.samples = append(w.samples, int16(l)<<8, int16(r)<<8)
+}
+
+func (w *wavWriter) save(path string) error {
+	const channels, bitsPerSample = 2, 16
+	dataSize := len(w.samples) * 2
+
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(36 + dataSize),
+		[4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16),
+		uint16(1), // PCM
+		uint16(channels),
+		uint32(w.rate),
+		uint32(w.rate * channels * bitsPerSample / 8),
+		uint16(channels * bitsPerSample / 8),
+		uint16(bitsPerSample),
+		[4]byte{'d', 'a', 't', 'a'},
+		uint32(dataSize),
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	for _, field := range header {
+		if err := binary.Write(f, binary.LittleEndian, field); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := binary.Write(f, binary.LittleEndian, w.samples); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"borzGBC/pkg/gbc"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+type nullFrontend struct{}
+
+func (f nullFrontend) SetPixel(x, y int, c uint32)                {}
+func (f nullFrontend) CommitScreen()                              {}
+func (f nullFrontend) NotifyAudioSample(l, r int8)                {}
+func (f nullFrontend) ExchangeSerial(sb, sc uint8) (uint8, uint8) { return 0, 0 }
+
+type savReport struct {
+	Path      string
+	Size      int
+	Matches   bool
+	Error     string          `json:",omitempty"`
+	RTC       *gbc.RTCFooter  `json:",omitempty"`
+	HuC3Clock *gbc.HuC3Footer `json:",omitempty"`
+}
+
+// The states do not record the ROM they were saved with: a state that
+// decodes may still belong to another ROM with the same mapper
+type stateReport struct {
+	Path    string
+	Size    int
+	Decodes bool
+	Error   string `json:",omitempty"`
+}
+
+type romReport struct {
+	Path     string
+	Size     int
+	SHA1     string
+	CRC32    string
+	Info     *gbc.CartridgeInfo
+	Warnings []string     `json:",omitempty"`
+	Error    string       `json:",omitempty"`
+	Sav      *savReport   `json:",omitempty"`
+	State    *stateReport `json:",omitempty"`
+}
+
+func inspectSav(console *gbc.Console, info *gbc.CartridgeInfo, path string) *savReport {
+	res := &savReport{Path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Size = len(data)
+
+	if len(data) > info.RAMSize {
+		footer := data[info.RAMSize:]
+		switch info.CartridgeType {
+		case 0x0F, 0x10: // MBC3+TIMER
+			res.RTC, _ = gbc.ParseRTCFooter(footer)
+		case 0xFE: // HuC3
+			res.HuC3Clock, _ = gbc.ParseHuC3Footer(footer)
+		}
+	}
+
+	if console == nil {
+		res.Error = "unable to load the ROM"
+		return res
+	}
+	if err := console.LoadSav(data); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Matches = true
+	return res
+}
+
+func inspectState(console *gbc.Console, path string) (res *stateReport) {
+	res = &stateReport{Path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Size = len(data)
+
+	if console == nil {
+		res.Error = "unable to load the ROM"
+		return res
+	}
+	// A state saved with another ROM can confuse the decoder
+	defer func() {
+		if r := recover(); r != nil {
+			res.Decodes = false
+			res.Error = fmt.Sprintf("%v", r)
+		}
+	}()
+	if err := console.LoadState(data); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Decodes = true
+	return res
+}
+
+func inspectROM(path, savPath, statePath string) (*romReport, error) {
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sha := sha1.Sum(rom)
+	res := &romReport{
+		Path:  path,
+		Size:  len(rom),
+		SHA1:  hex.EncodeToString(sha[:]),
+		CRC32: fmt.Sprintf("%08x", crc32.ChecksumIEEE(rom)),
+	}
+	res.Info, err = gbc.ReadCartridgeInfo(rom)
+	if err != nil {
+		return nil, err
+	}
+
+	console, err := gbc.MakeConsole(rom, nullFrontend{})
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		for _, warning := range console.LoadReport.Warnings {
+			res.Warnings = append(res.Warnings, warning.String())
+		}
+	}
+
+	if savPath != "" {
+		res.Sav = inspectSav(console, res.Info, savPath)
+	}
+	if statePath != "" {
+		res.State = inspectState(console, statePath)
+	}
+	return res, nil
+}
+
+func yesNo(v bool) string {
+	if v {
+		return "yes"
+	}
+	return "no"
+}
+
+func validity(v bool) string {
+	if v {
+		return "OK"
+	}
+	return "INVALID"
+}
+
+func printReport(r *romReport) {
+	info := r.Info
+	fmt.Printf("File:             %s\n", filepath.Base(r.Path))
+	fmt.Printf("Size:             %d bytes\n", r.Size)
+	fmt.Printf("SHA-1:            %s\n", r.SHA1)
+	fmt.Printf("CRC32:            %s\n", r.CRC32)
+	fmt.Printf("Title:            %s\n", info.Title)
+	if info.ManufacturerCode != "" {
+		fmt.Printf("Manufacturer:     %s\n", info.ManufacturerCode)
+	}
+	fmt.Printf("Licensee:         %s\n", info.Licensee)
+	fmt.Printf("CGB:              %s (0x%02x)", yesNo(info.CGBSupport), info.CGBFlag)
+	if info.CGBOnly {
+		fmt.Printf(", CGB only")
+	}
+	fmt.Println()
+	fmt.Printf("SGB:              %s\n", yesNo(info.SGBSupport))
+	fmt.Printf("Cartridge type:   0x%02x %s (supported: %s)\n",
+		info.CartridgeType, info.MapperName, yesNo(info.MapperSupported))
+	fmt.Printf("ROM size:         %d KB\n", info.ROMSize/1024)
+	fmt.Printf("RAM size:         %d bytes\n", info.RAMSize)
+	fmt.Printf("Region:           %s\n", info.Region)
+	fmt.Printf("Version:          %d\n", info.Version)
+	fmt.Printf("Nintendo logo:    %s\n", validity(info.LogoValid))
+	fmt.Printf("Header checksum:  0x%02x %s\n", info.HeaderChecksum, validity(info.HeaderChecksumValid))
+	fmt.Printf("Global checksum:  0x%04x %s\n", info.GlobalChecksum, validity(info.GlobalChecksumValid))
+	for _, warning := range r.Warnings {
+		fmt.Printf("Warning:          %s\n", warning)
+	}
+	if r.Error != "" {
+		fmt.Printf("Error:            %s\n", r.Error)
+	}
+
+	if r.Sav != nil {
+		fmt.Println()
+		fmt.Printf("SAV:              %s\n", filepath.Base(r.Sav.Path))
+		fmt.Printf("Size:             %d bytes\n", r.Sav.Size)
+		if r.Sav.RTC != nil {
+			fmt.Printf("RTC:              %s\n", r.Sav.RTC)
+		}
+		if r.Sav.HuC3Clock != nil {
+			fmt.Printf("HuC3 clock:       %s\n", r.Sav.HuC3Clock)
+		}
+		fmt.Printf("Matches the ROM:  %s\n", yesNo(r.Sav.Matches))
+		if r.Sav.Error != "" {
+			fmt.Printf("Error:            %s\n", r.Sav.Error)
+		}
+	}
+
+	if r.State != nil {
+		fmt.Println()
+		fmt.Printf("State:            %s\n", filepath.Base(r.State.Path))
+		fmt.Printf("Size:             %d bytes\n", r.State.Size)
+		fmt.Printf("Decodes:          %s\n", yesNo(r.State.Decodes))
+		if r.State.Error != "" {
+			fmt.Printf("Error:            %s\n", r.State.Error)
+		}
+	}
+}
+
+func main() {
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	savPath := flag.String("sav", "", "inspect the given .sav file")
+	statePath := flag.String("state", "", "inspect the given save state")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] rom [rom ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if flag.NArg() > 1 && (*savPath != "" || *statePath != "") {
+		fmt.Println("-sav and -state can be used only with a single ROM")
+		os.Exit(1)
+	}
+
+	reports := make([]*romReport, 0)
+	failed := false
+	for _, path := range flag.Args() {
+		report, err := inspectROM(path, *savPath, *statePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			failed = true
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	if *jsonOutput {
+		data, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	} else {
+		for i, report := range reports {
+			if i > 0 {
+				fmt.Println("----")
+			}
+			printReport(report)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
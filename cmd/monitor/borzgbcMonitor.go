@@ -0,0 +1,77 @@
+package main
+
+import (
+	"borzGBC/pkg/gbc"
+	"borzGBC/pkg/monitor"
+	"borzGBC/pkg/romfile"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+)
+
+// Runs a ROM in the terminal debugger, without video and audio output. The
+// console starts paused, at the first instruction (or at the state loaded
+// with -state)
+
+type nullFrontend struct{}
+
+func (nullFrontend) SetPixel(x, y int, c uint32)                {}
+func (nullFrontend) CommitScreen()                              {}
+func (nullFrontend) NotifyAudioSample(l, r int8)                {}
+func (nullFrontend) ExchangeSerial(sb, sc uint8) (uint8, uint8) { return 0, 0 }
+
+func main() {
+	entry := flag.String("entry", "", "ROM to load from a zip archive (default: the first .gb/.gbc)")
+	statePath := flag.String("state", "", "load a save state before starting")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] rom\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	rom, err := romfile.Load(flag.Arg(0), *entry)
+	if err != nil {
+		log.Fatalf("invalid rom: %s\n", err)
+	}
+	console, err := gbc.MakeConsole(rom, nullFrontend{})
+	if err != nil {
+		log.Fatalf("unable to create the console: %s\n", err)
+	}
+	syms, err := romfile.LoadSymbols(flag.Arg(0))
+	if err == nil && syms != nil {
+		err = console.LoadSymbols(syms)
+	}
+	if err != nil {
+		log.Printf("unable to load the symbols: %s\n", err)
+	}
+	if *statePath != "" {
+		state, err := os.ReadFile(*statePath)
+		if err != nil {
+			log.Fatalf("unable to read the state: %s\n", err)
+		}
+		if err := console.LoadState(state); err != nil {
+			log.Fatalf("unable to load the state: %s\n", err)
+		}
+	}
+
+	dbg := gbc.MakeDebugger(console)
+
+	// Ctrl-C stops the running command, instead of the monitor
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			dbg.Interrupt()
+		}
+	}()
+
+	fmt.Println("borzGBC monitor, type help for the list of commands")
+	monitor.MakeMonitor(dbg, os.Stdin, os.Stdout).Run()
+}
@@ -4,13 +4,20 @@ package main
 
 import (
 	"borzGBC/pkg/gbc"
+	"borzGBC/pkg/gdbstub"
+	"borzGBC/pkg/monitor"
+	"borzGBC/pkg/patch"
+	"borzGBC/pkg/romfile"
 	"bytes"
 	"encoding/binary"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/veandco/go-sdl2/sdl"
@@ -147,7 +154,19 @@ type SDLPlugin struct {
 	fastMode int
 	slowMode bool
 
+	haptic *sdl.Haptic
+
+	// Tilt (MBC7 accelerometer), from keyboard or from the analog stick
+	controller             *sdl.GameController
+	keyTiltX, keyTiltY     float64
+	stickTiltX, stickTiltY float64
+
 	serial *serialSync
+
+	// GDB remote debugging or terminal monitor, if enabled
+	gdb      *gdbstub.Server
+	monitor  *monitor.Monitor
+	debugger *gbc.Debugger
 }
 
 func MakeSDLPlugin(scale int) (*SDLPlugin, error) {
@@ -211,9 +230,75 @@ func MakeSDLPlugin(scale int) (*SDLPlugin, error) {
 	pl.charWidth = int(s.W)
 	s.Free()
 
+	// Rumble (optional)
+	pl.initializeHaptic()
+
+	// Game controller for tilt (optional)
+	pl.initializeController()
+
 	return pl, nil
 }
 
+func (pl *SDLPlugin) initializeHaptic() {
+	n, err := sdl.NumHaptics()
+	if err != nil || n == 0 {
+		return
+	}
+	haptic, err := sdl.HapticOpen(0)
+	if err != nil {
+		return
+	}
+	supported, err := haptic.RumbleSupported()
+	if err != nil || !supported || haptic.RumbleInit() != nil {
+		haptic.Close()
+		return
+	}
+	pl.haptic = haptic
+}
+
+func (pl *SDLPlugin) SetRumble(enabled bool) {
+	if pl.haptic == nil {
+		return
+	}
+	if enabled {
+		pl.haptic.RumblePlay(0.75, sdl.HAPTIC_INFINITY)
+	} else {
+		pl.haptic.RumbleStop()
+	}
+}
+
+func (pl *SDLPlugin) initializeController() {
+	for i := 0; i < sdl.NumJoysticks(); i++ {
+		if sdl.IsGameController(i) {
+			pl.controller = sdl.GameControllerOpen(i)
+			if pl.controller != nil {
+				return
+			}
+		}
+	}
+}
+
+// Tilt in g, the analog stick has priority over the keyboard
+func (pl *SDLPlugin) getTilt() (float64, float64) {
+	if pl.stickTiltX != 0 || pl.stickTiltY != 0 {
+		return pl.stickTiltX, pl.stickTiltY
+	}
+	return pl.keyTiltX, pl.keyTiltY
+}
+
+func axisToTilt(value int16) float64 {
+	// ignore the dead zone of the stick
+	if value > -4000 && value < 4000 {
+		return 0
+	}
+	return float64(value) / 32768
+}
+
+func (pl *SDLPlugin) CPULocked(pc uint16, opcode uint8) {
+	log.Printf("CPU locked by the illegal opcode %02x at %04x\n", opcode, pc)
+	pl.DisplayNotification("CPU locked")
+}
+
 func (pl *SDLPlugin) ExchangeSerial(sb, sc uint8) (uint8, uint8) {
 	if pl.serial != nil && pl.serial.running {
 		pl.serial.txSB <- sb
@@ -247,6 +332,12 @@ func (pl *SDLPlugin) DisplayNotification(text string) {
 }
 
 func (pl *SDLPlugin) Destroy() {
+	if pl.haptic != nil {
+		pl.haptic.Close()
+	}
+	if pl.controller != nil {
+		pl.controller.Close()
+	}
 	pl.renderer.Destroy()
 	pl.window.Destroy()
 	pl.surface.Free()
@@ -510,6 +601,13 @@ func (pl *SDLPlugin) Run(rom string, console *gbc.Console, serialServer string)
 			switch t := event.(type) {
 			case *sdl.QuitEvent:
 				running = false
+			case *sdl.ControllerAxisEvent:
+				switch t.Axis {
+				case sdl.CONTROLLER_AXIS_LEFTX:
+					pl.stickTiltX = axisToTilt(t.Value)
+				case sdl.CONTROLLER_AXIS_LEFTY:
+					pl.stickTiltY = axisToTilt(t.Value)
+				}
 			case *sdl.KeyboardEvent:
 				if t.Repeat != 0 {
 					break
@@ -567,6 +665,10 @@ func (pl *SDLPlugin) Run(rom string, console *gbc.Console, serialServer string)
 						}
 						pl.setTitle()
 					}
+				case sdl.K_F12:
+					if t.State == sdl.PRESSED && pl.serial == nil && pl.gdb == nil {
+						pl.enterMonitor(console)
+					}
 				case sdl.K_m:
 					if t.State == sdl.PRESSED {
 						console.APU.ToggleAudio()
@@ -646,6 +748,28 @@ func (pl *SDLPlugin) Run(rom string, console *gbc.Console, serialServer string)
 					currentInput.LEFT = t.State == sdl.PRESSED
 				case sdl.K_RIGHT:
 					currentInput.RIGHT = t.State == sdl.PRESSED
+
+				// Tilt Keys
+				case sdl.K_i:
+					pl.keyTiltY = 0
+					if t.State == sdl.PRESSED {
+						pl.keyTiltY = -1
+					}
+				case sdl.K_k:
+					pl.keyTiltY = 0
+					if t.State == sdl.PRESSED {
+						pl.keyTiltY = 1
+					}
+				case sdl.K_j:
+					pl.keyTiltX = 0
+					if t.State == sdl.PRESSED {
+						pl.keyTiltX = -1
+					}
+				case sdl.K_l:
+					pl.keyTiltX = 0
+					if t.State == sdl.PRESSED {
+						pl.keyTiltX = 1
+					}
 				}
 			}
 		}
@@ -672,7 +796,15 @@ func (pl *SDLPlugin) Run(rom string, console *gbc.Console, serialServer string)
 		} else {
 			console.Input.BackState = currentInput
 		}
-		ticks := console.Step()
+		console.SetTilt(pl.getTilt())
+		ticks := 0
+		if pl.gdb != nil {
+			ticks = pl.stepGDB()
+		} else if pl.monitor != nil {
+			ticks = pl.stepMonitor()
+		} else {
+			ticks = console.Step()
+		}
 
 		elapsed := time.Since(start)
 		if int(elapsed.Milliseconds()) < console.GetMs(ticks) {
@@ -690,14 +822,179 @@ func (pl *SDLPlugin) Run(rom string, console *gbc.Console, serialServer string)
 	return nil
 }
 
+func (pl *SDLPlugin) startGDB(console *gbc.Console, port int) error {
+	addr := fmt.Sprintf("localhost:%d", port)
+	log.Printf("waiting for GDB on %s\n", addr)
+	pl.debugger = gbc.MakeDebugger(console)
+	server, err := gdbstub.Listen(addr, pl.debugger)
+	if err != nil {
+		pl.debugger.Detach()
+		return err
+	}
+	pl.gdb = server
+	return nil
+}
+
+// stepGDB runs a frame under the control of GDB. While GDB holds the target
+// stopped, it just waits for a frame time
+func (pl *SDLPlugin) stepGDB() int {
+	ticks, err := pl.gdb.Frame()
+	if err != nil {
+		log.Printf("GDB disconnected\n")
+		pl.gdb.Close()
+		pl.debugger.Detach()
+		pl.gdb = nil
+		return 0
+	}
+	if ticks == 0 {
+		sdl.Delay(16)
+	}
+	return ticks
+}
+
+// enterMonitor pauses the game and reads debugger commands from the
+// terminal. The breakpoints are kept after "continue", until "q"
+func (pl *SDLPlugin) enterMonitor(console *gbc.Console) {
+	if pl.monitor == nil {
+		pl.debugger = gbc.MakeDebugger(console)
+		pl.monitor = monitor.MakeMonitor(pl.debugger, os.Stdin, os.Stdout)
+		pl.monitor.ResumeOnContinue = true
+	}
+	log.Printf("game paused, type the monitor commands on the terminal (help for the list)\n")
+	if !pl.monitor.Run() {
+		pl.debugger.Detach()
+		pl.monitor = nil
+	}
+	sdl.ClearQueuedAudio(pl.audioDevice)
+}
+
+// stepMonitor runs a frame, entering the monitor if a breakpoint is hit
+func (pl *SDLPlugin) stepMonitor() int {
+	ev := pl.debugger.RunFrame()
+	if ev.Reason != gbc.StopFrame {
+		pl.monitor.PrintStop(ev)
+		pl.enterMonitor(pl.debugger.Console)
+	}
+	return ev.Ticks
+}
+
+// applySoftPatch looks for a patch next to the ROM ("game.ips" or
+// "game.gb.ips" for "game.gb") and applies it. It returns the path used to
+// key saves and states, so that they are not shared with the original ROM
+func applySoftPatch(romPath string, rom []byte) ([]byte, string, error) {
+	base := strings.TrimSuffix(romPath, filepath.Ext(romPath))
+	for _, ext := range []string{".ips", ".ups", ".bps"} {
+		for _, patchPath := range []string{base + ext, romPath + ext} {
+			data, err := os.ReadFile(patchPath)
+			if err != nil {
+				continue
+			}
+			log.Printf("applying patch %s\n", patchPath)
+			patched, err := patch.Apply(rom, data)
+			if err != nil {
+				return nil, "", err
+			}
+			return patched, patchPath, nil
+		}
+	}
+	return rom, romPath, nil
+}
+
+func (pl *SDLPlugin) showTrack(player *gbc.GBSPlayer) {
+	h := player.GBS.Header
+	pl.window.SetTitle(fmt.Sprintf("BorzGBC - %s (%d/%d)", h.Title, player.Track+1, player.NumTracks()))
+	pl.DisplayNotification(fmt.Sprintf("track %d/%d", player.Track+1, player.NumTracks()))
+}
+
+// RunGBS plays a GBS file, the left and right arrows change track
+func (pl *SDLPlugin) RunGBS(gbs *gbc.GBS, track int) error {
+	player, err := gbc.MakeGBSPlayer(gbs, pl)
+	if err != nil {
+		return err
+	}
+	if track != 0 {
+		if err := player.PlayTrack(track - 1); err != nil {
+			return err
+		}
+	}
+	log.Printf("%s - %s (%s)\n", gbs.Header.Title, gbs.Header.Author, gbs.Header.Copyright)
+	pl.showTrack(player)
+
+	running := true
+	for running {
+		start := time.Now()
+
+		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+			switch t := event.(type) {
+			case *sdl.QuitEvent:
+				running = false
+			case *sdl.KeyboardEvent:
+				if t.Repeat != 0 || t.State != sdl.PRESSED {
+					break
+				}
+				switch t.Keysym.Sym {
+				case sdl.K_q:
+					running = false
+				case sdl.K_LEFT, sdl.K_RIGHT:
+					if t.Keysym.Sym == sdl.K_LEFT {
+						err = player.PrevTrack()
+					} else {
+						err = player.NextTrack()
+					}
+					if err != nil {
+						return err
+					}
+					sdl.ClearQueuedAudio(pl.audioDevice)
+					pl.showTrack(player)
+				case sdl.K_m:
+					player.Console.APU.ToggleAudio()
+					if player.Console.APU.IsMuted() {
+						pl.DisplayNotification("muted")
+					} else {
+						pl.DisplayNotification("unmuted")
+					}
+				case sdl.K_PLUS:
+					player.Console.APU.IncreaseAudio()
+					pl.DisplayNotification(player.Console.APU.GetVolumeString())
+				case sdl.K_MINUS:
+					player.Console.APU.DecreaseAudio()
+					pl.DisplayNotification(player.Console.APU.GetVolumeString())
+				}
+			}
+		}
+
+		ticks := player.Step()
+		// The LCD is off, refresh the screen for the notifications
+		pl.CommitScreen()
+
+		elapsed := time.Since(start)
+		if int(elapsed.Milliseconds()) < player.Console.GetMs(ticks) {
+			sdl.Delay(uint32(player.Console.GetMs(ticks) - int(elapsed.Milliseconds())))
+			for sdl.GetQueuedAudioSize(pl.audioDevice) > uint32(pl.audioSpec.Freq/5) {
+				sdl.Delay(80)
+			}
+		}
+	}
+	return nil
+}
+
 func main() {
-	if len(os.Args) < 2 {
+	entry := flag.String("entry", "", "ROM to load from a zip archive (default: the first .gb/.gbc)")
+	track := flag.Int("track", 0, "track of a GBS file to play, starting from 1 (default: the first track of the file)")
+	gdbPort := flag.Int("gdb", 0, "wait for GDB on this port of localhost")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] rom [remote]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
 		fmt.Println("missing ROM filename")
 		return
 	}
 	remote := ""
-	if len(os.Args) > 2 {
-		remote = os.Args[2]
+	if flag.NArg() > 1 {
+		remote = flag.Arg(1)
 		log.Printf("remote mode, connecting to %s\n", remote)
 	}
 
@@ -708,18 +1005,58 @@ func main() {
 	}
 	defer pl.Destroy()
 
-	romPath := os.Args[1]
-	rom, err := os.ReadFile(romPath)
+	// With archives, saves and states are stored next to the archive
+	romPath := flag.Arg(0)
+	rom, err := romfile.Load(romPath, *entry)
 	if err != nil {
 		log.Printf("invalid rom: %s\n", err)
 		return
 	}
+	rom, savePath, err := applySoftPatch(romPath, rom)
+	if err != nil {
+		log.Printf("unable to apply the patch: %s\n", err)
+		return
+	}
+	if gbc.IsGBS(rom) {
+		gbs, err := gbc.LoadGBS(rom)
+		if err != nil {
+			log.Printf("invalid GBS: %s\n", err)
+			return
+		}
+		if err := pl.RunGBS(gbs, *track); err != nil {
+			log.Printf("unable to play the GBS: %s\n", err)
+		}
+		return
+	}
 	console, err := gbc.MakeConsole(rom, pl)
 	if err != nil {
 		log.Printf("unable to create the console: %s\n", err)
 		return
 	}
-	savFile := fmt.Sprintf("%s.sav", romPath)
+	for _, warning := range console.LoadReport.Warnings {
+		log.Printf("warning: %s\n", warning)
+	}
+	// In-game time follows the emulation speed (e.g., in fast mode)
+	console.SetClock(gbc.MakeEmulatedClock(time.Now().Unix()))
+	// Pocket Camera carts capture a still image, if available
+	cameraFile := fmt.Sprintf("%s.camera.png", romPath)
+	if cameraImage, err := os.ReadFile(cameraFile); err == nil {
+		source, err := gbc.MakePNGCameraSource(cameraImage)
+		if err != nil {
+			log.Printf("unable to load camera image: %s\n", err)
+			return
+		}
+		console.SetCameraSource(source)
+	}
+	// Labels for the debugger, from the symbol file next to the ROM
+	syms, err := romfile.LoadSymbols(romPath)
+	if err == nil && syms != nil {
+		err = console.LoadSymbols(syms)
+	}
+	if err != nil {
+		log.Printf("unable to load the symbols: %s\n", err)
+	}
+	savFile := fmt.Sprintf("%s.sav", savePath)
 	sav, err := os.ReadFile(savFile)
 	if err == nil {
 		err = console.LoadSav(sav)
@@ -732,7 +1069,13 @@ func main() {
 	console.Verbose = false
 	console.CPU.EnableDisas = false
 	console.PrintDebug = false
-	err = pl.Run(romPath, console, remote)
+	if *gdbPort != 0 {
+		if err := pl.startGDB(console, *gdbPort); err != nil {
+			log.Printf("unable to start the GDB server: %s\n", err)
+			return
+		}
+	}
+	err = pl.Run(savePath, console, remote)
 	if err != nil {
 		log.Printf("unable to run the emulator: %s\n", err)
 	}
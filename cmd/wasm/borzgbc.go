@@ -48,6 +48,10 @@ func (f *jsFrontend) ExchangeSerial(sb, sc uint8) (uint8, uint8) {
 	return 0, 0
 }
 
+func (f *jsFrontend) CPULocked(pc uint16, opcode uint8) {
+	fmt.Printf("CPU locked by the illegal opcode %02x at %04x\n", opcode, pc)
+}
+
 func base64Decode(str string) (string, bool) {
 	data, err := base64.StdEncoding.DecodeString(str)
 	if err != nil {
@@ -82,6 +86,9 @@ func emulator_init(this js.Value, args []js.Value) interface{} {
 		fmt.Printf("!Err: gbc.MakeConsole failed [%s]\n", err)
 		return 0
 	}
+	for _, warning := range console.LoadReport.Warnings {
+		fmt.Printf("!Warn: %s\n", warning)
+	}
 	gPl.console = console
 	return unsafe.Pointer(&gPl.img)
 }
@@ -126,6 +133,32 @@ func emulator_notify_input(this js.Value, args []js.Value) interface{} {
 	return true
 }
 
+func emulator_set_tilt(this js.Value, args []js.Value) interface{} {
+	if gPl == nil || len(args) != 2 {
+		return false
+	}
+
+	gPl.console.SetTilt(args[0].Float(), args[1].Float())
+	return true
+}
+
+// The frame is a base64 encoded 128x112 grayscale image
+func emulator_set_camera_frame(this js.Value, args []js.Value) interface{} {
+	if gPl == nil || len(args) != 1 {
+		return false
+	}
+
+	data, err := base64.StdEncoding.DecodeString(args[0].String())
+	if err != nil || len(data) != gbc.CameraWidth*gbc.CameraHeight {
+		fmt.Printf("!Err invalid camera frame\n")
+		return false
+	}
+	gPl.console.SetCameraSource(gbc.FuncCameraSource(func() []uint8 {
+		return data
+	}))
+	return true
+}
+
 func emulator_load_sav(this js.Value, args []js.Value) interface{} {
 	if gPl == nil || len(args) == 0 {
 		return false
@@ -164,6 +197,8 @@ func main() {
 	js.Global().Set("emulator_notify_input", js.FuncOf(emulator_notify_input))
 	js.Global().Set("emulator_start_timer", js.FuncOf(emulator_start_timer))
 	js.Global().Set("emulator_end_timer", js.FuncOf(emulator_end_timer))
+	js.Global().Set("emulator_set_tilt", js.FuncOf(emulator_set_tilt))
+	js.Global().Set("emulator_set_camera_frame", js.FuncOf(emulator_set_camera_frame))
 	js.Global().Set("emulator_load_sav", js.FuncOf(emulator_load_sav))
 	js.Global().Set("emulator_store_sav", js.FuncOf(emulator_store_sav))
 
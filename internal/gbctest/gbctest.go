@@ -0,0 +1,46 @@
+// Package gbctest builds consoles running small synthetic ROMs, for the
+// tests of the debugger frontends
+package gbctest
+
+import (
+	"borzGBC/pkg/gbc"
+	"math/bits"
+)
+
+// Frontend discards the video, the audio and the serial output
+type Frontend struct{}
+
+func (Frontend) SetPixel(x, y int, c uint32)                {}
+func (Frontend) CommitScreen()                              {}
+func (Frontend) NotifyAudioSample(l, r int8)                {}
+func (Frontend) ExchangeSerial(sb, sc uint8) (uint8, uint8) { return 0, 0 }
+
+// MakeROM builds a ROM of the given cartridge type and number of 16KB banks
+// (a power of two), with a valid header. The entry point jumps to code,
+// copied at 0x150
+func MakeROM(cartType uint8, banks int, code []byte) []byte {
+	rom := make([]byte, banks*0x4000)
+	copy(rom[0x100:], []byte{0x00, 0xC3, 0x50, 0x01}) // jp 0x150
+	copy(rom[0x104:], gbc.NintendoLogo[:])
+	rom[0x147] = cartType
+	rom[0x148] = uint8(bits.Len(uint(banks)) - 2)
+	checksum := uint8(0)
+	for _, b := range rom[0x134:0x14D] {
+		checksum = checksum - b - 1
+	}
+	rom[0x14D] = checksum
+	copy(rom[0x150:], code)
+	return rom
+}
+
+// MakeConsole creates a console running rom, past the boot ROM
+func MakeConsole(rom []byte) (*gbc.Console, error) {
+	cons, err := gbc.MakeConsole(rom, Frontend{})
+	if err != nil {
+		return nil, err
+	}
+	cons.InBootROM = false
+	cons.CPU.PC = 0x100
+	cons.CPU.SP = 0xFFFE
+	return cons, nil
+}
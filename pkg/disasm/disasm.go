@@ -0,0 +1,466 @@
+package disasm
+
+import (
+	"borzGBC/pkg/gbc"
+	"borzGBC/pkg/z80cpu"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A recursive-descent disassembler of whole ROMs. The code is found by
+// following the jumps and the calls from the entry point, the interrupt
+// and RST vectors and the addresses added with AddEntry (e.g. the ones
+// executed in a coverage log), the rest of the ROM is data. The output is
+// RGBDS assembly that re-assembles to the same ROM (with RGBDS 0.6 or
+// later, without the optimizations of rgbasm):
+//
+//	rgbasm -o game.o game.asm
+//	rgblink -o game.gb game.o
+//
+// The bank mapped by the code in bank 0 is tracked on the usual
+// "ld a,BANK; ld [$2000],a" sequence, the targets in unknown banks are
+// not followed
+
+const bankSize = 0x4000
+
+type DisasmError string
+
+func (err DisasmError) Error() string {
+	return string(err)
+}
+
+// Location is an address in a ROM bank, as in the symbol files
+type Location struct {
+	Bank int
+	Addr uint16
+}
+
+func (loc Location) String() string {
+	return fmt.Sprintf("%02x:%04x", loc.Bank, loc.Addr)
+}
+
+// The kinds of the bytes of the ROM
+const (
+	byteData    = iota
+	byteCode    // The first byte of an instruction
+	byteOperand // The other bytes of an instruction
+	byteHeader  // The cartridge header, never code
+)
+
+// The entry points with a name, the RST and interrupt vectors are walked
+// only if they are not filled with 0x00 or 0xFF
+var vectors = []struct {
+	addr uint16
+	name string
+}{
+	{0x100, "Boot"},
+	{0x00, "RST_00"}, {0x08, "RST_08"}, {0x10, "RST_10"}, {0x18, "RST_18"},
+	{0x20, "RST_20"}, {0x28, "RST_28"}, {0x30, "RST_30"}, {0x38, "RST_38"},
+	{0x40, "VBlank"}, {0x48, "LCDStat"}, {0x50, "Timer"}, {0x58, "Serial"},
+	{0x60, "Joypad"},
+}
+
+type entry struct {
+	loc     Location
+	romBank int // The bank mapped in 0x4000-0x7FFF, -1 if unknown
+}
+
+type Disassembler struct {
+	ROM []byte
+
+	// Labels of the symbol file, used instead of the generated ones
+	Symbols *gbc.SymbolTable
+
+	kind    []uint8
+	labels  map[int]string
+	targets map[int]int // Instruction offset -> offset of its ROM operand
+	queue   []entry
+}
+
+func MakeDisassembler(rom []byte) (*Disassembler, error) {
+	if len(rom) < 2*bankSize || len(rom)%bankSize != 0 {
+		return nil, DisasmError("The size of the ROM is not a multiple of 16KB")
+	}
+	d := &Disassembler{
+		ROM:     rom,
+		kind:    make([]uint8, len(rom)),
+		labels:  make(map[int]string),
+		targets: make(map[int]int),
+	}
+	for off := 0x104; off < 0x150; off++ {
+		d.kind[off] = byteHeader
+	}
+	return d, nil
+}
+
+func (d *Disassembler) numBanks() int {
+	return len(d.ROM) / bankSize
+}
+
+// offset returns the offset in the ROM of loc, if loc is in the ROM
+func (d *Disassembler) offset(loc Location) (int, bool) {
+	if loc.Addr >= 0x8000 || loc.Bank < 0 || loc.Bank >= d.numBanks() ||
+		(loc.Addr < bankSize) != (loc.Bank == 0) {
+		return 0, false
+	}
+	return loc.Bank*bankSize + int(loc.Addr&(bankSize-1)), true
+}
+
+func location(off int) Location {
+	loc := Location{Bank: off / bankSize, Addr: uint16(off % bankSize)}
+	if loc.Bank != 0 {
+		loc.Addr |= bankSize
+	}
+	return loc
+}
+
+// resolve returns the offset in the ROM of addr, referenced by the code at
+// bank, with romBank mapped in 0x4000-0x7FFF
+func (d *Disassembler) resolve(addr uint16, bank, romBank int) (int, bool) {
+	switch {
+	case addr < bankSize:
+		bank = 0
+	case addr >= 0x8000 || bank != 0:
+	case romBank > 0:
+		bank = romBank
+	case d.numBanks() == 2:
+		bank = 1
+	default:
+		return 0, false
+	}
+	return d.offset(Location{bank, addr})
+}
+
+func (d *Disassembler) word(off int) uint16 {
+	return uint16(d.ROM[off+1]) | uint16(d.ROM[off+2])<<8
+}
+
+// instruction decodes the instruction at off
+func (d *Disassembler) instruction(off int) (z80cpu.Instruction, error) {
+	end := (off/bankSize + 1) * bankSize
+	if off+3 < end {
+		end = off + 3
+	}
+	return z80cpu.Decode(location(off).Addr, d.ROM[off:end])
+}
+
+// decode decodes the instruction at off, if it is a valid instruction
+// over bytes not yet classified
+func (d *Disassembler) decode(off int) (z80cpu.Instruction, bool) {
+	in, err := d.instruction(off)
+	if err != nil || in.Illegal {
+		return in, false
+	}
+	if in.Mnemonic == "STOP" && d.ROM[off+1] != 0x00 {
+		// Assembled as 0x10 0x00
+		return in, false
+	}
+	for i := 0; i < in.Length; i++ {
+		if d.kind[off+i] != byteData {
+			return in, false
+		}
+	}
+	return in, true
+}
+
+// AddEntry adds an address where the code is executed
+func (d *Disassembler) AddEntry(loc Location) {
+	d.queue = append(d.queue, entry{loc: loc, romBank: -1})
+}
+
+// Analyze separates the code from the data
+func (d *Disassembler) Analyze() {
+	for _, v := range vectors {
+		if v.addr == 0x100 || d.ROM[v.addr] != 0x00 && d.ROM[v.addr] != 0xFF {
+			d.queue = append(d.queue, entry{loc: Location{0, v.addr}, romBank: -1})
+			d.labels[int(v.addr)] = v.name
+		}
+	}
+	for len(d.queue) > 0 {
+		e := d.queue[0]
+		d.queue = d.queue[1:]
+		d.walk(e.loc, e.romBank)
+	}
+	d.addSymbols()
+}
+
+func (d *Disassembler) walk(loc Location, romBank int) {
+	// The value of A, if it is known
+	a := -1
+	for {
+		off, ok := d.offset(loc)
+		if !ok {
+			return
+		}
+		in, ok := d.decode(off)
+		if !ok {
+			return
+		}
+		d.kind[off] = byteCode
+		for i := 1; i < in.Length; i++ {
+			d.kind[off+i] = byteOperand
+		}
+
+		opcode := d.ROM[off]
+		switch opcode {
+		case 0x3E: // ld a,n8
+			a = int(d.ROM[off+1])
+		case 0xEA: // ld [a16],a
+			if addr := d.word(off); 0x2000 <= addr && addr < 0x4000 && a >= 0 {
+				romBank = a
+				if romBank == 0 {
+					romBank = 1
+				}
+			}
+		case 0xE0: // ldh [h8],a
+		default:
+			a = -1
+		}
+
+		// The address referenced by the instruction
+		addr, hasAddr := in.Target, in.HasTarget
+		for _, op := range in.Operands {
+			if op.Kind == z80cpu.OperandImm16 || op.Kind == z80cpu.OperandAddr16 {
+				addr, hasAddr = uint16(op.Value), true
+			}
+		}
+		target, hasTarget := 0, false
+		if hasAddr {
+			target, hasTarget = d.resolve(addr, loc.Bank, romBank)
+			if hasTarget {
+				d.targets[off] = target
+			}
+		}
+
+		if hasTarget && in.HasTarget {
+			if _, ok := d.labels[target]; !ok {
+				d.labels[target] = labelName(target, in.IsCall())
+			}
+			// The code in bank 0 runs with the bank mapped by its caller
+			targetBank := romBank
+			if loc.Bank != 0 {
+				targetBank = loc.Bank
+			}
+			d.queue = append(d.queue, entry{loc: location(target), romBank: targetBank})
+		}
+		if in.EndsFlow() {
+			return
+		}
+		loc.Addr += uint16(in.Length)
+	}
+}
+
+// labelName generates the label of the target of a jump or of a call
+func labelName(target int, isCall bool) string {
+	for _, v := range vectors {
+		if int(v.addr) == target {
+			return v.name
+		}
+	}
+	prefix := "Jump"
+	if isCall {
+		prefix = "Call"
+	}
+	return fmt.Sprintf("%s_%03x_%04x", prefix, target/bankSize, location(target).Addr)
+}
+
+func symbolName(name string) string {
+	return strings.ReplaceAll(name, ".", "_")
+}
+
+// addSymbols replaces the generated labels with the ones of the symbols
+func (d *Disassembler) addSymbols() {
+	if d.Symbols == nil {
+		return
+	}
+	named := make(map[int]bool)
+	for _, sym := range d.Symbols.Symbols() {
+		loc := Location{sym.Bank, sym.Addr}
+		if loc.Bank == 0 && loc.Addr >= bankSize && d.numBanks() == 2 {
+			// Linked with rgblink -t, without the switchable bank
+			loc.Bank = 1
+		}
+		if off, ok := d.offset(loc); ok && !named[off] {
+			d.labels[off] = symbolName(sym.Name)
+			named[off] = true
+		}
+	}
+}
+
+// ramSymbol returns the label of addr, outside of the ROM
+func (d *Disassembler) ramSymbol(addr uint16) (string, bool) {
+	if d.Symbols == nil {
+		return "", false
+	}
+	for _, bank := range []int{0, 1} {
+		if name, ok := d.Symbols.Label(bank, addr); ok {
+			return symbolName(name), true
+		}
+	}
+	return "", false
+}
+
+// addrName returns the label of the operand addr of the instruction at off
+func (d *Disassembler) addrName(off int, addr uint16) string {
+	if target, ok := d.targets[off]; ok {
+		name, ok := d.labels[target]
+		if ok && d.kind[target] != byteOperand && location(target).Addr == addr {
+			return name
+		}
+	}
+	if addr >= 0x8000 {
+		if name, ok := d.ramSymbol(addr); ok {
+			return name
+		}
+	}
+	return fmt.Sprintf("$%04x", addr)
+}
+
+// format returns the instruction at off in RGBDS syntax, and its size
+func (d *Disassembler) format(off int) (string, int) {
+	opcode := d.ROM[off]
+	in, _ := d.instruction(off)
+	res := opcodes[opcode]
+	switch {
+	case opcode == 0xCB:
+		res = cbOpcode(d.ROM[off+1])
+	case strings.Contains(res, "n16"):
+		res = strings.Replace(res, "n16", d.addrName(off, d.word(off)), 1)
+	case strings.Contains(res, "a16"):
+		res = strings.Replace(res, "a16", d.addrName(off, d.word(off)), 1)
+	case strings.Contains(res, "n8"):
+		res = strings.Replace(res, "n8", fmt.Sprintf("$%02x", d.ROM[off+1]), 1)
+	case strings.Contains(res, "e8"):
+		res = strings.Replace(res, "e8", d.addrName(off, in.Target), 1)
+	case strings.Contains(res, "h8"):
+		res = strings.Replace(res, "h8", d.addrName(off, 0xFF00|uint16(d.ROM[off+1])), 1)
+	case strings.Contains(res, "s8"):
+		res = strings.Replace(res, "s8", strconv.Itoa(int(int8(d.ROM[off+1]))), 1)
+		res = strings.Replace(res, "+-", "-", 1)
+	}
+	return res, in.Length
+}
+
+// CodeSize returns the number of bytes of code found by Analyze
+func (d *Disassembler) CodeSize() int {
+	res := 0
+	for _, k := range d.kind {
+		if k == byteCode || k == byteOperand {
+			res += 1
+		}
+	}
+	return res
+}
+
+// hasLabel reports whether a label is emitted at off
+func (d *Disassembler) hasLabel(off int) bool {
+	_, ok := d.labels[off]
+	return ok && d.kind[off] != byteOperand
+}
+
+// writeData writes the data from off, up to end or to the following label
+// or code, and returns the offset after it. The runs of the same byte are
+// filled with ds
+func (d *Disassembler) writeData(out io.Writer, off, end int) int {
+	n := 1
+	for off+n < end && d.kind[off+n] != byteCode && !d.hasLabel(off+n) && d.ROM[off+n] == d.ROM[off] {
+		n += 1
+	}
+	if n >= 16 {
+		fmt.Fprintf(out, "\tds %d, $%02x\n", n, d.ROM[off])
+		return off + n
+	}
+
+	data := make([]string, 0, 8)
+	for len(data) < 8 && off < end && d.kind[off] != byteCode {
+		if len(data) > 0 && d.hasLabel(off) {
+			break
+		}
+		data = append(data, fmt.Sprintf("$%02x", d.ROM[off]))
+		off += 1
+	}
+	fmt.Fprintf(out, "\tdb %s\n", strings.Join(data, ", "))
+	return off
+}
+
+// Write writes the ROM in RGBDS syntax, with a section for each bank
+func (d *Disassembler) Write(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "; Disassembled by borzGBC: %d banks, %d bytes of code\n", d.numBanks(), d.CodeSize())
+
+	if d.Symbols != nil {
+		defined := make(map[string]bool)
+		for _, sym := range d.Symbols.Symbols() {
+			name := symbolName(sym.Name)
+			if sym.Addr >= 0x8000 && !defined[name] {
+				if len(defined) == 0 {
+					fmt.Fprintln(out)
+				}
+				fmt.Fprintf(out, "DEF %s EQU $%04x\n", name, sym.Addr)
+				defined[name] = true
+			}
+		}
+	}
+
+	for bank := 0; bank < d.numBanks(); bank++ {
+		if bank == 0 {
+			fmt.Fprintf(out, "\nSECTION \"ROM Bank $000\", ROM0[$0000]\n")
+		} else {
+			fmt.Fprintf(out, "\nSECTION \"ROM Bank $%03x\", ROMX[$4000], BANK[$%x]\n", bank, bank)
+		}
+		end := (bank + 1) * bankSize
+		for off := bank * bankSize; off < end; {
+			if d.hasLabel(off) {
+				fmt.Fprintf(out, "\n%s:\n", d.labels[off])
+			}
+			if d.kind[off] != byteCode {
+				off = d.writeData(out, off, end)
+				continue
+			}
+			instr, size := d.format(off)
+			fmt.Fprintf(out, "\t%s\n", instr)
+			off += size
+		}
+	}
+	return out.Flush()
+}
+
+// ParseCoverage parses a coverage log, with a bank:addr of executed code
+// for each line
+func ParseCoverage(data []byte) ([]Location, error) {
+	res := make([]Location, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		bank, addr, found := strings.Cut(line, ":")
+		b, errBank := strconv.ParseUint(bank, 16, 16)
+		a, errAddr := strconv.ParseUint(addr, 16, 16)
+		if !found || errBank != nil || errAddr != nil {
+			return nil, DisasmError(fmt.Sprintf("Invalid location at line %d", n))
+		}
+		res = append(res, Location{Bank: int(b), Addr: uint16(a)})
+	}
+	return res, scanner.Err()
+}
+
+// FormatCoverage formats a coverage log, sorted by bank and address
+func FormatCoverage(locs []Location) []byte {
+	sorted := append([]Location{}, locs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		x, y := sorted[i], sorted[j]
+		return x.Bank < y.Bank || x.Bank == y.Bank && x.Addr < y.Addr
+	})
+	buf := &bytes.Buffer{}
+	for _, loc := range sorted {
+		fmt.Fprintf(buf, "%s\n", loc)
+	}
+	return buf.Bytes()
+}
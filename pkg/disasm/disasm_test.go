@@ -0,0 +1,234 @@
+package disasm
+
+import (
+	"borzGBC/pkg/gbc"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A minimal assembler for the output of Write, with the encodings of
+// opcodes and cbOpcode
+type testAssembler struct {
+	t        *testing.T
+	exact    map[string][]byte
+	patterns []testPattern
+	names    map[string]int
+}
+
+type testPattern struct {
+	re     *regexp.Regexp
+	opcode uint8
+	token  string
+}
+
+func makeTestAssembler(t *testing.T) *testAssembler {
+	as := &testAssembler{t: t, exact: make(map[string][]byte), names: make(map[string]int)}
+	for i, tmpl := range opcodes {
+		token := ""
+		for _, tok := range []string{"n16", "a16", "n8", "e8", "h8", "s8"} {
+			if strings.Contains(tmpl, tok) {
+				token = tok
+			}
+		}
+		switch {
+		case tmpl == "" || i == 0xCB:
+		case i == 0x10:
+			as.exact[tmpl] = []byte{0x10, 0x00}
+		case token == "":
+			as.exact[tmpl] = []byte{uint8(i)}
+		default:
+			re := "^" + strings.Replace(regexp.QuoteMeta(tmpl), token, "(.+)", 1) + "$"
+			as.patterns = append(as.patterns, testPattern{regexp.MustCompile(re), uint8(i), token})
+		}
+	}
+	for i := 0; i < 256; i++ {
+		as.exact[cbOpcode(uint8(i))] = []byte{0xCB, uint8(i)}
+	}
+	return as
+}
+
+func (as *testAssembler) value(s string, final bool) (int, bool) {
+	if strings.HasPrefix(s, "$") {
+		v, err := strconv.ParseInt(s[1:], 16, 32)
+		return int(v), err == nil
+	}
+	if v, err := strconv.Atoi(s); err == nil {
+		return v, true
+	}
+	v, ok := as.names[s]
+	if !ok && final {
+		as.t.Fatalf("undefined symbol %s", s)
+	}
+	return v, ok || !final && regexp.MustCompile(`^[A-Za-z_]\w*$`).MatchString(s)
+}
+
+func (as *testAssembler) encode(line string, addr uint16, final bool) []byte {
+	line = strings.Replace(line, "sp-", "sp+-", 1)
+	if res, ok := as.exact[line]; ok {
+		return res
+	}
+	for _, p := range as.patterns {
+		m := p.re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		v, ok := as.value(m[1], false)
+		if !ok {
+			continue
+		}
+		if final {
+			v, _ = as.value(m[1], true)
+		}
+		switch p.token {
+		case "n16", "a16":
+			return []byte{p.opcode, uint8(v), uint8(v >> 8)}
+		case "e8":
+			return []byte{p.opcode, uint8(v - int(addr) - 2)}
+		}
+		return []byte{p.opcode, uint8(v)}
+	}
+	as.t.Fatalf("unknown instruction \"%s\"", line)
+	return nil
+}
+
+func (as *testAssembler) assemble(src string, size int) []byte {
+	var rom []byte
+	for pass := 0; pass < 2; pass++ {
+		final := pass == 1
+		rom = make([]byte, size)
+		off := 0
+		for _, line := range strings.Split(src, "\n") {
+			if i := strings.IndexByte(line, ';'); i >= 0 {
+				line = line[:i]
+			}
+			line = strings.TrimSpace(line)
+			fields := strings.Fields(strings.ReplaceAll(line, ",", " "))
+			switch {
+			case line == "":
+			case fields[0] == "DEF":
+				as.names[fields[1]], _ = as.value(fields[3], true)
+			case fields[0] == "SECTION":
+				bank := 0
+				if i := strings.Index(line, "BANK[$"); i >= 0 {
+					b, _ := strconv.ParseInt(strings.TrimSuffix(line[i+6:], "]"), 16, 32)
+					bank = int(b)
+				}
+				off = bank * bankSize
+			case strings.HasSuffix(line, ":"):
+				as.names[strings.TrimSuffix(line, ":")] = int(location(off).Addr)
+			case fields[0] == "db":
+				for _, arg := range fields[1:] {
+					v, _ := as.value(arg, true)
+					rom[off] = uint8(v)
+					off += 1
+				}
+			case fields[0] == "ds":
+				n, _ := as.value(fields[1], true)
+				v, _ := as.value(fields[2], true)
+				for i := 0; i < n; i++ {
+					rom[off] = uint8(v)
+					off += 1
+				}
+			default:
+				res := as.encode(line, location(off).Addr, final)
+				copy(rom[off:], res)
+				off += len(res)
+			}
+		}
+	}
+	return rom
+}
+
+// makeTestROM builds a MBC1 cart that calls a function in bank 2, bank 3
+// has code that is never called
+func makeTestROM() []byte {
+	rom := make([]byte, 4*bankSize)
+	for i := range rom {
+		rom[i] = 0xFF
+	}
+	rom[0x08] = 0xC9 // ret
+	rom[0x40] = 0xD9 // reti
+	copy(rom[0x100:], []byte{0x00, 0xC3, 0x50, 0x01})
+	copy(rom[0x104:], gbc.NintendoLogo[:])
+	copy(rom[0x134:], "TEST")
+	rom[0x147] = 0x01 // MBC1
+	rom[0x148] = 0x01 // 4 banks
+	copy(rom[0x150:], []byte{
+		0x3E, 0x02, //       ld a,2
+		0xEA, 0x00, 0x20, // ld [$2000],a
+		0xCD, 0x00, 0x40, // call $4000
+		0xFA, 0x00, 0xC0, // ld a,[$c000]
+		0xE0, 0x80, //       ldh [$ff80],a
+		0xE8, 0xFE, //       add sp,-2
+		0xF8, 0x03, //       ld hl,sp+3
+		0xCF,       //       rst $08
+		0xCB, 0xFF, //       set 7,a
+		0x10, 0x00, //       stop
+		0x18, 0xFE, //       jr @
+		0x01, 0xD3, 0x02, // data
+	})
+	copy(rom[2*bankSize:], []byte{
+		0x21, 0x10, 0x40, // ld hl,$4010
+		0x2A, //             ld a,[hli]
+		0xC9, //             ret
+	})
+	copy(rom[2*bankSize+0x10:], "hello")
+	copy(rom[3*bankSize:], []byte{
+		0x47, // ld b,a
+		0xC9, // ret
+	})
+	return rom
+}
+
+func TestDisassemble(t *testing.T) {
+	rom := makeTestROM()
+	d, err := MakeDisassembler(rom)
+	if err != nil {
+		t.Fatalf("unable to create the disassembler: %s", err)
+	}
+	d.Symbols, _ = gbc.ParseSymbols([]byte("00:0150 Main\n02:4010 Text\n00:c000 wValue\n00:ff80 hTemp.x\n"))
+	coverage, err := ParseCoverage(FormatCoverage([]Location{{3, 0x4000}, {0, 0x150}}))
+	if err != nil || len(coverage) != 2 || coverage[0] != (Location{0, 0x150}) {
+		t.Fatalf("invalid coverage %v: %v", coverage, err)
+	}
+	for _, loc := range coverage {
+		d.AddEntry(loc)
+	}
+	d.Analyze()
+
+	out := &bytes.Buffer{}
+	if err := d.Write(out); err != nil {
+		t.Fatalf("unable to write the disassembly: %s", err)
+	}
+	src := out.String()
+	for _, exp := range []string{
+		"DEF wValue EQU $c000",
+		"\nBoot:\n\tnop\n\tjp Main\n",
+		"\nMain:\n\tld a,$02\n\tld [$2000],a\n\tcall Call_002_4000\n\tld a,[wValue]\n\tldh [hTemp_x],a\n",
+		"\tadd sp,-2\n\tld hl,sp+3\n\trst $08\n\tset 7,a\n\tstop\n",
+		"\nJump_000_0166:\n\tjr Jump_000_0166\n\tdb $01, $d3, $02, $ff",
+		"\nVBlank:\n\treti\n",
+		"\nRST_08:\n\tret\n",
+		"\nCall_002_4000:\n\tld hl,Text\n\tld a,[hli]\n\tret\n",
+		"\nText:\n\tdb $68, $65, $6c, $6c, $6f, $ff",
+		"SECTION \"ROM Bank $003\", ROMX[$4000], BANK[$3]\n\tld b,a\n\tret\n",
+	} {
+		if !strings.Contains(src, exp) {
+			t.Errorf("missing %q in the disassembly", exp)
+		}
+	}
+	if t.Failed() {
+		t.Logf("disassembly:\n%s", src)
+	}
+
+	if res := makeTestAssembler(t).assemble(src, len(rom)); !bytes.Equal(res, rom) {
+		for i := range rom {
+			if res[i] != rom[i] {
+				t.Fatalf("the assembled ROM differs at %s", location(i))
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package disasm
+
+import (
+	"fmt"
+)
+
+// The instructions in RGBDS syntax. The operands are:
+//   - n8, n16: immediate values
+//   - a16: an address, of a jump or of a load
+//   - e8: the target of a relative jump
+//   - h8: an address in 0xFF00-0xFFFF
+//   - s8: a signed offset from SP
+//
+// The empty strings are the illegal opcodes, 0xCB is the prefix of the
+// instructions in cbOpcodes and 0x10 (stop) is followed by 0x00
+
+var opcodes = [256]string{
+	"nop", "ld bc,n16", "ld [bc],a", "inc bc", "inc b", "dec b", "ld b,n8", "rlca", // 00
+	"ld [a16],sp", "add hl,bc", "ld a,[bc]", "dec bc", "inc c", "dec c", "ld c,n8", "rrca", // 08
+	"stop", "ld de,n16", "ld [de],a", "inc de", "inc d", "dec d", "ld d,n8", "rla", // 10
+	"jr e8", "add hl,de", "ld a,[de]", "dec de", "inc e", "dec e", "ld e,n8", "rra", // 18
+	"jr nz,e8", "ld hl,n16", "ld [hli],a", "inc hl", "inc h", "dec h", "ld h,n8", "daa", // 20
+	"jr z,e8", "add hl,hl", "ld a,[hli]", "dec hl", "inc l", "dec l", "ld l,n8", "cpl", // 28
+	"jr nc,e8", "ld sp,n16", "ld [hld],a", "inc sp", "inc [hl]", "dec [hl]", "ld [hl],n8", "scf", // 30
+	"jr c,e8", "add hl,sp", "ld a,[hld]", "dec sp", "inc a", "dec a", "ld a,n8", "ccf", // 38
+	"ld b,b", "ld b,c", "ld b,d", "ld b,e", "ld b,h", "ld b,l", "ld b,[hl]", "ld b,a", // 40
+	"ld c,b", "ld c,c", "ld c,d", "ld c,e", "ld c,h", "ld c,l", "ld c,[hl]", "ld c,a", // 48
+	"ld d,b", "ld d,c", "ld d,d", "ld d,e", "ld d,h", "ld d,l", "ld d,[hl]", "ld d,a", // 50
+	"ld e,b", "ld e,c", "ld e,d", "ld e,e", "ld e,h", "ld e,l", "ld e,[hl]", "ld e,a", // 58
+	"ld h,b", "ld h,c", "ld h,d", "ld h,e", "ld h,h", "ld h,l", "ld h,[hl]", "ld h,a", // 60
+	"ld l,b", "ld l,c", "ld l,d", "ld l,e", "ld l,h", "ld l,l", "ld l,[hl]", "ld l,a", // 68
+	"ld [hl],b", "ld [hl],c", "ld [hl],d", "ld [hl],e", "ld [hl],h", "ld [hl],l", "halt", "ld [hl],a", // 70
+	"ld a,b", "ld a,c", "ld a,d", "ld a,e", "ld a,h", "ld a,l", "ld a,[hl]", "ld a,a", // 78
+	"add a,b", "add a,c", "add a,d", "add a,e", "add a,h", "add a,l", "add a,[hl]", "add a,a", // 80
+	"adc a,b", "adc a,c", "adc a,d", "adc a,e", "adc a,h", "adc a,l", "adc a,[hl]", "adc a,a", // 88
+	"sub b", "sub c", "sub d", "sub e", "sub h", "sub l", "sub [hl]", "sub a", // 90
+	"sbc a,b", "sbc a,c", "sbc a,d", "sbc a,e", "sbc a,h", "sbc a,l", "sbc a,[hl]", "sbc a,a", // 98
+	"and b", "and c", "and d", "and e", "and h", "and l", "and [hl]", "and a", // a0
+	"xor b", "xor c", "xor d", "xor e", "xor h", "xor l", "xor [hl]", "xor a", // a8
+	"or b", "or c", "or d", "or e", "or h", "or l", "or [hl]", "or a", // b0
+	"cp b", "cp c", "cp d", "cp e", "cp h", "cp l", "cp [hl]", "cp a", // b8
+	"ret nz", "pop bc", "jp nz,a16", "jp a16", "call nz,a16", "push bc", "add a,n8", "rst $00", // c0
+	"ret z", "ret", "jp z,a16", "", "call z,a16", "call a16", "adc a,n8", "rst $08", // c8
+	"ret nc", "pop de", "jp nc,a16", "", "call nc,a16", "push de", "sub n8", "rst $10", // d0
+	"ret c", "reti", "jp c,a16", "", "call c,a16", "", "sbc a,n8", "rst $18", // d8
+	"ldh [h8],a", "pop hl", "ldh [c],a", "", "", "push hl", "and n8", "rst $20", // e0
+	"add sp,s8", "jp hl", "ld [a16],a", "", "", "", "xor n8", "rst $28", // e8
+	"ldh a,[h8]", "pop af", "ldh a,[c]", "di", "", "push af", "or n8", "rst $30", // f0
+	"ld hl,sp+s8", "ld sp,hl", "ld a,[a16]", "ei", "", "", "cp n8", "rst $38", // f8
+}
+
+var cbRegisters = []string{"b", "c", "d", "e", "h", "l", "[hl]", "a"}
+
+var cbOperations = []string{"rlc", "rrc", "rl", "rr", "sla", "sra", "swap", "srl"}
+
+func cbOpcode(opcode uint8) string {
+	reg := cbRegisters[opcode&7]
+	bit := (opcode >> 3) & 7
+	switch opcode >> 6 {
+	case 0:
+		return cbOperations[bit] + " " + reg
+	case 1:
+		return fmt.Sprintf("bit %d,%s", bit, reg)
+	case 2:
+		return fmt.Sprintf("res %d,%s", bit, reg)
+	}
+	return fmt.Sprintf("set %d,%s", bit, reg)
+}
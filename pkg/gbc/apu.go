@@ -39,6 +39,9 @@ const (
 	maxFrameBufferLength = 5000
 )
 
+// Rate of the samples passed to Frontend.NotifyAudioSample
+const AudioSampleRate = sampleRate
+
 var sweepTimes = map[byte]float64{
 	1: 7.8 / 1000,
 	2: 15.6 / 1000,
@@ -0,0 +1,180 @@
+package gbc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// Resolution of the images produced by the Pocket Camera
+const (
+	CameraWidth  = 128
+	CameraHeight = 112
+)
+
+// Offset of the captured image in RAM bank 0
+const CameraImageOffset = 0x100
+
+// Pocket Camera registers (A000 - A035 when bank 0x10 is mapped)
+const (
+	cameraRegControl  = 0x00
+	cameraRegGain     = 0x01
+	cameraRegExpHigh  = 0x02
+	cameraRegExpLow   = 0x03
+	cameraRegEdge     = 0x04
+	cameraRegDither   = 0x06
+	cameraRegisters   = 0x36
+	cameraTileBytes   = 16
+	cameraImageLength = CameraWidth * CameraHeight / 4
+)
+
+// CameraSource provides the frames seen by the sensor of the Pocket
+// Camera. CaptureFrame returns a CameraWidth x CameraHeight grayscale
+// image, row by row (0 is black, 255 is white)
+type CameraSource interface {
+	CaptureFrame() []uint8
+}
+
+// FuncCameraSource adapts a function to a CameraSource, e.g., to feed the
+// frames of a webcam handled by the frontend
+type FuncCameraSource func() []uint8
+
+func (f FuncCameraSource) CaptureFrame() []uint8 {
+	return f()
+}
+
+// TestPatternCameraSource generates a moving pattern of gray bars and
+// checkers, used when no other source is available
+type TestPatternCameraSource struct {
+	frame int
+}
+
+func (s *TestPatternCameraSource) CaptureFrame() []uint8 {
+	res := make([]uint8, CameraWidth*CameraHeight)
+	for y := 0; y < CameraHeight; y++ {
+		for x := 0; x < CameraWidth; x++ {
+			var v int
+			if y < CameraHeight/2 {
+				// gradient bars
+				v = ((x + s.frame) % CameraWidth) * 256 / CameraWidth
+			} else if ((x+s.frame)/16+y/16)%2 == 0 {
+				v = 0xE0
+			} else {
+				v = 0x20
+			}
+			res[y*CameraWidth+x] = uint8(v)
+		}
+	}
+	s.frame += 1
+	return res
+}
+
+// ImageCameraSource always captures the same (still) image
+type ImageCameraSource struct {
+	pixels []uint8
+}
+
+func (s *ImageCameraSource) CaptureFrame() []uint8 {
+	return s.pixels
+}
+
+// MakeImageCameraSource scales (nearest neighbour) and converts to
+// grayscale the image
+func MakeImageCameraSource(img image.Image) *ImageCameraSource {
+	bounds := img.Bounds()
+	res := &ImageCameraSource{
+		pixels: make([]uint8, CameraWidth*CameraHeight),
+	}
+	for y := 0; y < CameraHeight; y++ {
+		for x := 0; x < CameraWidth; x++ {
+			sx := bounds.Min.X + x*bounds.Dx()/CameraWidth
+			sy := bounds.Min.Y + y*bounds.Dy()/CameraHeight
+			gray := color.GrayModel.Convert(img.At(sx, sy)).(color.Gray)
+			res.pixels[y*CameraWidth+x] = gray.Y
+		}
+	}
+	return res
+}
+
+func MakePNGCameraSource(data []byte) (*ImageCameraSource, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return MakeImageCameraSource(img), nil
+}
+
+// Duration of a capture in CPU M-cycles
+func cameraCaptureTicks(regs []uint8) int {
+	exposure := int(regs[cameraRegExpHigh])<<8 | int(regs[cameraRegExpLow])
+	res := 32446 + 16*exposure
+	if regs[cameraRegGain]&0x80 == 0 {
+		res += 512
+	}
+	return res
+}
+
+var cameraEdgeRatios = []int{2, 3, 4, 5, 8, 12, 16, 20} // in quarters
+
+// cameraProcess emulates the sensor (exposure and edge enhancement) and
+// the dithering of the cartridge, returning the image as 2bpp tiles
+func cameraProcess(regs []uint8, frame []uint8) []uint8 {
+	exposure := int(regs[cameraRegExpHigh])<<8 | int(regs[cameraRegExpLow])
+	pixel := func(x, y int) int {
+		if x < 0 {
+			x = 0
+		} else if x >= CameraWidth {
+			x = CameraWidth - 1
+		}
+		if y < 0 {
+			y = 0
+		} else if y >= CameraHeight {
+			y = CameraHeight - 1
+		}
+		v := int(frame[y*CameraWidth+x]) * exposure / 0x1000
+		if v > 0xFF {
+			v = 0xFF
+		}
+		return v
+	}
+
+	edgeEnabled := regs[cameraRegEdge]&0x80 != 0
+	edgeRatio := cameraEdgeRatios[(regs[cameraRegEdge]>>4)&7]
+	invert := regs[cameraRegEdge]&0x08 != 0
+
+	res := make([]uint8, cameraImageLength)
+	for y := 0; y < CameraHeight; y++ {
+		for x := 0; x < CameraWidth; x++ {
+			v := pixel(x, y)
+			if edgeEnabled {
+				edge := 4*v - pixel(x-1, y) - pixel(x+1, y) - pixel(x, y-1) - pixel(x, y+1)
+				v += edge * edgeRatio / 4
+			}
+			if invert {
+				v = 0xFF - v
+			}
+
+			// 4x4 matrix of thresholds, three for each pixel
+			thresholds := regs[cameraRegDither+((y%4)*4+x%4)*3:]
+			var c uint8
+			switch {
+			case v < int(thresholds[0]):
+				c = 3
+			case v < int(thresholds[1]):
+				c = 2
+			case v < int(thresholds[2]):
+				c = 1
+			default:
+				c = 0
+			}
+
+			tile := (y/8)*(CameraWidth/8) + x/8
+			off := tile*cameraTileBytes + (y%8)*2
+			bit := uint8(7 - x%8)
+			res[off] |= (c & 1) << bit
+			res[off+1] |= ((c >> 1) & 1) << bit
+		}
+	}
+	return res
+}
@@ -0,0 +1,127 @@
+package gbc
+
+import (
+	"bytes"
+	"testing"
+)
+
+// makeCameraRegs returns the registers with the given exposure and edge
+// register, and the dithering thresholds 0x40, 0x80, 0xC0 for every pixel
+func makeCameraRegs(exposure uint16, edge uint8) []uint8 {
+	regs := make([]uint8, cameraRegisters)
+	regs[cameraRegGain] = 0x80
+	regs[cameraRegExpHigh] = uint8(exposure >> 8)
+	regs[cameraRegExpLow] = uint8(exposure)
+	regs[cameraRegEdge] = edge
+	for i := cameraRegDither; i < cameraRegisters; i += 3 {
+		regs[i], regs[i+1], regs[i+2] = 0x40, 0x80, 0xC0
+	}
+	return regs
+}
+
+func uniformFrame(v uint8) []uint8 {
+	return bytes.Repeat([]uint8{v}, CameraWidth*CameraHeight)
+}
+
+func TestCameraCaptureTicks(t *testing.T) {
+	tests := []struct {
+		gain     uint8
+		exposure uint16
+		exp      int
+	}{
+		{0x80, 0x0000, 32446},
+		{0x00, 0x0000, 32446 + 512},
+		{0x80, 0x1000, 32446 + 16*0x1000},
+		{0x00, 0xFFFF, 32446 + 16*0xFFFF + 512},
+	}
+	for _, test := range tests {
+		regs := makeCameraRegs(test.exposure, 0)
+		regs[cameraRegGain] = test.gain
+		if res := cameraCaptureTicks(regs); res != test.exp {
+			t.Errorf("gain %02x, exposure %04x: %d ticks (exp: %d)", test.gain, test.exposure, res, test.exp)
+		}
+	}
+}
+
+func TestCameraProcess(t *testing.T) {
+	tests := []struct {
+		name     string
+		pixel    uint8
+		exposure uint16
+		edge     uint8
+		color    uint8
+	}{
+		{"black", 0x20, 0x1000, 0x00, 3},
+		{"dark gray", 0x60, 0x1000, 0x00, 2},
+		{"light gray", 0xA0, 0x1000, 0x00, 1},
+		{"white", 0xE0, 0x1000, 0x00, 0},
+		{"half exposure", 0xE0, 0x0800, 0x00, 2},
+		{"saturated", 0x80, 0x4000, 0x00, 0},
+		{"inverted", 0x20, 0x1000, 0x08, 0},
+		{"edges of a uniform image", 0x60, 0x1000, 0xF0, 2},
+	}
+	for _, test := range tests {
+		res := cameraProcess(makeCameraRegs(test.exposure, test.edge), uniformFrame(test.pixel))
+		if len(res) != cameraImageLength {
+			t.Fatalf("%s: the image is %d bytes", test.name, len(res))
+		}
+		// Every row of every tile has the same two bitplanes
+		var exp [2]uint8
+		for plane := 0; plane < 2; plane++ {
+			if (test.color>>plane)&1 != 0 {
+				exp[plane] = 0xFF
+			}
+		}
+		for i := 0; i < len(res); i += 2 {
+			if res[i] != exp[0] || res[i+1] != exp[1] {
+				t.Errorf("%s: tile row %02x %02x at %d (exp: color %d)", test.name, res[i], res[i+1], i, test.color)
+				break
+			}
+		}
+	}
+
+	// A light pixel on a dark gray background is enhanced to white
+	frame := uniformFrame(0x70)
+	frame[8*CameraWidth+8] = 0x90
+	res := cameraProcess(makeCameraRegs(0x1000, 0x80), frame)
+	row := res[(CameraWidth/8+1)*cameraTileBytes:]
+	if row[0] != 0x00 || row[1] != 0x7F {
+		t.Errorf("edge enhanced tile row %02x %02x (exp: 00 7f)", row[0], row[1])
+	}
+}
+
+func TestCameraCapture(t *testing.T) {
+	cons := makeMapperConsole(t, 0xFC, 8, 0x04) // POCKET CAMERA, 16 RAM banks
+	captured := 0
+	cons.SetCameraSource(FuncCameraSource(func() []uint8 {
+		captured += 1
+		return uniformFrame(0x20)
+	}))
+
+	cons.Write(0x0000, 0x0A)
+	cons.Write(0x4000, 0x10)
+	for i, v := range makeCameraRegs(0x0000, 0x00) {
+		cons.Write(0xA000+uint16(i), v)
+	}
+	cons.Write(0xA000, 0x01)
+	if v := cons.Read(0xA000); v != 0x01 {
+		t.Errorf("control=%02x during the capture", v)
+	}
+
+	cons.tickComponents(cameraCaptureTicks(makeCameraRegs(0x0000, 0x00)) - 1)
+	cons.Write(0x4000, 0x00)
+	cons.Write(0xA100, 0x42) // ignored during the capture
+	if v := cons.Read(0xA100); captured != 0 || v != 0x00 {
+		t.Errorf("captured %d frames, RAM=%02x before the end of the capture", captured, v)
+	}
+
+	cons.tickComponents(1)
+	cons.Write(0x4000, 0x10)
+	if v := cons.Read(0xA000); captured != 1 || v != 0x00 {
+		t.Errorf("captured %d frames, control=%02x at the end of the capture", captured, v)
+	}
+	cons.Write(0x4000, 0x00)
+	if v := cons.Read(0xA100 + cameraImageLength - 1); v != 0xFF {
+		t.Errorf("read %02x from the captured image (exp: black)", v)
+	}
+}
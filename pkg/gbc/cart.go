@@ -4,10 +4,18 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 )
 
 const HeaderSize = 0x4A
 
+var NintendoLogo = [48]uint8{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83,
+	0x00, 0x0C, 0x00, 0x0D, 0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E,
+	0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99, 0xBB, 0xBB, 0x67, 0x63,
+	0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
 type Header struct {
 	EntryCode        [4]uint8  // 0100h - 0104h
 	NintendoLogo     [48]uint8 // 0104h - 0133h
@@ -31,7 +39,8 @@ type Cart struct {
 	ROMBanks [][16384]uint8
 	RAMBanks [][8192]uint8
 
-	Map Mapper
+	Map   Mapper
+	clock Clock
 }
 
 func (cart *Cart) Save(encoder *gob.Encoder) {
@@ -50,6 +59,38 @@ func (cart *Cart) Load(decoder *gob.Decoder) error {
 	return nil
 }
 
+func (cart *Cart) storeRAM() []byte {
+	res := make([]byte, 0, len(cart.RAMBanks)*8192)
+	for i := 0; i < len(cart.RAMBanks); i++ {
+		res = append(res, cart.RAMBanks[i][:]...)
+	}
+	return res
+}
+
+// readBanked reads addr (in 0000 - 7FFF or A000 - BFFF) in a bank of
+// ROMBanks or RAMBanks, for the mappers with 16KB ROM and 8KB RAM banks
+func (cart *Cart) readBanked(bank int, addr uint16) uint8 {
+	if addr <= 0x7FFF {
+		return cart.ROMBanks[bank%len(cart.ROMBanks)][addr&0x3FFF]
+	}
+	if len(cart.RAMBanks) == 0 {
+		return 0xFF
+	}
+	return cart.RAMBanks[bank%len(cart.RAMBanks)][addr&0x1FFF]
+}
+
+func (cart *Cart) loadRAM(data []byte) error {
+	if len(data) != len(cart.RAMBanks)*8192 {
+		return CartError("Invalid SAV file")
+	}
+
+	for i := 0; i < len(cart.RAMBanks); i++ {
+		off := i * 8192
+		copy(cart.RAMBanks[i][:], data[off:off+8192])
+	}
+	return nil
+}
+
 type CartError string
 
 func (err CartError) Error() string {
@@ -61,124 +102,300 @@ func getMapper(cart *Cart) (Mapper, error) {
 	case 0x00: // ROM ONLY
 		return ROMOnlyMapper{cart: cart}, nil
 	case 0x01, 0x02, 0x03: // MBC1
-		return MakeMBC1Mapper(cart), nil
-	case 0x05: // MBC2
-		return nil, CartError("Unsupported Mapper MBC2")
-	case 0x06: // MBC2+BATTERY
-		return nil, CartError("Unsupported Mapper MBC2+BATTERY")
-	case 0x08: // ROM+RAM 1
-		return nil, CartError("Unsupported Mapper ROM+RAM 1")
-	case 0x09: // ROM+RAM+BATTERY 1
-		return nil, CartError("Unsupported Mapper ROM+RAM+BATTERY 1")
-	case 0x0B: // MMM01
-		return nil, CartError("Unsupported Mapper MMM01")
-	case 0x0C: // MMM01+RAM
-		return nil, CartError("Unsupported Mapper MMM01+RAM")
-	case 0x0D: // MMM01+RAM+BATTERY
-		return nil, CartError("Unsupported Mapper MMM01+RAM+BATTERY")
+		return MakeMBC1Mapper(cart, isMBC1Multicart(cart)), nil
+	case 0x05, 0x06: // MBC2
+		return MakeMBC2Mapper(cart), nil
+	case 0x08, 0x09: // ROM+RAM (+BATTERY)
+		return ROMOnlyMapper{cart: cart}, nil
+	case 0x0B, 0x0C, 0x0D: // MMM01
+		return MakeMMM01Mapper(cart), nil
 	case 0x0F, 0x10, 0x11, 0x12, 0x13: // MBC3
 		return MakeMBC3Mapper(cart), nil
 	case 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E: // MBC5
 		return MakeMBC5Mapper(cart), nil
 	case 0x20: // MBC6
-		return nil, CartError("Unsupported Mapper MBC6")
+		return MakeMBC6Mapper(cart), nil
 	case 0x22: // MBC7+SENSOR+RUMBLE+RAM+BATTERY
-		return nil, CartError("Unsupported Mapper MBC7+SENSOR+RUMBLE+RAM+BATTERY")
+		return MakeMBC7Mapper(cart), nil
 	case 0xFC: // POCKET CAMERA
-		return nil, CartError("Unsupported Mapper POCKET CAMERA")
+		return MakePocketCameraMapper(cart), nil
 	case 0xFD: // BANDAI TAMA5
-		return nil, CartError("Unsupported Mapper BANDAI TAMA5")
+		return MakeTAMA5Mapper(cart), nil
 	case 0xFE: // HuC3
-		return nil, CartError("Unsupported Mapper HuC3")
+		return MakeHuC3Mapper(cart), nil
 	case 0xFF: // HuC1+RAM+BATTERY
-		return nil, CartError("Unsupported Mapper HuC1+RAM+BATTERY")
+		return MakeHuC1Mapper(cart), nil
 	}
 
 	return nil, CartError("Unexpected CartType")
 }
 
-func LoadCartridge(rom []byte) (*Cart, error) {
-	res := &Cart{}
-	reader := bytes.NewReader(rom)
-
-	// Read Header
-	_, err := reader.Seek(0x0100, 0)
-	if err != nil {
-		return nil, err
+// MBC1 multicarts (MBC1M) are 1MB compilations in which the secondary
+// banking register is wired to ROM bank bits 4-5 instead of 5-6. There is
+// nothing in the header telling them apart from a regular MBC1 cart, so
+// we look for the header of the second game (i.e., the Nintendo logo) at
+// the beginning of bank 0x10
+func isMBC1Multicart(cart *Cart) bool {
+	if len(cart.ROMBanks) != 64 {
+		return false
 	}
-	err = binary.Read(reader, binary.BigEndian, &res.header)
-	if err != nil {
-		return nil, err
-	}
-
-	// Read ROM Banks
-	numROMBanks := 0
-	if res.header.RomSize <= 8 {
-		numROMBanks = 2 * (1 << int(res.header.RomSize))
-	} else if res.header.RomSize == 52 {
-		numROMBanks = 72
-	} else if res.header.RomSize == 53 {
-		numROMBanks = 80
-	} else if res.header.RomSize == 54 {
-		numROMBanks = 96
-	} else {
-		return nil, CartError("Invalid header.RomSize value")
+	return bytes.Equal(cart.ROMBanks[0x10][0x104:0x134], NintendoLogo[:])
+}
+
+func readHeader(rom []byte, off int) (Header, error) {
+	var res Header
+	if off < 0 || len(rom) < off+0x100 {
+		return res, CartError("Unable to read the header: not enough data in file")
 	}
+	err := binary.Read(bytes.NewReader(rom[off+0x100:]), binary.BigEndian, &res)
+	return res, err
+}
 
-	res.ROMBanks = make([][16384]uint8, numROMBanks)
-	_, err = reader.Seek(0, 0)
+// MMM01 carts boot from the last 32KB of the ROM, where the header of
+// the menu is located. The header at the beginning of the file is the one
+// of the first game
+func isMMM01(rom []byte) (Header, bool) {
+	header, err := readHeader(rom, len(rom)-0x8000)
 	if err != nil {
-		return nil, err
+		return header, false
 	}
-	for i := 0; i < numROMBanks; i++ {
-		num, err := reader.Read(res.ROMBanks[i][:])
-		if err != nil {
-			return nil, err
-		}
-		if num != 16384 {
-			return nil, CartError("Unable to read ROMBank: not enough data in file")
-		}
+	if header.CartridgeType < 0x0B || header.CartridgeType > 0x0D {
+		return header, false
 	}
+	return header, header.NintendoLogo == NintendoLogo
+}
 
-	// Create RAM Banks
-	numRAMBanks := 0
-	switch res.header.RamSize {
+// LoadOptions controls how a ROM image is turned into a cartridge
+type LoadOptions struct {
+	// Reject images whose size does not match the header, instead of
+	// reporting a warning
+	Strict bool
+	// Fill the missing part of short images mirroring the available
+	// data (as an undersized ROM chip would do) instead of padding it
+	// with 0xFF
+	Mirror bool
+	// Source of time of the cartridge (RealClock if nil)
+	Clock Clock
+}
+
+type LoadWarningKind int
+
+const (
+	LoadWarningOverdump LoadWarningKind = iota
+	LoadWarningUnderdump
+	LoadWarningInvalidRomSize
+	LoadWarningInvalidRamSize
+	LoadWarningHeaderless
+)
+
+// LoadWarning is a non-fatal issue found while loading a ROM image
+type LoadWarning struct {
+	Kind    LoadWarningKind
+	Message string
+}
+
+func (w LoadWarning) String() string {
+	return w.Message
+}
+
+// LoadReport describes how the ROM image has been loaded
+type LoadReport struct {
+	Warnings []LoadWarning
+
+	FileSize    int // size of the image
+	HeaderBanks int // number of ROM banks according to the header (0 if invalid)
+	ROMBanks    int // number of ROM banks of the cartridge
+}
+
+func (r *LoadReport) warn(kind LoadWarningKind, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, LoadWarning{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Number of ROM banks according to the header, 0 if RomSize is invalid
+func romBanksFromHeader(romSize uint8) int {
+	switch {
+	case romSize <= 8:
+		return 2 * (1 << int(romSize))
+	case romSize == 52:
+		return 72
+	case romSize == 53:
+		return 80
+	case romSize == 54:
+		return 96
+	}
+	return 0
+}
+
+// Number of RAM banks according to the header, -1 if RamSize is invalid
+func ramBanksFromHeader(ramSize uint8) int {
+	switch ramSize {
 	case 0:
-		numRAMBanks = 0
+		return 0
 	case 2:
-		numRAMBanks = 1
+		return 1
 	case 3:
-		numRAMBanks = 4
+		return 4
 	case 4:
-		numRAMBanks = 16
+		return 16
 	case 5:
-		numRAMBanks = 8
-	default:
-		return nil, CartError("Invalid header.RamSize")
+		return 8
 	}
+	return -1
+}
 
-	res.RAMBanks = make([][8192]uint8, numRAMBanks)
+func computeHeaderChecksum(rom []byte) uint8 {
+	checksum := uint8(0)
+	for _, b := range rom[0x134:0x14D] {
+		checksum = checksum - b - 1
+	}
+	return checksum
+}
 
-	// Check if we read the whole file
-	off, err := reader.Seek(0, 1)
-	if err != nil {
-		return nil, err
+// An image is considered headerless if it is too small to contain a
+// header, or if both the logo and the header checksum are wrong
+func isHeaderless(rom []byte) bool {
+	if len(rom) < 0x150 {
+		return true
+	}
+	return !bytes.Equal(rom[0x104:0x134], NintendoLogo[:]) &&
+		computeHeaderChecksum(rom) != rom[0x14D]
+}
+
+// Offset in an image of size bytes from which the byte at offset off of
+// the (bigger) ROM chip is read. The part exceeding the largest power of
+// two is mirrored recursively
+func mirrorOffset(off, size int) int {
+	if off < size {
+		return off
+	}
+	p := 1
+	for p*2 <= size {
+		p *= 2
+	}
+	if p == size {
+		return off % size
+	}
+	return p + mirrorOffset(off-p, size-p)
+}
+
+func LoadCartridge(rom []byte) (*Cart, error) {
+	cart, _, err := LoadCartridgeWithOptions(rom, LoadOptions{})
+	return cart, err
+}
+
+func LoadCartridgeWithOptions(rom []byte, opts LoadOptions) (*Cart, *LoadReport, error) {
+	report := &LoadReport{FileSize: len(rom)}
+	res := &Cart{clock: opts.Clock}
+	if res.clock == nil {
+		res.clock = RealClock{}
+	}
+
+	headerless := isHeaderless(rom) && !(opts.Strict && len(rom) >= 0x150)
+	if headerless {
+		if opts.Strict {
+			return nil, nil, CartError("Unable to read the header: not enough data in file")
+		}
+		report.warn(LoadWarningHeaderless, "no valid header, loading the image as ROM only")
+	} else {
+		var err error
+		res.header, err = readHeader(rom, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		if header, ok := isMMM01(rom); ok {
+			res.header = header
+		}
+	}
+
+	// ROM Banks
+	report.HeaderBanks = romBanksFromHeader(res.header.RomSize)
+	if report.HeaderBanks == 0 && opts.Strict {
+		return nil, nil, CartError("Invalid header.RomSize value")
+	}
+
+	// Number of banks of the file, rounded up to a power of two
+	fileBanks := 2
+	for fileBanks*16384 < len(rom) {
+		fileBanks *= 2
 	}
 
-	if len(rom) != int(off) {
-		return nil, CartError("Unread data at the end of the cartridge")
+	numROMBanks := report.HeaderBanks
+	switch {
+	case headerless:
+		numROMBanks = fileBanks
+	case report.HeaderBanks == 0:
+		numROMBanks = fileBanks
+		report.warn(LoadWarningInvalidRomSize,
+			"invalid RomSize 0x%02x, using %d banks from the file size", res.header.RomSize, numROMBanks)
+	case len(rom) > report.HeaderBanks*16384:
+		if opts.Strict {
+			return nil, nil, CartError("Unread data at the end of the cartridge")
+		}
+		numROMBanks = fileBanks
+		report.warn(LoadWarningOverdump,
+			"image is bigger than the header size (%d > %d bytes), using %d banks",
+			len(rom), report.HeaderBanks*16384, numROMBanks)
+	case len(rom) < report.HeaderBanks*16384:
+		if opts.Strict {
+			return nil, nil, CartError("Unable to read ROMBank: not enough data in file")
+		}
+		numROMBanks = fileBanks
+		fill := "padded"
+		if opts.Mirror {
+			fill = "mirrored"
+		}
+		report.warn(LoadWarningUnderdump,
+			"image is smaller than the header size (%d < %d bytes), %s to %d banks",
+			len(rom), report.HeaderBanks*16384, fill, numROMBanks)
 	}
+	report.ROMBanks = numROMBanks
+
+	res.ROMBanks = make([][16384]uint8, numROMBanks)
+	for i := 0; i < numROMBanks; i++ {
+		bank := res.ROMBanks[i][:]
+		off := i * 16384
+		n := 0
+		if off < len(rom) {
+			n = copy(bank, rom[off:])
+		}
+		for j := n; j < len(bank); j++ {
+			if opts.Mirror && len(rom) > 0 {
+				bank[j] = rom[mirrorOffset(off+j, len(rom))]
+			} else {
+				bank[j] = 0xFF
+			}
+		}
+	}
+
+	// Create RAM Banks
+	numRAMBanks := ramBanksFromHeader(res.header.RamSize)
+	if numRAMBanks < 0 {
+		if opts.Strict {
+			return nil, nil, CartError("Invalid header.RamSize")
+		}
+		// 0x01 was used by a few old carts for 2KB of RAM
+		numRAMBanks = 0
+		if res.header.RamSize == 1 {
+			numRAMBanks = 1
+		}
+		report.warn(LoadWarningInvalidRamSize,
+			"invalid RamSize 0x%02x, using %d RAM banks", res.header.RamSize, numRAMBanks)
+	}
+	res.RAMBanks = make([][8192]uint8, numRAMBanks)
 
 	mapper, err := getMapper(res)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	res.Map = mapper
 
-	return res, nil
+	return res, report, nil
 }
 
 func (cart *Cart) GetGameTitle() string {
-	// FIXME: in older ROMS it should include also 5 bytes after the GameTitle array
-	return string(cart.header.GameTitle[:])
+	title, _ := decodeTitle(&cart.header)
+	return title
 }
@@ -0,0 +1,73 @@
+package gbc
+
+import (
+	"reflect"
+	"testing"
+)
+
+// setHeader changes a byte of the header of rom, fixing the checksum
+func setHeader(rom []byte, off int, value uint8) []byte {
+	rom[off] = value
+	rom[0x14D] = computeHeaderChecksum(rom)
+	return rom
+}
+
+func TestLoadReport(t *testing.T) {
+	underdump := makeTestROM(0x00, 4, 0x00, nil)
+	underdump[0x4000] = 0x42
+	underdump = underdump[:0x5000]
+
+	tests := []struct {
+		name     string
+		rom      []byte
+		mirror   bool
+		warnings []LoadWarningKind
+		romBanks int
+		ramBanks int
+		strictOK bool
+	}{
+		{"valid", makeTestROM(0x00, 2, 0x00, nil), false, nil, 2, 0, true},
+		{"overdump", setHeader(makeTestROM(0x00, 4, 0x00, nil), 0x148, 0x00), false, []LoadWarningKind{LoadWarningOverdump}, 4, 0, false},
+		{"underdump", underdump, false, []LoadWarningKind{LoadWarningUnderdump}, 2, 0, false},
+		{"mirrored underdump", underdump, true, []LoadWarningKind{LoadWarningUnderdump}, 2, 0, false},
+		{"headerless", make([]byte, 0x100), false, []LoadWarningKind{LoadWarningHeaderless}, 2, 0, false},
+		{"invalid rom size", setHeader(makeTestROM(0x00, 2, 0x00, nil), 0x148, 0x20), false, []LoadWarningKind{LoadWarningInvalidRomSize}, 2, 0, false},
+		{"invalid ram size", makeTestROM(0x00, 2, 0x07, nil), false, []LoadWarningKind{LoadWarningInvalidRamSize}, 2, 0, false},
+		{"2KB ram", makeTestROM(0x08, 2, 0x01, nil), false, []LoadWarningKind{LoadWarningInvalidRamSize}, 2, 1, false},
+	}
+	for _, test := range tests {
+		cart, report, err := LoadCartridgeWithOptions(test.rom, LoadOptions{Mirror: test.mirror})
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		var warnings []LoadWarningKind
+		for _, w := range report.Warnings {
+			warnings = append(warnings, w.Kind)
+		}
+		if !reflect.DeepEqual(warnings, test.warnings) {
+			t.Errorf("%s: warnings %v (exp: %v)", test.name, report.Warnings, test.warnings)
+		}
+		if report.FileSize != len(test.rom) || report.ROMBanks != test.romBanks || len(cart.ROMBanks) != test.romBanks {
+			t.Errorf("%s: unexpected report %+v", test.name, report)
+		}
+		if len(cart.RAMBanks) != test.ramBanks {
+			t.Errorf("%s: %d RAM banks (exp: %d)", test.name, len(cart.RAMBanks), test.ramBanks)
+		}
+		if _, _, err := LoadCartridgeWithOptions(test.rom, LoadOptions{Strict: true}); (err == nil) != test.strictOK {
+			t.Errorf("%s: strict loading returned %v", test.name, err)
+		}
+	}
+
+	// The missing part of bank 1 is filled with 0xFF, or read again from
+	// the last 4KB of the image (mirroring 0x4000)
+	for _, test := range []struct {
+		mirror bool
+		exp    uint8
+	}{{false, 0xFF}, {true, 0x42}} {
+		cart, _, _ := LoadCartridgeWithOptions(underdump, LoadOptions{Mirror: test.mirror})
+		if v := cart.ROMBanks[1][0x1000]; v != test.exp {
+			t.Errorf("mirror=%v: read %02x past the end of the image (exp: %02x)", test.mirror, v, test.exp)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package gbc
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// CartridgeInfo is the decoded content of the cartridge header
+type CartridgeInfo struct {
+	Title            string
+	ManufacturerCode string
+	CGBFlag          uint8
+	CGBSupport       bool // the game enhances its graphics on CGB
+	CGBOnly          bool
+	SGBSupport       bool
+	Licensee         string
+	LicenseeCode     string // new licensee code (if the old one is 0x33)
+	OldLicenseeCode  uint8
+	CartridgeType    uint8
+	MapperName       string
+	MapperSupported  bool
+	ROMSize          int // bytes
+	RAMSize          int // bytes (including the memory built in the mapper)
+	Region           string
+	Version          uint8
+
+	HeaderChecksum         uint8
+	ComputedHeaderChecksum uint8
+	HeaderChecksumValid    bool
+	GlobalChecksum         uint16
+	ComputedGlobalChecksum uint16
+	GlobalChecksumValid    bool
+	LogoValid              bool
+}
+
+var mapperNames = map[uint8]string{
+	0x00: "ROM ONLY",
+	0x01: "MBC1",
+	0x02: "MBC1+RAM",
+	0x03: "MBC1+RAM+BATTERY",
+	0x05: "MBC2",
+	0x06: "MBC2+BATTERY",
+	0x08: "ROM+RAM",
+	0x09: "ROM+RAM+BATTERY",
+	0x0B: "MMM01",
+	0x0C: "MMM01+RAM",
+	0x0D: "MMM01+RAM+BATTERY",
+	0x0F: "MBC3+TIMER+BATTERY",
+	0x10: "MBC3+TIMER+RAM+BATTERY",
+	0x11: "MBC3",
+	0x12: "MBC3+RAM",
+	0x13: "MBC3+RAM+BATTERY",
+	0x19: "MBC5",
+	0x1A: "MBC5+RAM",
+	0x1B: "MBC5+RAM+BATTERY",
+	0x1C: "MBC5+RUMBLE",
+	0x1D: "MBC5+RUMBLE+RAM",
+	0x1E: "MBC5+RUMBLE+RAM+BATTERY",
+	0x20: "MBC6",
+	0x22: "MBC7+SENSOR+RUMBLE+RAM+BATTERY",
+	0xFC: "POCKET CAMERA",
+	0xFD: "BANDAI TAMA5",
+	0xFE: "HuC3",
+	0xFF: "HuC1+RAM+BATTERY",
+}
+
+var newLicensees = map[string]string{
+	"00": "None",
+	"01": "Nintendo R&D1",
+	"08": "Capcom",
+	"13": "Electronic Arts",
+	"18": "Hudson Soft",
+	"19": "b-ai",
+	"20": "kss",
+	"22": "pow",
+	"24": "PCM Complete",
+	"25": "san-x",
+	"28": "Kemco Japan",
+	"29": "seta",
+	"30": "Viacom",
+	"31": "Nintendo",
+	"32": "Bandai",
+	"33": "Ocean/Acclaim",
+	"34": "Konami",
+	"35": "Hector",
+	"37": "Taito",
+	"38": "Hudson",
+	"39": "Banpresto",
+	"41": "Ubi Soft",
+	"42": "Atlus",
+	"44": "Malibu",
+	"46": "angel",
+	"47": "Bullet-Proof",
+	"49": "irem",
+	"50": "Absolute",
+	"51": "Acclaim",
+	"52": "Activision",
+	"53": "American sammy",
+	"54": "Konami",
+	"55": "Hi tech entertainment",
+	"56": "LJN",
+	"57": "Matchbox",
+	"58": "Mattel",
+	"59": "Milton Bradley",
+	"60": "Titus",
+	"61": "Virgin",
+	"64": "LucasArts",
+	"67": "Ocean",
+	"69": "Electronic Arts",
+	"70": "Infogrames",
+	"71": "Interplay",
+	"72": "Broderbund",
+	"73": "sculptured",
+	"75": "sci",
+	"78": "THQ",
+	"79": "Accolade",
+	"80": "misawa",
+	"83": "lozc",
+	"86": "Tokuma Shoten Intermedia",
+	"87": "Tsukuda Original",
+	"91": "Chunsoft",
+	"92": "Video system",
+	"93": "Ocean/Acclaim",
+	"95": "Varie",
+	"96": "Yonezawa/s'pal",
+	"97": "Kaneko",
+	"99": "Pack in soft",
+	"9H": "Bottom Up",
+	"A4": "Konami (Yu-Gi-Oh!)",
+}
+
+var oldLicensees = map[uint8]string{
+	0x00: "None",
+	0x01: "Nintendo",
+	0x08: "Capcom",
+	0x09: "Hot-B",
+	0x0A: "Jaleco",
+	0x0B: "Coconuts Japan",
+	0x0C: "Elite Systems",
+	0x13: "Electronic Arts",
+	0x18: "Hudson Soft",
+	0x19: "ITC Entertainment",
+	0x1A: "Yanoman",
+	0x1D: "Japan Clary",
+	0x1F: "Virgin",
+	0x24: "PCM Complete",
+	0x25: "San-X",
+	0x28: "Kotobuki Systems",
+	0x29: "Seta",
+	0x30: "Infogrames",
+	0x31: "Nintendo",
+	0x32: "Bandai",
+	0x34: "Konami",
+	0x35: "HectorSoft",
+	0x38: "Capcom",
+	0x39: "Banpresto",
+	0x3C: "Entertainment i",
+	0x3E: "Gremlin",
+	0x41: "Ubi Soft",
+	0x42: "Atlus",
+	0x44: "Malibu",
+	0x46: "Angel",
+	0x47: "Spectrum Holoby",
+	0x49: "Irem",
+	0x4A: "Virgin",
+	0x4D: "Malibu",
+	0x4F: "U.S. Gold",
+	0x50: "Absolute",
+	0x51: "Acclaim",
+	0x52: "Activision",
+	0x53: "American Sammy",
+	0x54: "GameTek",
+	0x55: "Park Place",
+	0x56: "LJN",
+	0x57: "Matchbox",
+	0x59: "Milton Bradley",
+	0x5A: "Mindscape",
+	0x5B: "Romstar",
+	0x5C: "Naxat Soft",
+	0x5D: "Tradewest",
+	0x60: "Titus",
+	0x61: "Virgin",
+	0x67: "Ocean",
+	0x69: "Electronic Arts",
+	0x6E: "Elite Systems",
+	0x6F: "Electro Brain",
+	0x70: "Infogrames",
+	0x71: "Interplay",
+	0x72: "Broderbund",
+	0x73: "Sculptered Soft",
+	0x75: "The Sales Curve",
+	0x78: "THQ",
+	0x79: "Accolade",
+	0x7A: "Triffix Entertainment",
+	0x7C: "Microprose",
+	0x7F: "Kemco",
+	0x80: "Misawa Entertainment",
+	0x83: "Lozc",
+	0x86: "Tokuma Shoten Intermedia",
+	0x8B: "Bullet-Proof Software",
+	0x8C: "Vic Tokai",
+	0x8E: "Ape",
+	0x8F: "I'Max",
+	0x91: "Chunsoft",
+	0x92: "Video System",
+	0x93: "Tsubaraya Productions",
+	0x95: "Varie",
+	0x96: "Yonezawa/S'Pal",
+	0x97: "Kaneko",
+	0x99: "Arc",
+	0x9A: "Nihon Bussan",
+	0x9B: "Tecmo",
+	0x9C: "Imagineer",
+	0x9D: "Banpresto",
+	0x9F: "Nova",
+	0xA1: "Hori Electric",
+	0xA2: "Bandai",
+	0xA4: "Konami",
+	0xA6: "Kawada",
+	0xA7: "Takara",
+	0xA9: "Technos Japan",
+	0xAA: "Broderbund",
+	0xAC: "Toei Animation",
+	0xAD: "Toho",
+	0xAF: "Namco",
+	0xB0: "Acclaim",
+	0xB1: "ASCII or Nexsoft",
+	0xB2: "Bandai",
+	0xB4: "Square Enix",
+	0xB6: "HAL Laboratory",
+	0xB7: "SNK",
+	0xB9: "Pony Canyon",
+	0xBA: "Culture Brain",
+	0xBB: "Sunsoft",
+	0xBD: "Sony Imagesoft",
+	0xBF: "Sammy",
+	0xC0: "Taito",
+	0xC2: "Kemco",
+	0xC3: "Squaresoft",
+	0xC4: "Tokuma Shoten Intermedia",
+	0xC5: "Data East",
+	0xC6: "Tonkinhouse",
+	0xC8: "Koei",
+	0xC9: "UFL",
+	0xCA: "Ultra",
+	0xCB: "Vap",
+	0xCC: "Use Corporation",
+	0xCD: "Meldac",
+	0xCE: "Pony Canyon",
+	0xCF: "Angel",
+	0xD0: "Taito",
+	0xD1: "Sofel",
+	0xD2: "Quest",
+	0xD3: "Sigma Enterprises",
+	0xD4: "ASK Kodansha",
+	0xD6: "Naxat Soft",
+	0xD7: "Copya System",
+	0xD9: "Banpresto",
+	0xDA: "Tomy",
+	0xDB: "LJN",
+	0xDD: "NCS",
+	0xDE: "Human",
+	0xDF: "Altron",
+	0xE0: "Jaleco",
+	0xE1: "Towa Chiki",
+	0xE2: "Yutaka",
+	0xE3: "Varie",
+	0xE5: "Epoch",
+	0xE7: "Athena",
+	0xE8: "Asmik",
+	0xE9: "Natsume",
+	0xEA: "King Records",
+	0xEB: "Atlus",
+	0xEC: "Epic/Sony Records",
+	0xEE: "IGS",
+	0xF0: "A Wave",
+	0xF3: "Extreme Entertainment",
+	0xFF: "LJN",
+}
+
+func GetMapperName(cartridgeType uint8) string {
+	if name, ok := mapperNames[cartridgeType]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown (0x%02x)", cartridgeType)
+}
+
+// Strip the padding (zeros and spaces) at the end of a header string
+func headerString(data []uint8) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return strings.TrimRight(string(data), " ")
+}
+
+func isManufacturerCode(data []uint8) bool {
+	for _, c := range data {
+		if !(('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// In the oldest carts the title is 16 bytes long, CGB carts use the last
+// byte for the CGB flag and the newest ones also store a manufacturer
+// code in the 4 bytes before. Nothing marks the presence of the code: we
+// assume it is there if the title is terminated before it, or if it
+// looks like a code (e.g., "AAXE") ending with a destination letter
+func decodeTitle(h *Header) (title, manufacturer string) {
+	raw := make([]uint8, 0, 16)
+	raw = append(raw, h.GameTitle[:]...)
+	raw = append(raw, h.ManufacturerCode[:]...)
+	if h.CgbFlag&0x80 == 0 {
+		raw = append(raw, h.CgbFlag)
+		return headerString(raw), ""
+	}
+	code := h.ManufacturerCode[:]
+	if isManufacturerCode(code) &&
+		(h.GameTitle[10] == 0 || strings.IndexByte("EJPDFISU", code[3]) >= 0) {
+		return headerString(h.GameTitle[:]), string(h.ManufacturerCode[:])
+	}
+	return headerString(raw), ""
+}
+
+func decodeLicensee(h *Header) (name, code string) {
+	if h.OldLicenseeCode != 0x33 {
+		if name, ok := oldLicensees[h.OldLicenseeCode]; ok {
+			return name, ""
+		}
+		return fmt.Sprintf("Unknown (0x%02x)", h.OldLicenseeCode), ""
+	}
+	code = string(h.LicenseeCode[:])
+	if name, ok := newLicensees[code]; ok {
+		return name, code
+	}
+	return fmt.Sprintf("Unknown (%q)", code), code
+}
+
+func decodeRAMSize(h *Header) int {
+	switch h.CartridgeType {
+	case 0x05, 0x06:
+		return MBC2RamSize
+	case 0x22:
+		return EEPROMSize
+	case 0xFD:
+		return TAMA5RAMSize
+	}
+	if h.RamSize == 1 {
+		return 2048
+	}
+	banks := ramBanksFromHeader(h.RamSize)
+	if banks < 0 {
+		return 0
+	}
+	return banks * 8192
+}
+
+func computeGlobalChecksum(rom []byte) uint16 {
+	checksum := uint16(0)
+	for i, b := range rom {
+		if i == 0x14E || i == 0x14F {
+			continue
+		}
+		checksum += uint16(b)
+	}
+	return checksum
+}
+
+// headerData starts at the beginning of the bank containing the header
+// (which differs from rom only for MMM01 carts)
+func makeCartridgeInfo(h *Header, headerData, rom []byte) *CartridgeInfo {
+	res := &CartridgeInfo{
+		CGBFlag:         h.CgbFlag,
+		CGBSupport:      h.CgbFlag&0x80 != 0,
+		CGBOnly:         h.CgbFlag == 0xC0,
+		SGBSupport:      h.SgbFlag == 0x03 && h.OldLicenseeCode == 0x33,
+		OldLicenseeCode: h.OldLicenseeCode,
+		CartridgeType:   h.CartridgeType,
+		MapperName:      GetMapperName(h.CartridgeType),
+		ROMSize:         romBanksFromHeader(h.RomSize) * 16384,
+		RAMSize:         decodeRAMSize(h),
+		Region:          "Japan",
+		Version:         h.RomVersionNumber,
+		HeaderChecksum:  h.HeaderChecksum,
+		GlobalChecksum:  h.GlobalChecksum,
+		LogoValid:       h.NintendoLogo == NintendoLogo,
+	}
+	if h.DestinationCode != 0 {
+		res.Region = "Overseas"
+	}
+	res.Title, res.ManufacturerCode = decodeTitle(h)
+	res.Licensee, res.LicenseeCode = decodeLicensee(h)
+
+	// every known cartridge type has a mapper
+	_, res.MapperSupported = mapperNames[h.CartridgeType]
+
+	if len(headerData) >= 0x150 {
+		res.ComputedHeaderChecksum = computeHeaderChecksum(headerData)
+		res.HeaderChecksumValid = res.ComputedHeaderChecksum == h.HeaderChecksum
+	}
+	res.ComputedGlobalChecksum = computeGlobalChecksum(rom)
+	res.GlobalChecksumValid = res.ComputedGlobalChecksum == h.GlobalChecksum
+	return res
+}
+
+// ReadCartridgeInfo decodes the header of a ROM image without loading it.
+// For MMM01 carts the header of the menu is returned
+func ReadCartridgeInfo(rom []byte) (*CartridgeInfo, error) {
+	header, err := readHeader(rom, 0)
+	if err != nil {
+		return nil, err
+	}
+	headerData := rom
+	if mmm01Header, ok := isMMM01(rom); ok {
+		header = mmm01Header
+		headerData = rom[len(rom)-0x8000:]
+	}
+	return makeCartridgeInfo(&header, headerData, rom), nil
+}
+
+// Info decodes the header of the cartridge. The global checksum is
+// computed on the loaded ROM banks
+func (cart *Cart) Info() *CartridgeInfo {
+	rom := make([]byte, 0, len(cart.ROMBanks)*16384)
+	for i := range cart.ROMBanks {
+		rom = append(rom, cart.ROMBanks[i][:]...)
+	}
+	headerData := rom
+	if _, ok := cart.Map.(*MMM01Mapper); ok {
+		headerData = rom[len(rom)-0x8000:]
+	}
+	return makeCartridgeInfo(&cart.header, headerData, rom)
+}
@@ -0,0 +1,92 @@
+package gbc
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func setGlobalChecksum(rom []byte) []byte {
+	binary.BigEndian.PutUint16(rom[0x14E:], computeGlobalChecksum(rom))
+	return rom
+}
+
+// makeInfoROM builds an MBC1 ROM with the given header strings and valid
+// checksums
+func makeInfoROM(fields map[int]string) []byte {
+	rom := makeTestROM(0x03, 4, 0x03, nil)
+	for off, s := range fields {
+		copy(rom[off:], s)
+	}
+	rom[0x14D] = computeHeaderChecksum(rom)
+	return setGlobalChecksum(rom)
+}
+
+func TestReadCartridgeInfo(t *testing.T) {
+	badHeader := makeInfoROM(nil)
+	badHeader[0x14D] += 1
+	setGlobalChecksum(badHeader)
+	badGlobal := makeInfoROM(nil)
+	badGlobal[0x4000] += 1
+
+	tests := []struct {
+		name         string
+		rom          []byte
+		title        string
+		manufacturer string
+		licensee     string
+		headerOK     bool
+		globalOK     bool
+	}{
+		{"old title", makeInfoROM(map[int]string{0x134: "TETRIS", 0x14B: "\x01"}), "TETRIS", "", "Nintendo", true, true},
+		{"16 bytes title", makeInfoROM(map[int]string{0x134: "SUPER MARIOLAND2"}), "SUPER MARIOLAND2", "", "None", true, true},
+		{"manufacturer code", makeInfoROM(map[int]string{0x134: "POKEMON_SLVAAXE\x80"}), "POKEMON_SLV", "AAXE", "None", true, true},
+		{"terminated title", makeInfoROM(map[int]string{0x134: "ZELDA\x00\x00\x00\x00\x00\x00AZ7K\xC0"}), "ZELDA", "AZ7K", "None", true, true},
+		{"new licensee", makeInfoROM(map[int]string{0x144: "01", 0x14B: "\x33"}), "", "", "Nintendo R&D1", true, true},
+		{"bad header checksum", badHeader, "", "", "None", false, true},
+		{"bad global checksum", badGlobal, "", "", "None", true, false},
+	}
+	for _, test := range tests {
+		info, err := ReadCartridgeInfo(test.rom)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if info.Title != test.title || info.ManufacturerCode != test.manufacturer || info.Licensee != test.licensee {
+			t.Errorf("%s: title %q, manufacturer %q, licensee %q", test.name, info.Title, info.ManufacturerCode, info.Licensee)
+		}
+		if info.HeaderChecksumValid != test.headerOK || info.GlobalChecksumValid != test.globalOK {
+			t.Errorf("%s: header checksum %02x (computed: %02x), global checksum %04x (computed: %04x)", test.name,
+				info.HeaderChecksum, info.ComputedHeaderChecksum, info.GlobalChecksum, info.ComputedGlobalChecksum)
+		}
+		if info.MapperName != "MBC1+RAM+BATTERY" || info.ROMSize != 4*16384 || info.RAMSize != 4*8192 {
+			t.Errorf("%s: unexpected info %+v", test.name, info)
+		}
+	}
+
+	if _, err := ReadCartridgeInfo(make([]byte, 0x14F)); err == nil {
+		t.Errorf("truncated header accepted")
+	}
+}
+
+func TestCartridgeInfoMMM01(t *testing.T) {
+	rom := makeTestROM(0x01, 32, 0x00, nil)
+	menu := makeTestROM(0x0D, 32, 0x03, nil)
+	copy(rom[len(rom)-0x8000:], menu[:0x150])
+
+	info, err := ReadCartridgeInfo(rom)
+	if err != nil {
+		t.Fatalf("unable to read the header: %s", err)
+	}
+	// The header and its checksum are the ones of the menu, the global
+	// checksum covers the whole image
+	if info.CartridgeType != 0x0D || !info.HeaderChecksumValid || info.ComputedGlobalChecksum != computeGlobalChecksum(rom) {
+		t.Errorf("unexpected info %+v", info)
+	}
+	cart, err := LoadCartridge(rom)
+	if err != nil {
+		t.Fatalf("unable to load the cartridge: %s", err)
+	}
+	if *cart.Info() != *info {
+		t.Errorf("the info of the loaded cart %+v differ (exp: %+v)", *cart.Info(), *info)
+	}
+}
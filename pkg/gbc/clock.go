@@ -0,0 +1,116 @@
+package gbc
+
+import (
+	"encoding/gob"
+	"time"
+)
+
+// Clock is the source of time used by the cartridge real time clocks
+type Clock interface {
+	// Now returns the current time as seconds since the UNIX epoch
+	Now() int64
+	// WallTime returns the current time of the host, written in the save
+	// files (which other emulators expect to be a real UNIX timestamp)
+	WallTime() int64
+	// Advance is called by the console with the number of CPU ticks
+	// (in single speed units) emulated since the last call
+	Advance(ticks int)
+}
+
+// RealClock follows the wall clock of the host
+type RealClock struct{}
+
+func (RealClock) Now() int64 {
+	return time.Now().Unix()
+}
+
+func (RealClock) WallTime() int64 {
+	return time.Now().Unix()
+}
+
+func (RealClock) Advance(ticks int) {}
+
+// EmulatedClock derives the time from the number of emulated CPU ticks,
+// starting from Base. Since it does not depend on the host, fast-forward
+// and slow mode scale the in-game time accordingly, and two runs of the
+// same inputs see the same time
+type EmulatedClock struct {
+	Base  int64
+	Ticks uint64
+}
+
+func MakeEmulatedClock(base int64) *EmulatedClock {
+	return &EmulatedClock{Base: base}
+}
+
+func (c *EmulatedClock) Now() int64 {
+	return c.Base + int64(c.Ticks*4/GBCPU_FREQ)
+}
+
+func (c *EmulatedClock) WallTime() int64 {
+	return time.Now().Unix()
+}
+
+func (c *EmulatedClock) Advance(ticks int) {
+	c.Ticks += uint64(ticks)
+}
+
+// ManualClock changes only when explicitly told to
+type ManualClock struct {
+	Time int64
+}
+
+func MakeManualClock(t int64) *ManualClock {
+	return &ManualClock{Time: t}
+}
+
+func (c *ManualClock) Now() int64 {
+	return c.Time
+}
+
+// WallTime is the time of the clock, there is no host time to follow
+func (c *ManualClock) WallTime() int64 {
+	return c.Time
+}
+
+func (c *ManualClock) Advance(ticks int) {}
+
+func (c *ManualClock) Set(t int64) {
+	c.Time = t
+}
+
+func (c *ManualClock) Add(seconds int64) {
+	c.Time += seconds
+}
+
+// fromWallTime converts a time of the host (e.g. the timestamp of a save
+// file) to the time of clock
+func fromWallTime(clock Clock, t int64) int64 {
+	return t + clock.Now() - clock.WallTime()
+}
+
+// saveClock saves the state of the emulated clocks, the others follow the
+// host
+func saveClock(encoder *gob.Encoder, clock Clock) {
+	base, ticks := clock.Now(), uint64(0)
+	if c, ok := clock.(*EmulatedClock); ok {
+		base, ticks = c.Base, c.Ticks
+	}
+	panicIfErr(encoder.Encode(base))
+	panicIfErr(encoder.Encode(ticks))
+}
+
+func loadClock(decoder *gob.Decoder, clock Clock) error {
+	var base int64
+	var ticks uint64
+	if err := decoder.Decode(&base); err != nil {
+		return err
+	}
+	if err := decoder.Decode(&ticks); err != nil {
+		return err
+	}
+	if c, ok := clock.(*EmulatedClock); ok {
+		c.Base, c.Ticks = base, ticks
+	}
+	return nil
+}
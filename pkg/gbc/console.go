@@ -21,6 +21,19 @@ var InterruptJoypad z80cpu.Z80Interrupt = z80cpu.Z80Interrupt{
 
 const GBCPU_FREQ = 4194304
 
+// Duration of the CGB speed switch, in M-cycles
+const SpeedSwitchTicks = 2050
+
+// Version of the save state layout, written before the state. Bump it
+// every time the values stored by Save change
+const SaveStateVersion uint32 = 3
+
+type StateError string
+
+func (err StateError) Error() string {
+	return string(err)
+}
+
 type Frontend interface {
 	NotifyAudioSample(l, r int8)
 	SetPixel(x, y int, color uint32)
@@ -28,6 +41,19 @@ type Frontend interface {
 	ExchangeSerial(sb, sc uint8) (uint8, uint8)
 }
 
+// RumbleFrontend can be implemented by a Frontend able to drive a rumble
+// motor. SetRumble is called every time the cartridge turns the motor
+// on or off
+type RumbleFrontend interface {
+	SetRumble(enabled bool)
+}
+
+// LockFrontend can be implemented by a Frontend to be notified when the
+// CPU locks up, after executing one of the illegal opcodes
+type LockFrontend interface {
+	CPULocked(pc uint16, opcode uint8)
+}
+
 type Console struct {
 	ROM    []byte
 	Cart   *Cart
@@ -39,9 +65,25 @@ type Console struct {
 	Input  *Joypad
 	serial *Serial
 
+	// Non-fatal issues found while loading the ROM
+	LoadReport *LoadReport
+
 	CGBMode bool
 	CPUFreq int
 
+	// Source of time for the cartridge RTC
+	clock          Clock
+	clockRemainder int
+
+	// Set if the cartridge mapper needs to be clocked
+	tickingMapper tickingMapper
+
+	// Notified when the CPU locks up
+	lockFrontend LockFrontend
+
+	// M-cycles left before the end of a CGB speed switch
+	speedSwitchTicks int
+
 	// Memory
 	IOMem   [256]byte
 	HighRAM [0x80]byte
@@ -58,9 +100,13 @@ type Console struct {
 	// Debug Flags
 	PrintDebug bool
 	Verbose    bool
+
+	// Symbols loaded with LoadSymbols, nil if there are none
+	Symbols *SymbolTable
 }
 
 func (cons *Console) Save(encoder *gob.Encoder) {
+	panicIfErr(encoder.Encode(SaveStateVersion))
 	panicIfErr(encoder.Encode(cons.IOMem))
 	panicIfErr(encoder.Encode(cons.HighRAM))
 	panicIfErr(encoder.Encode(cons.WorkRAM))
@@ -77,9 +123,18 @@ func (cons *Console) Save(encoder *gob.Encoder) {
 	cons.timer.Save(encoder)
 	cons.serial.Save(encoder)
 	cons.Input.Save(encoder)
+	panicIfErr(encoder.Encode(cons.speedSwitchTicks))
+	saveClock(encoder, cons.clock)
+	panicIfErr(encoder.Encode(cons.clockRemainder))
 }
 
 func (cons *Console) Load(decoder *gob.Decoder) error {
+	// The states without a version start with IOMem and fail to decode
+	var version uint32
+	if err := decoder.Decode(&version); err != nil || version != SaveStateVersion {
+		return StateError("Unsupported save state version")
+	}
+
 	errs := []error{
 		decoder.Decode(&cons.IOMem),
 		decoder.Decode(&cons.HighRAM),
@@ -97,6 +152,9 @@ func (cons *Console) Load(decoder *gob.Decoder) error {
 		cons.timer.Load(decoder),
 		cons.serial.Load(decoder),
 		cons.Input.Load(decoder),
+		decoder.Decode(&cons.speedSwitchTicks),
+		loadClock(decoder, cons.clock),
+		decoder.Decode(&cons.clockRemainder),
 	}
 
 	for _, err := range errs {
@@ -448,6 +506,44 @@ func (cons *Console) Write(addr uint16, value uint8) {
 	}
 }
 
+// Bank returns the bank mapped at addr: the ROM bank in 0000 - 7FFF, the
+// VRAM bank in 8000 - 9FFF and the WRAM bank in D000 - DFFF (and its echo).
+// Elsewhere, it is 0
+func (cons *Console) Bank(addr uint16) int {
+	switch {
+	case addr <= 0x7FFF:
+		if m, ok := cons.Cart.Map.(bankedMapper); ok {
+			return m.ROMBank(addr)
+		}
+		return int(addr >> 14)
+	case 0x8000 <= addr && addr <= 0x9FFF:
+		return int(cons.PPU.VRAMBank)
+	case 0xD000 <= addr && addr <= 0xDFFF, 0xF000 <= addr && addr <= 0xFDFF:
+		return int(cons.RamBank)
+	}
+	return 0
+}
+
+// ReadBanked reads addr in a given bank (see Bank), regardless of the banks
+// currently mapped. The banks of the cart are read by its mapper. With
+// AnyBank, it is a Read
+func (cons *Console) ReadBanked(bank int, addr uint16) uint8 {
+	if bank == AnyBank {
+		return cons.Read(addr)
+	}
+	switch {
+	case addr <= 0x7FFF, 0xA000 <= addr && addr <= 0xBFFF:
+		if m, ok := cons.Cart.Map.(bankedMapper); ok {
+			return m.MapperReadBanked(bank, addr)
+		}
+	case 0x8000 <= addr && addr <= 0x9FFF:
+		return cons.PPU.VRAM[bank&1][addr-0x8000]
+	case 0xD000 <= addr && addr <= 0xDFFF:
+		return cons.WorkRAM[bank&7][addr-0xD000]
+	}
+	return cons.Read(addr)
+}
+
 func getBoot(cart *Cart) []byte {
 	if cart.header.CgbFlag != 0 {
 		return CGBBoot
@@ -456,42 +552,36 @@ func getBoot(cart *Cart) []byte {
 }
 
 func (c *Console) LoadSav(data []byte) error {
-	if len(data) != len(c.Cart.RAMBanks)*8192 {
-		return CartError("Invalid SAV file")
+	if m, ok := c.Cart.Map.(SavMapper); ok {
+		return m.LoadSav(data)
 	}
-
-	for i := 0; i < len(c.Cart.RAMBanks); i++ {
-		off := i * 8192
-		copy(c.Cart.RAMBanks[i][:], data[off:off+8192])
-	}
-	return nil
+	return c.Cart.loadRAM(data)
 }
 
 func (c *Console) StoreSav() ([]byte, error) {
-	if len(c.Cart.RAMBanks) == 0 {
-		return []byte{}, nil
+	if m, ok := c.Cart.Map.(SavMapper); ok {
+		return m.StoreSav(), nil
 	}
-
-	buf := make([]byte, 0)
-	writer := bytes.NewBuffer(buf)
-	for i := 0; i < len(c.Cart.RAMBanks); i++ {
-		_, err := writer.Write(c.Cart.RAMBanks[i][:])
-		if err != nil {
-			return nil, err
-		}
-	}
-	return writer.Bytes(), nil
+	return c.Cart.storeRAM(), nil
 }
 
 func MakeConsole(rom []byte, frontend Frontend) (*Console, error) {
-	cart, err := LoadCartridge(rom)
+	return MakeConsoleWithOptions(rom, frontend, LoadOptions{})
+}
+
+func MakeConsoleWithOptions(rom []byte, frontend Frontend, opts LoadOptions) (*Console, error) {
+	cart, report, err := LoadCartridgeWithOptions(rom, opts)
 	if err != nil {
 		return nil, err
 	}
+	return makeConsoleWithCart(rom, cart, report, frontend), nil
+}
 
+func makeConsoleWithCart(rom []byte, cart *Cart, report *LoadReport, frontend Frontend) *Console {
 	res := &Console{
 		ROM:             rom,
 		Cart:            cart,
+		LoadReport:      report,
 		RamBank:         1,
 		CGBMode:         cart.header.CgbFlag != 0,
 		CPUFreq:         GBCPU_FREQ,
@@ -515,18 +605,89 @@ func MakeConsole(rom []byte, frontend Frontend) (*Console, error) {
 	res.CPU.RegisterInterrupt(InterruptSerial)
 	res.CPU.RegisterInterrupt(InterruptJoypad)
 
-	return res, nil
+	if rf, ok := frontend.(RumbleFrontend); ok {
+		if m, ok := cart.Map.(rumbleMapper); ok {
+			m.setRumbleFrontend(rf)
+		}
+	}
+
+	if m, ok := cart.Map.(tickingMapper); ok {
+		res.tickingMapper = m
+	}
+	if lf, ok := frontend.(LockFrontend); ok {
+		res.lockFrontend = lf
+	}
+
+	res.SetClock(cart.clock)
+	return res
+}
+
+// SetClock changes the source of time used by the cartridge (if it
+// has a real time clock)
+func (cons *Console) SetClock(clock Clock) {
+	cons.clock = clock
+	cons.Cart.clock = clock
+	if m, ok := cons.Cart.Map.(clockedMapper); ok {
+		m.setClock(clock)
+	}
+}
+
+// SetTilt feeds the accelerometer of the cartridge (if any). Values are
+// expressed in g, positive x means tilted to the right and positive y
+// tilted towards the bottom
+func (cons *Console) SetTilt(x, y float64) {
+	if m, ok := cons.Cart.Map.(tiltMapper); ok {
+		m.setTilt(x, y)
+	}
+}
+
+// SetCameraSource changes the source of the images captured by the
+// cartridge (if it has a camera)
+func (cons *Console) SetCameraSource(source CameraSource) {
+	if m, ok := cons.Cart.Map.(cameraMapper); ok {
+		m.setCameraSource(source)
+	}
+}
+
+func (cons *Console) GetClock() Clock {
+	return cons.clock
+}
+
+func (cons *Console) advanceClock(cpuTicks int) {
+	if cons.DoubleSpeedMode {
+		// each tick lasts half the time
+		cpuTicks += cons.clockRemainder
+		cons.clockRemainder = cpuTicks & 1
+		cpuTicks >>= 1
+	}
+	cons.clock.Advance(cpuTicks)
 }
 
 var prevTicks int = 0
 
 func (cons *Console) tickComponents(cpuTicks int) {
+	cons.timer.Tick(cpuTicks)
+	cons.tickStoppedComponents(cpuTicks)
+}
+
+// The components that keep running while the CPU is in STOP mode, DIV
+// and the timer are paused
+func (cons *Console) tickStoppedComponents(cpuTicks int) {
 	cons.PPU.Tick(cpuTicks)
 	cons.APU.Tick(cpuTicks)
 	cons.DMA.Tick(cpuTicks)
-	cons.timer.Tick(cpuTicks)
 	cons.serial.Tick(cpuTicks)
 	cons.Input.Tick(cpuTicks)
+	cons.advanceClock(cpuTicks)
+	if cons.tickingMapper != nil {
+		cons.tickingMapper.tick(cpuTicks)
+	}
+}
+
+// Tick advances the components by some CPU M-cycles, it is called by the
+// CPU during the execution of the instructions
+func (cons *Console) Tick(cpuTicks int) {
+	cons.tickComponents(cpuTicks)
 }
 
 func (cons *Console) innerStep() int {
@@ -542,25 +703,60 @@ func (cons *Console) innerStep() int {
 	if cons.PrintDebug {
 		var cpu *z80cpu.Z80Cpu = cons.CPU
 		_, disas_str := cons.CPU.Disas.DisassembleOneFromCPU(cons.CPU)
+		if label := cons.DescribeAddr(cpu.PC); label != "" {
+			disas_str = fmt.Sprintf("%s ; %s", disas_str, label)
+		}
 
 		log.Printf("%s |CYC=%d PC=%04x SP=%04x A=%02x B=%02x C=%02x D=%02x E=%02x H=%02x L=%02x F=%02x IV=%02x PPUC=%04d LY=%02x LYC=%02x STAT=%02x LCDC=%02x SCX=%02x SCY=%02x WX=%02x WY=%02x MEM=%02x\n",
 			disas_str, prevTicks, cpu.PC, cpu.SP, cpu.A, cpu.B, cpu.C, cpu.D, cpu.E, cpu.H, cpu.L, cpu.PackFlags(), cpu.IE&cpu.IF, cons.PPU.CycleCount, cons.PPU.LY, cons.PPU.LYC, cons.PPU.STAT, cons.PPU.LCDC, cons.PPU.SCX, cons.PPU.SCY, cons.PPU.WX, cons.PPU.WY, cons.Read(cpu.SP))
 	}
 
+	if cons.CPU.IsStopped {
+		return totTicks + cons.stepStopped()
+	}
+
+	// The components are ticked by the CPU, at every M-cycle
+	pc, wasLocked := cons.CPU.PC, cons.CPU.IsLocked
 	cpuTicks := cons.CPU.ExecOne()
+	if cons.CPU.IsLocked && !wasLocked && cons.lockFrontend != nil {
+		cons.lockFrontend.CPULocked(pc, cons.Read(pc))
+	}
 	if cons.CPU.IsStopped {
-		if cons.CGBMode && cons.SpeedSwitch&1 == 1 {
+		cons.enterStop()
+	}
+
+	totTicks += cpuTicks
+	return totTicks
+}
+
+// enterStop resets DIV and, if requested through KEY1, starts the CGB speed
+// switch. Otherwise, the console waits for a button press
+func (cons *Console) enterStop() {
+	cons.timer.resetDiv()
+	if cons.CGBMode && cons.SpeedSwitch&1 == 1 {
+		cons.speedSwitchTicks = SpeedSwitchTicks
+	}
+}
+
+func (cons *Console) stepStopped() int {
+	if cons.speedSwitchTicks > 0 {
+		cons.tickStoppedComponents(1)
+		cons.speedSwitchTicks -= 1
+		if cons.speedSwitchTicks == 0 {
 			cons.SpeedSwitch = (cons.SpeedSwitch ^ 0x80) & 0x80
 			cons.DoubleSpeedMode = !cons.DoubleSpeedMode
 			cons.CPU.IsStopped = false
-			// FIXME: is this correct !? It should be totTicks+cpuTicks
-			return totTicks
 		}
+		return 1
 	}
 
-	cons.tickComponents(cpuTicks)
-	totTicks += cpuTicks
-	return totTicks
+	// Low power mode, woken up by the joypad
+	if cons.Input.BackState != (JoypadState{}) {
+		cons.CPU.IsStopped = false
+		return 0
+	}
+	cons.tickStoppedComponents(1)
+	return 1
 }
 
 func (cons *Console) Step() int {
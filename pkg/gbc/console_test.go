@@ -0,0 +1,42 @@
+package gbc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func TestSaveStateVersion(t *testing.T) {
+	cons, err := makeTestConsole(makeTestROM(0x00, 2, 0x00, nil))
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+	cons.HighRAM[0] = 0x42
+	state := cons.SaveState()
+
+	unversioned := new(bytes.Buffer)
+	panicIfErr(gob.NewEncoder(unversioned).Encode(cons.IOMem))
+	newer := new(bytes.Buffer)
+	panicIfErr(gob.NewEncoder(newer).Encode(SaveStateVersion + 1))
+
+	tests := []struct {
+		name  string
+		state []byte
+		ok    bool
+	}{
+		{"current", state, true},
+		{"without version", unversioned.Bytes(), false},
+		{"newer version", newer.Bytes(), false},
+		{"empty", nil, false},
+	}
+	for _, test := range tests {
+		cons.HighRAM[0] = 0
+		err := cons.LoadState(test.state)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if test.ok && cons.HighRAM[0] != 0x42 {
+			t.Errorf("%s: the state was not restored", test.name)
+		}
+	}
+}
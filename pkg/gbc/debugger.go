@@ -0,0 +1,420 @@
+package gbc
+
+import (
+	"borzGBC/pkg/z80cpu"
+	"fmt"
+	"sync/atomic"
+)
+
+// The Debugger runs the console instruction by instruction, stopping at
+// the breakpoints and watchpoints. While it is attached, the memory accesses
+// of the CPU are checked against the watchpoints
+
+type StopReason int
+
+const (
+	StopStep       StopReason = iota // the step is complete
+	StopBreakpoint                   // a breakpoint was hit
+	StopWatchpoint                   // a watchpoint was hit
+	StopLocked                       // the CPU is locked by an illegal opcode
+	StopFrame                        // the frame is complete
+	StopLimit                        // the M-cycles limit was reached
+	StopInterrupt                    // the command was interrupted
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopStep:
+		return "step"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopWatchpoint:
+		return "watchpoint"
+	case StopLocked:
+		return "CPU locked"
+	case StopFrame:
+		return "frame"
+	case StopLimit:
+		return "limit"
+	case StopInterrupt:
+		return "interrupted"
+	}
+	return fmt.Sprintf("StopReason(%d)", int(r))
+}
+
+type WatchKind uint8
+
+const (
+	WatchRead WatchKind = 1 << iota
+	WatchWrite
+	WatchExec
+)
+
+func (k WatchKind) String() string {
+	res := ""
+	for _, kind := range []struct {
+		kind WatchKind
+		name string
+	}{{WatchRead, "r"}, {WatchWrite, "w"}, {WatchExec, "x"}} {
+		if k&kind.kind != 0 {
+			res += kind.name
+		}
+	}
+	return res
+}
+
+// A Breakpoint stops the execution before the instruction at Addr. If Bank
+// is not AnyBank, the bank mapped at Addr must match too (see Console.Bank).
+// If Cond is not nil, it must evaluate to a non zero value
+type Breakpoint struct {
+	ID      int
+	Addr    uint16
+	Bank    int
+	Cond    *Expr
+	Enabled bool
+	Hits    int
+}
+
+const AnyBank = -1
+
+func (bp *Breakpoint) String() string {
+	res := fmt.Sprintf("%d: %04x", bp.ID, bp.Addr)
+	if bp.Bank != AnyBank {
+		res = fmt.Sprintf("%d: %02x:%04x", bp.ID, bp.Bank, bp.Addr)
+	}
+	if bp.Cond != nil {
+		res += " if " + bp.Cond.Source
+	}
+	if !bp.Enabled {
+		res += " (disabled)"
+	}
+	return res
+}
+
+// A Watchpoint stops the execution after the CPU accesses the range
+// Start - End (inclusive). Read watchpoints ignore the instruction fetches,
+// while execute watchpoints stop before the instruction
+type Watchpoint struct {
+	ID         int
+	Start, End uint16
+	Kind       WatchKind
+	Enabled    bool
+	Hits       int
+}
+
+func (wp *Watchpoint) String() string {
+	res := fmt.Sprintf("%d: %04x-%04x %s", wp.ID, wp.Start, wp.End, wp.Kind)
+	if !wp.Enabled {
+		res += " (disabled)"
+	}
+	return res
+}
+
+// StopEvent describes why the execution stopped
+type StopEvent struct {
+	Reason     StopReason
+	PC         uint16
+	Bank       int // bank mapped at PC
+	Ticks      int // M-cycles run
+	Breakpoint *Breakpoint
+	Watchpoint *Watchpoint
+
+	// Watchpoint access
+	Access WatchKind
+	Addr   uint16
+	Value  uint8
+}
+
+func (ev StopEvent) String() string {
+	res := fmt.Sprintf("%s at %02x:%04x", ev.Reason, ev.Bank, ev.PC)
+	switch ev.Reason {
+	case StopBreakpoint:
+		res = fmt.Sprintf("breakpoint %d at %02x:%04x", ev.Breakpoint.ID, ev.Bank, ev.PC)
+	case StopWatchpoint:
+		res += fmt.Sprintf(", watchpoint %d (%s %04x = %02x)", ev.Watchpoint.ID, ev.Access, ev.Addr, ev.Value)
+	}
+	return res
+}
+
+type Debugger struct {
+	Console *Console
+
+	breakpoints []*Breakpoint
+	watchpoints []*Watchpoint
+	nextID      int
+
+	// The first watchpoint hit by the current instruction
+	watchHit *StopEvent
+
+	mem z80cpu.Memory
+
+	interrupted atomic.Bool
+}
+
+// debugMemory checks the memory accesses of the CPU against the watchpoints
+type debugMemory struct {
+	dbg *Debugger
+}
+
+func (m debugMemory) Read(addr uint16) uint8 {
+	value := m.dbg.mem.Read(addr)
+	if !m.dbg.Console.CPU.Fetching {
+		m.dbg.checkAccess(WatchRead, addr, value)
+	}
+	return value
+}
+
+func (m debugMemory) Write(addr uint16, value uint8) {
+	m.dbg.mem.Write(addr, value)
+	m.dbg.checkAccess(WatchWrite, addr, value)
+}
+
+// MakeDebugger attaches a debugger to the console, until Detach is called
+func MakeDebugger(cons *Console) *Debugger {
+	res := &Debugger{
+		Console: cons,
+		nextID:  1,
+		mem:     cons.CPU.Mem,
+	}
+	cons.CPU.Mem = debugMemory{res}
+	return res
+}
+
+// Interrupt stops the running command of the debugger (e.g., on Ctrl-C),
+// it can be called from any goroutine
+func (d *Debugger) Interrupt() {
+	d.interrupted.Store(true)
+}
+
+func (d *Debugger) Detach() {
+	d.Console.CPU.Mem = d.mem
+}
+
+func (d *Debugger) AddBreakpoint(addr uint16, bank int, cond string) (*Breakpoint, error) {
+	bp := &Breakpoint{Addr: addr, Bank: bank, Enabled: true}
+	if cond != "" {
+		expr, err := ParseExprWithSymbols(cond, d.Console.Symbols)
+		if err != nil {
+			return nil, err
+		}
+		bp.Cond = expr
+	}
+	bp.ID = d.nextID
+	d.nextID += 1
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp, nil
+}
+
+func (d *Debugger) AddWatchpoint(start, end uint16, kind WatchKind) (*Watchpoint, error) {
+	if end < start {
+		return nil, DebugError(fmt.Sprintf("Invalid range %04x-%04x", start, end))
+	}
+	if kind&(WatchRead|WatchWrite|WatchExec) == 0 {
+		return nil, DebugError("The watchpoint has no access kind")
+	}
+	wp := &Watchpoint{Start: start, End: end, Kind: kind, Enabled: true}
+	wp.ID = d.nextID
+	d.nextID += 1
+	d.watchpoints = append(d.watchpoints, wp)
+	return wp, nil
+}
+
+// Remove deletes the breakpoint or the watchpoint with the given id
+func (d *Debugger) Remove(id int) bool {
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	for i, wp := range d.watchpoints {
+		if wp.ID == id {
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SetEnabled enables or disables the breakpoint or the watchpoint with the
+// given id
+func (d *Debugger) SetEnabled(id int, enabled bool) bool {
+	for _, bp := range d.breakpoints {
+		if bp.ID == id {
+			bp.Enabled = enabled
+			return true
+		}
+	}
+	for _, wp := range d.watchpoints {
+		if wp.ID == id {
+			wp.Enabled = enabled
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	return append([]*Breakpoint{}, d.breakpoints...)
+}
+
+func (d *Debugger) Watchpoints() []*Watchpoint {
+	return append([]*Watchpoint{}, d.watchpoints...)
+}
+
+func (d *Debugger) checkAccess(kind WatchKind, addr uint16, value uint8) {
+	if d.watchHit != nil {
+		return
+	}
+	for _, wp := range d.watchpoints {
+		if wp.Enabled && wp.Kind&kind != 0 && wp.Start <= addr && addr <= wp.End {
+			wp.Hits += 1
+			d.watchHit = &StopEvent{
+				Reason:     StopWatchpoint,
+				Watchpoint: wp,
+				Access:     kind,
+				Addr:       addr,
+				Value:      value,
+			}
+			return
+		}
+	}
+}
+
+// willExecute is false when the next step does not fetch an instruction at
+// PC: the CPU is stopped, or halted without pending interrupts
+func (d *Debugger) willExecute() bool {
+	cpu := d.Console.CPU
+	if cpu.IsStopped || cpu.IsLocked {
+		return false
+	}
+	return !cpu.IsHalted || cpu.IE&cpu.IF&0x1F != 0
+}
+
+// checkBreak checks the breakpoints and the execute watchpoints at PC
+func (d *Debugger) checkBreak() *StopEvent {
+	if !d.willExecute() {
+		return nil
+	}
+	pc := d.Console.CPU.PC
+	for _, bp := range d.breakpoints {
+		if !bp.Enabled || bp.Addr != pc {
+			continue
+		}
+		if bp.Bank != AnyBank && bp.Bank != d.Console.Bank(pc) {
+			continue
+		}
+		if bp.Cond != nil && bp.Cond.Eval(d.Console) == 0 {
+			continue
+		}
+		bp.Hits += 1
+		return &StopEvent{Reason: StopBreakpoint, Breakpoint: bp}
+	}
+	for _, wp := range d.watchpoints {
+		if wp.Enabled && wp.Kind&WatchExec != 0 && wp.Start <= pc && pc <= wp.End {
+			wp.Hits += 1
+			return &StopEvent{
+				Reason:     StopWatchpoint,
+				Watchpoint: wp,
+				Access:     WatchExec,
+				Addr:       pc,
+				Value:      d.Console.Read(pc),
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Debugger) stop(ev StopEvent, ticks int) StopEvent {
+	ev.PC = d.Console.CPU.PC
+	ev.Bank = d.Console.Bank(ev.PC)
+	ev.Ticks = ticks
+	return ev
+}
+
+// run steps the console until done returns true, or a breakpoint is hit.
+// done receives PC and the opcode at PC before the step. The breakpoints at
+// the initial PC are ignored, to resume from them
+func (d *Debugger) run(maxTicks int, done func(pc uint16, opcode uint8) bool, reason StopReason) StopEvent {
+	cons := d.Console
+	d.watchHit = nil
+	d.interrupted.Store(false)
+	ticks := 0
+	for first := true; ; first = false {
+		if d.interrupted.Load() {
+			return d.stop(StopEvent{Reason: StopInterrupt}, ticks)
+		}
+		if cons.CPU.IsLocked {
+			return d.stop(StopEvent{Reason: StopLocked}, ticks)
+		}
+		if !first {
+			if ev := d.checkBreak(); ev != nil {
+				return d.stop(*ev, ticks)
+			}
+		}
+
+		pc := cons.CPU.PC
+		opcode := cons.Read(pc)
+		ticks += cons.innerStep()
+
+		if d.watchHit != nil {
+			ev := *d.watchHit
+			d.watchHit = nil
+			return d.stop(ev, ticks)
+		}
+		if cons.CPU.IsLocked {
+			return d.stop(StopEvent{Reason: StopLocked}, ticks)
+		}
+		if done(pc, opcode) {
+			return d.stop(StopEvent{Reason: reason}, ticks)
+		}
+		if maxTicks > 0 && ticks >= maxTicks {
+			return d.stop(StopEvent{Reason: StopLimit}, ticks)
+		}
+	}
+}
+
+// Step executes an instruction, or dispatches an interrupt. While the CPU is
+// halted or stopped, it advances by an M-cycle
+func (d *Debugger) Step() StopEvent {
+	return d.run(0, func(uint16, uint8) bool { return true }, StopStep)
+}
+
+// StepOver is a Step that runs the called functions until they return
+func (d *Debugger) StepOver() StopEvent {
+	cpu := d.Console.CPU
+	in := z80cpu.DecodeMemory(d.Console, cpu.PC)
+	if !in.IsCall() || !d.willExecute() {
+		return d.Step()
+	}
+	target, sp := cpu.PC+uint16(in.Length), cpu.SP
+	return d.run(0, func(uint16, uint8) bool {
+		return cpu.PC == target && cpu.SP >= sp
+	}, StopStep)
+}
+
+// StepOut runs until the current function returns
+func (d *Debugger) StepOut() StopEvent {
+	cpu := d.Console.CPU
+	sp := cpu.SP
+	return d.run(0, func(pc uint16, opcode uint8) bool {
+		// RET, RETI and RET cc
+		isRet := opcode == 0xC9 || opcode == 0xD9 || opcode&0xE7 == 0xC0
+		return isRet && cpu.SP > sp
+	}, StopStep)
+}
+
+// RunUntilBreak runs until a breakpoint or a watchpoint is hit, or the CPU
+// locks up. If maxTicks is not 0, it stops after maxTicks M-cycles
+func (d *Debugger) RunUntilBreak(maxTicks int) StopEvent {
+	return d.run(maxTicks, func(uint16, uint8) bool { return false }, StopLimit)
+}
+
+// RunFrame is a RunUntilBreak that also stops at the end of the frame, for
+// frontends driving the console one frame at a time
+func (d *Debugger) RunFrame() StopEvent {
+	frame := d.Console.PPU.FrameCount
+	return d.run(0, func(uint16, uint8) bool {
+		return d.Console.PPU.FrameCount != frame
+	}, StopFrame)
+}
@@ -0,0 +1,260 @@
+package gbc
+
+import (
+	"strings"
+	"testing"
+)
+
+// makeDebugConsole builds a MBC1 cart that calls a function in bank 2 and
+// one in bank 3, both at 0x4000, and then locks the CPU
+func makeDebugConsole(t *testing.T) *Debugger {
+	rom := makeTestROM(0x01, 4, 0x00, []byte{ // MBC1
+		0x3e, 0x02, //       ld a, 2
+		0xea, 0x00, 0x20, // ld (0x2000), a
+		0xcd, 0x00, 0x40, // call 0x4000
+		0x3e, 0x03, //       ld a, 3
+		0xea, 0x00, 0x20, // ld (0x2000), a
+		0xcd, 0x00, 0x40, // call 0x4000
+		0xd3, //             illegal
+	})
+	copy(rom[2*0x4000:], []byte{
+		0x21, 0x00, 0xc0, // ld hl, 0xc000
+		0x36, 0x42, //       ld (hl), 0x42
+		0x3c, //             inc a
+		0xc9, //             ret
+	})
+	copy(rom[3*0x4000:], []byte{
+		0xfa, 0x00, 0xc0, // ld a, (0xc000)
+		0xc9, //             ret
+	})
+	cons, err := makeTestConsole(rom)
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+	return MakeDebugger(cons)
+}
+
+func checkStop(t *testing.T, ev StopEvent, reason StopReason, bank int, pc uint16) {
+	t.Helper()
+	if ev.Reason != reason || ev.Bank != bank || ev.PC != pc {
+		t.Errorf("stopped at %s (exp: %s at %02x:%04x)", ev, reason, bank, pc)
+	}
+}
+
+func TestBankedBreakpoint(t *testing.T) {
+	dbg := makeDebugConsole(t)
+	bp, _ := dbg.AddBreakpoint(0x4000, 3, "")
+
+	ev := dbg.RunUntilBreak(0)
+	checkStop(t, ev, StopBreakpoint, 3, 0x4000)
+	if ev.Breakpoint != bp || bp.Hits != 1 {
+		t.Errorf("unexpected breakpoint %v, hits=%d", ev.Breakpoint, bp.Hits)
+	}
+
+	ev = dbg.RunUntilBreak(0)
+	checkStop(t, ev, StopLocked, 0, 0x0161)
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	dbg := makeDebugConsole(t)
+	dbg.AddBreakpoint(0x4000, AnyBank, "a == 3")
+
+	ev := dbg.RunUntilBreak(0)
+	checkStop(t, ev, StopBreakpoint, 3, 0x4000)
+
+	if _, err := dbg.AddBreakpoint(0x4000, AnyBank, "a =="); err == nil {
+		t.Errorf("invalid condition accepted")
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	dbg := makeDebugConsole(t)
+	wp, _ := dbg.AddWatchpoint(0xC000, 0xC0FF, WatchWrite)
+
+	ev := dbg.RunUntilBreak(0)
+	checkStop(t, ev, StopWatchpoint, 2, 0x4005)
+	if ev.Watchpoint != wp || ev.Addr != 0xC000 || ev.Value != 0x42 {
+		t.Errorf("unexpected watchpoint event: %s", ev)
+	}
+
+	dbg.Remove(wp.ID)
+	dbg.AddWatchpoint(0xC000, 0xC000, WatchRead)
+	dbg.AddWatchpoint(0x4000, 0x4000, WatchExec)
+	ev = dbg.RunUntilBreak(0)
+	checkStop(t, ev, StopWatchpoint, 3, 0x4000)
+	if ev.Access != WatchExec {
+		t.Errorf("unexpected watchpoint event: %s", ev)
+	}
+	ev = dbg.RunUntilBreak(0)
+	checkStop(t, ev, StopWatchpoint, 3, 0x4003)
+	if ev.Access != WatchRead || ev.Value != 0x42 {
+		t.Errorf("unexpected watchpoint event: %s", ev)
+	}
+
+	// Fetching the operands (here the offset of jr) is not a read
+	for i, b := range []uint8{0x18, 0x00, 0xd3} { // jr +0; illegal
+		dbg.Console.Write(0xC100+uint16(i), b)
+	}
+	dbg.Console.CPU.PC = 0xC100
+	wp, _ = dbg.AddWatchpoint(0xC100, 0xC1FF, WatchRead)
+	// And neither is the tracing disassembly
+	if _, instr := dbg.Console.CPU.Disas.DisassembleOneFromCPU(dbg.Console.CPU); wp.Hits != 0 {
+		t.Errorf("the disassembly of \"%s\" hit the watchpoint", instr)
+	}
+	if ev = dbg.RunUntilBreak(0); ev.Reason != StopLocked || ev.PC != 0xC103 {
+		t.Errorf("stopped at %s (exp: locked at 0xc103)", ev)
+	}
+}
+
+func TestStepping(t *testing.T) {
+	dbg := makeDebugConsole(t)
+	dbg.AddBreakpoint(0x155, AnyBank, "")
+	dbg.RunUntilBreak(0)
+
+	checkStop(t, dbg.StepOver(), StopStep, 0, 0x158)
+	dbg.Step()
+	dbg.Step()
+	checkStop(t, dbg.Step(), StopStep, 3, 0x4000)
+	checkStop(t, dbg.Step(), StopStep, 3, 0x4003)
+	ev := dbg.StepOut()
+	checkStop(t, ev, StopStep, 0, 0x160)
+	if dbg.Console.CPU.A != 0x42 {
+		t.Errorf("cpu.A=%02x (exp: 0x42)", dbg.Console.CPU.A)
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	dbg := makeDebugConsole(t)
+	cpu := dbg.Console.CPU
+	cpu.A, cpu.H, cpu.L = 0x10, 0xC0, 0x01
+	dbg.Console.Write(0xC001, 0x7F)
+
+	tests := map[string]int{
+		"a":                 0x10,
+		"HL":                0xC001,
+		"[hl] + 1":          0x80,
+		"$10 == a && !zf":   1,
+		"1 + 2 * 3":         7,
+		"(1 + 2) * 3":       9,
+		"0b101 | 0x10 << 1": 0x25,
+		"-a / 0":            0,
+	}
+	for src, exp := range tests {
+		expr, err := ParseExpr(src)
+		if err != nil {
+			t.Errorf("unable to parse \"%s\": %s", src, err)
+			continue
+		}
+		if v := expr.Eval(dbg.Console); v != exp {
+			t.Errorf("\"%s\"=%d (exp: %d)", src, v, exp)
+		}
+	}
+
+	for _, src := range []string{"", "a +", "(a", "foo", "a b"} {
+		if _, err := ParseExpr(src); err == nil {
+			t.Errorf("\"%s\" accepted", src)
+		}
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	dbg := makeDebugConsole(t)
+	cons := dbg.Console
+	err := cons.LoadSymbols([]byte(`; File generated by rgblink
+00:0150 Main
+00:0155 Main.call
+02:4000 SetValue
+03:4000 ReadValue
+00:c000 wValue
+`))
+	if err != nil {
+		t.Fatalf("unable to load the symbols: %s", err)
+	}
+
+	if sym, ok := cons.Symbols.Lookup("ReadValue"); !ok || sym.Bank != 3 || sym.Addr != 0x4000 {
+		t.Errorf("ReadValue=%s", sym)
+	}
+	for _, test := range []struct {
+		bank int
+		addr uint16
+		exp  string
+	}{
+		{0, 0x0153, "Main+3"},
+		{0, 0x0158, "Main.call+3"},
+		{2, 0x4002, "SetValue+2"},
+		{1, 0x4000, ""},
+		{0, 0xC010, "wValue+10"},
+		{0, 0xD000, ""},
+	} {
+		if res := cons.Symbols.Describe(test.bank, test.addr); res != test.exp {
+			t.Errorf("%02x:%04x=%q (exp: %q)", test.bank, test.addr, res, test.exp)
+		}
+	}
+
+	if _, instr := cons.DisassembleBanked(2, 0x4000); !strings.HasSuffix(instr, "LD HL,wValue") {
+		t.Errorf("unexpected disassembly: %s", instr)
+	}
+	dbg.AddBreakpoint(0x4000, AnyBank, "[wValue] == 0x42")
+	checkStop(t, dbg.RunUntilBreak(0), StopBreakpoint, 3, 0x4000)
+	if _, instr := cons.CPU.Disas.DisassembleOneFromCPU(cons.CPU); !strings.HasSuffix(instr, "LD A,(wValue)") {
+		t.Errorf("unexpected disassembly: %s", instr)
+	}
+}
+
+func TestReadBankedMBC6(t *testing.T) {
+	rom := makeTestROM(0x20, 4, 0x00, nil) // MBC6
+	for bank := 1; bank < 8; bank++ {
+		rom[bank*0x2000] = uint8(bank)
+	}
+	cons, err := makeTestConsole(rom)
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+
+	// Flash in 4000 - 5FFF, ROM in 6000 - 7FFF
+	cons.Cart.Map.MapperWrite(0x0C00, 0x01)
+	cons.Cart.Map.MapperWrite(0x2800, 0x08)
+	for _, test := range []struct {
+		bank int
+		addr uint16
+		exp  uint8
+	}{
+		{0, 0x2000, 1},
+		{3, 0x6000, 3},
+		{5, 0x6000, 5},
+		{3, 0x4000, 0xFF},
+	} {
+		if v := cons.ReadBanked(test.bank, test.addr); v != test.exp {
+			t.Errorf("%02x:%04x=%02x (exp: %02x)", test.bank, test.addr, v, test.exp)
+		}
+	}
+}
+
+func TestReadBankedRAM(t *testing.T) {
+	tests := []struct {
+		name     string
+		cartType uint8
+		ramSize  uint8
+		setup    func(cons *Console)
+		bank     int
+		exp      uint8
+	}{
+		{"mbc1", 0x03, 0x03, func(cons *Console) { cons.Cart.RAMBanks[2][0] = 0x42 }, 2, 0x42},
+		{"mbc2", 0x06, 0x00, func(cons *Console) { cons.Cart.Map.(*MBC2Mapper).ram[0] = 0x07 }, 2, 0xF7},
+		{"mbc6 4KB banks", 0x20, 0x03, func(cons *Console) { cons.Cart.RAMBanks[2][0x1000] = 0x42 }, 5, 0x42},
+	}
+	for _, test := range tests {
+		cons, err := makeTestConsole(makeTestROM(test.cartType, 4, test.ramSize, nil))
+		if err != nil {
+			t.Fatalf("%s: unable to create the console: %s", test.name, err)
+		}
+		test.setup(cons)
+		if v := cons.ReadBanked(test.bank, 0xA000); v != test.exp {
+			t.Errorf("%s: %02x:a000=%02x (exp: %02x)", test.name, test.bank, v, test.exp)
+		}
+		// The RAM is disabled
+		if v := cons.ReadBanked(AnyBank, 0xA000); v != 0xFF {
+			t.Errorf("%s: read %02x with AnyBank (exp: ff)", test.name, v)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package gbc
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+)
+
+// 93LC56 serial EEPROM (128 x 16 bits) as wired in MBC7 cartridges.
+// Commands are shifted in MSB first on the rising edge of CLK while CS is
+// high: a start bit, a 2 bits opcode and an 8 bits address (of which
+// only the lower 7 are used). READ, WRITE and WRAL are followed by 16
+// bits of data.
+
+const EEPROMSize = 256
+
+const (
+	eepromIdle = iota
+	eepromCommand
+	eepromRead
+	eepromWrite
+)
+
+const (
+	eepromOpExtended = 0
+	eepromOpWrite    = 1
+	eepromOpRead     = 2
+	eepromOpErase    = 3
+)
+
+type EEPROM struct {
+	Data [EEPROMSize / 2]uint16
+
+	CS, CLK, DI, DO bool
+	WriteEnabled    bool
+
+	State    int
+	Shift    uint16
+	Bits     int
+	Addr     uint8
+	WriteAll bool
+}
+
+func (e *EEPROM) Save(encoder *gob.Encoder) {
+	panicIfErr(encoder.Encode(e.Data))
+	panicIfErr(encoder.Encode(e.CS))
+	panicIfErr(encoder.Encode(e.CLK))
+	panicIfErr(encoder.Encode(e.DI))
+	panicIfErr(encoder.Encode(e.DO))
+	panicIfErr(encoder.Encode(e.WriteEnabled))
+	panicIfErr(encoder.Encode(e.State))
+	panicIfErr(encoder.Encode(e.Shift))
+	panicIfErr(encoder.Encode(e.Bits))
+	panicIfErr(encoder.Encode(e.Addr))
+	panicIfErr(encoder.Encode(e.WriteAll))
+}
+
+func (e *EEPROM) Load(decoder *gob.Decoder) error {
+	errs := []error{
+		decoder.Decode(&e.Data),
+		decoder.Decode(&e.CS),
+		decoder.Decode(&e.CLK),
+		decoder.Decode(&e.DI),
+		decoder.Decode(&e.DO),
+		decoder.Decode(&e.WriteEnabled),
+		decoder.Decode(&e.State),
+		decoder.Decode(&e.Shift),
+		decoder.Decode(&e.Bits),
+		decoder.Decode(&e.Addr),
+		decoder.Decode(&e.WriteAll),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func MakeEEPROM() EEPROM {
+	res := EEPROM{
+		State: eepromIdle,
+		DO:    true,
+	}
+	// A blank chip reads as all ones
+	for i := range res.Data {
+		res.Data[i] = 0xFFFF
+	}
+	return res
+}
+
+// Bytes returns the content of the EEPROM as stored in .sav files
+// (little endian words)
+func (e *EEPROM) Bytes() []byte {
+	res := make([]byte, EEPROMSize)
+	for i, w := range e.Data {
+		binary.LittleEndian.PutUint16(res[i*2:], w)
+	}
+	return res
+}
+
+func (e *EEPROM) LoadBytes(data []byte) error {
+	if len(data) != EEPROMSize {
+		return CartError("Invalid SAV file")
+	}
+	for i := range e.Data {
+		e.Data[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return nil
+}
+
+// Pins layout: bit 7 CS, bit 6 CLK, bit 1 DI, bit 0 DO
+func (e *EEPROM) ReadPins() uint8 {
+	res := uint8(0)
+	if e.CS {
+		res |= 0x80
+	}
+	if e.CLK {
+		res |= 0x40
+	}
+	if e.DI {
+		res |= 0x02
+	}
+	if e.DO {
+		res |= 0x01
+	}
+	return res
+}
+
+func (e *EEPROM) WritePins(value uint8) {
+	cs := value&0x80 != 0
+	clk := value&0x40 != 0
+	e.DI = value&0x02 != 0
+
+	if !cs {
+		// Deselecting the chip aborts the current command
+		e.CS = false
+		e.CLK = clk
+		e.State = eepromIdle
+		e.DO = true
+		return
+	}
+
+	risingEdge := e.CS && !e.CLK && clk
+	e.CS = true
+	e.CLK = clk
+	if risingEdge {
+		e.clockBit()
+	}
+}
+
+func (e *EEPROM) clockBit() {
+	bit := uint16(0)
+	if e.DI {
+		bit = 1
+	}
+
+	switch e.State {
+	case eepromIdle:
+		if bit == 1 {
+			e.State = eepromCommand
+			e.Shift = 0
+			e.Bits = 0
+		}
+	case eepromCommand:
+		e.Shift = e.Shift<<1 | bit
+		e.Bits += 1
+		if e.Bits == 10 {
+			e.executeCommand(uint8(e.Shift>>8), uint8(e.Shift))
+		}
+	case eepromWrite:
+		e.Shift = e.Shift<<1 | bit
+		e.Bits += 1
+		if e.Bits == 16 {
+			if e.WriteEnabled {
+				if e.WriteAll {
+					for i := range e.Data {
+						e.Data[i] = e.Shift
+					}
+				} else {
+					e.Data[e.Addr] = e.Shift
+				}
+			}
+			e.State = eepromIdle
+			e.DO = true
+		}
+	case eepromRead:
+		// Sequential read: after 16 bits move on to the next word
+		e.DO = (e.Data[e.Addr]>>(15-e.Bits))&1 != 0
+		e.Bits += 1
+		if e.Bits == 16 {
+			e.Bits = 0
+			e.Addr = (e.Addr + 1) & 0x7F
+		}
+	}
+}
+
+func (e *EEPROM) executeCommand(op, addr uint8) {
+	e.Addr = addr & 0x7F
+	e.Shift = 0
+	e.Bits = 0
+	e.State = eepromIdle
+
+	switch op {
+	case eepromOpRead:
+		// A dummy zero precedes the data
+		e.State = eepromRead
+		e.DO = false
+	case eepromOpWrite:
+		e.State = eepromWrite
+		e.WriteAll = false
+	case eepromOpErase:
+		if e.WriteEnabled {
+			e.Data[e.Addr] = 0xFFFF
+		}
+		e.DO = true
+	case eepromOpExtended:
+		switch (addr >> 6) & 3 {
+		case 0: // EWDS
+			e.WriteEnabled = false
+		case 1: // WRAL
+			e.State = eepromWrite
+			e.WriteAll = true
+		case 2: // ERAL
+			if e.WriteEnabled {
+				for i := range e.Data {
+					e.Data[i] = 0xFFFF
+				}
+			}
+			e.DO = true
+		case 3: // EWEN
+			e.WriteEnabled = true
+		}
+	}
+}
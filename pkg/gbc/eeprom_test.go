@@ -0,0 +1,167 @@
+package gbc
+
+import "testing"
+
+// eepromBus drives the pins of the EEPROM as the MBC7 games do
+type eepromBus struct {
+	write func(value uint8)
+	read  func() uint8
+}
+
+// clock shifts in a bit, and returns the DO pin after the rising edge
+func (b eepromBus) clock(bit uint32) bool {
+	di := uint8(bit&1) << 1
+	b.write(0x80 | di)
+	b.write(0xC0 | di)
+	return b.read()&0x01 != 0
+}
+
+// command sends the start bit, the opcode and the address
+func (b eepromBus) command(op, addr uint8) {
+	b.write(0x00)
+	b.write(0x80)
+	cmd := uint32(1)<<10 | uint32(op)<<8 | uint32(addr)
+	for i := 10; i >= 0; i-- {
+		b.clock(cmd >> i)
+	}
+}
+
+func (b eepromBus) data(value uint16) {
+	for i := 15; i >= 0; i-- {
+		b.clock(uint32(value) >> i)
+	}
+}
+
+func (b eepromBus) readWord() uint16 {
+	res := uint16(0)
+	for i := 0; i < 16; i++ {
+		res <<= 1
+		if b.clock(0) {
+			res |= 1
+		}
+	}
+	return res
+}
+
+func (b eepromBus) readAt(addr uint8) uint16 {
+	b.command(eepromOpRead, addr)
+	return b.readWord()
+}
+
+func TestEEPROM(t *testing.T) {
+	e := MakeEEPROM()
+	bus := eepromBus{write: e.WritePins, read: e.ReadPins}
+	ewen := func() { bus.command(eepromOpExtended, 0xC0) }
+	ewds := func() { bus.command(eepromOpExtended, 0x00) }
+
+	tests := []struct {
+		name string
+		run  func()
+		addr uint8
+		exp  uint16
+	}{
+		{"blank", func() {}, 0x05, 0xFFFF},
+		{"write protected", func() { bus.command(eepromOpWrite, 0x05); bus.data(0x1234) }, 0x05, 0xFFFF},
+		{"write", func() { ewen(); bus.command(eepromOpWrite, 0x05); bus.data(0x1234) }, 0x05, 0x1234},
+		{"address bit 7 ignored", func() {}, 0x85, 0x1234},
+		{"write all", func() { bus.command(eepromOpExtended, 0x40); bus.data(0xA55A) }, 0x7F, 0xA55A},
+		{"erase", func() { bus.command(eepromOpErase, 0x7F) }, 0x7F, 0xFFFF},
+		{"erase protected", func() { ewds(); bus.command(eepromOpErase, 0x05) }, 0x05, 0xA55A},
+		{"erase all", func() { ewen(); bus.command(eepromOpExtended, 0x80) }, 0x05, 0xFFFF},
+	}
+	for _, test := range tests {
+		test.run()
+		if v := bus.readAt(test.addr); v != test.exp {
+			t.Errorf("%s: read %04x at %02x (exp: %04x)", test.name, v, test.addr, test.exp)
+		}
+	}
+
+	// Sequential read, and the dummy zero before the data
+	bus.command(eepromOpWrite, 0x10)
+	bus.data(0x0102)
+	bus.command(eepromOpWrite, 0x11)
+	bus.data(0x0304)
+	bus.command(eepromOpRead, 0x10)
+	if e.ReadPins()&0x01 != 0 {
+		t.Errorf("missing the dummy zero bit")
+	}
+	if w0, w1 := bus.readWord(), bus.readWord(); w0 != 0x0102 || w1 != 0x0304 {
+		t.Errorf("sequential read %04x %04x (exp: 0102 0304)", w0, w1)
+	}
+
+	// Deselecting the chip aborts the command
+	bus.command(eepromOpWrite, 0x10)
+	bus.clock(1)
+	bus.write(0x00)
+	if v := bus.readAt(0x10); v != 0x0102 {
+		t.Errorf("aborted write changed the word to %04x", v)
+	}
+
+	sav := e.Bytes()
+	if sav[0x20] != 0x02 || sav[0x21] != 0x01 {
+		t.Errorf("the .sav file is not little endian: %x", sav[0x20:0x22])
+	}
+	loaded := MakeEEPROM()
+	if err := loaded.LoadBytes(sav); err != nil || loaded.Data != e.Data {
+		t.Errorf("unable to load the .sav file: %v", err)
+	}
+	if err := loaded.LoadBytes(sav[1:]); err == nil {
+		t.Errorf("invalid .sav size accepted")
+	}
+}
+
+func TestMBC7(t *testing.T) {
+	cons, err := makeTestConsole(makeTestROM(0x22, 4, 0x00, nil)) // MBC7+SENSOR+RUMBLE+RAM+BATTERY
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+	readAccel := func() (uint16, uint16) {
+		return uint16(cons.Read(0xA020)) | uint16(cons.Read(0xA030))<<8,
+			uint16(cons.Read(0xA040)) | uint16(cons.Read(0xA050))<<8
+	}
+
+	if v := cons.Read(0xA020); v != 0xFF {
+		t.Errorf("read %02x with the RAM disabled", v)
+	}
+	cons.Write(0x0000, 0x0A)
+	cons.Write(0x4000, 0x40)
+
+	tests := []struct {
+		name   string
+		x, y   float64
+		writes [][2]uint16
+		expX   uint16
+		expY   uint16
+	}{
+		{"reset", 0.5, -1, [][2]uint16{{0xA000, 0x55}}, 0x8000, 0x8000},
+		{"latch", 0.5, -1, [][2]uint16{{0xA010, 0xAA}}, MBC7AccelCenter + MBC7AccelGravity/2, MBC7AccelCenter - MBC7AccelGravity},
+		{"latched", 0, 0, nil, MBC7AccelCenter + MBC7AccelGravity/2, MBC7AccelCenter - MBC7AccelGravity},
+		{"latch without reset", 0, 0, [][2]uint16{{0xA010, 0xAA}}, MBC7AccelCenter + MBC7AccelGravity/2, MBC7AccelCenter - MBC7AccelGravity},
+		{"relatch", 0, 0, [][2]uint16{{0xA000, 0x55}, {0xA010, 0xAA}}, MBC7AccelCenter, MBC7AccelCenter},
+		{"saturated", 1000, -1000, [][2]uint16{{0xA000, 0x55}, {0xA010, 0xAA}}, 0xFFFF, 0x0000},
+	}
+	for _, test := range tests {
+		cons.SetTilt(test.x, test.y)
+		for _, w := range test.writes {
+			cons.Write(w[0], uint8(w[1]))
+		}
+		if x, y := readAccel(); x != test.expX || y != test.expY {
+			t.Errorf("%s: accelerometer %04x, %04x (exp: %04x, %04x)", test.name, x, y, test.expX, test.expY)
+		}
+	}
+
+	// The EEPROM is at A080
+	bus := eepromBus{
+		write: func(value uint8) { cons.Write(0xA080, value) },
+		read:  func() uint8 { return cons.Read(0xA080) },
+	}
+	bus.command(eepromOpExtended, 0xC0)
+	bus.command(eepromOpWrite, 0x00)
+	bus.data(0xBEEF)
+	if v := bus.readAt(0x00); v != 0xBEEF {
+		t.Errorf("read %04x from the EEPROM (exp: beef)", v)
+	}
+	if sav, _ := cons.StoreSav(); len(sav) != EEPROMSize || sav[0] != 0xEF {
+		t.Errorf("unexpected .sav file")
+	}
+}
@@ -0,0 +1,269 @@
+package gbc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expressions on the state of the console, used by the conditional
+// breakpoints. The syntax is the one of C, with:
+//   - numbers in decimal, hexadecimal (0x1F or $1F) or binary (0b101)
+//   - registers (a, f, b, ..., af, bc, de, hl, sp, pc) and flags (zf, nf,
+//     hf, cf), case insensitive
+//   - [addr], the byte in memory at addr
+//   - labels, with ParseExprWithSymbols
+//
+// For example: "a == 0x10 && [hl] != 0". The division by zero gives 0
+
+type DebugError string
+
+func (err DebugError) Error() string {
+	return string(err)
+}
+
+type Expr struct {
+	Source string
+	eval   func(cons *Console) int
+}
+
+func (e *Expr) Eval(cons *Console) int {
+	return e.eval(cons)
+}
+
+func ParseExpr(s string) (*Expr, error) {
+	return ParseExprWithSymbols(s, nil)
+}
+
+// ParseExprWithSymbols parses an expression that can use the addresses of
+// the labels in symbols (registers take precedence over labels)
+func ParseExprWithSymbols(s string, symbols *SymbolTable) (*Expr, error) {
+	p := &exprParser{src: s, symbols: symbols}
+	p.next()
+	eval, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, DebugError(fmt.Sprintf("Unexpected \"%s\" in expression", p.tok))
+	}
+	return &Expr{Source: s, eval: eval}, nil
+}
+
+type exprFunc func(cons *Console) int
+
+var exprRegisters = map[string]exprFunc{
+	"a":  func(c *Console) int { return int(c.CPU.A) },
+	"f":  func(c *Console) int { return int(c.CPU.PackFlags()) },
+	"b":  func(c *Console) int { return int(c.CPU.B) },
+	"c":  func(c *Console) int { return int(c.CPU.C) },
+	"d":  func(c *Console) int { return int(c.CPU.D) },
+	"e":  func(c *Console) int { return int(c.CPU.E) },
+	"h":  func(c *Console) int { return int(c.CPU.H) },
+	"l":  func(c *Console) int { return int(c.CPU.L) },
+	"af": func(c *Console) int { return int(c.CPU.A)<<8 | int(c.CPU.PackFlags()) },
+	"bc": func(c *Console) int { return int(c.CPU.B)<<8 | int(c.CPU.C) },
+	"de": func(c *Console) int { return int(c.CPU.D)<<8 | int(c.CPU.E) },
+	"hl": func(c *Console) int { return int(c.CPU.H)<<8 | int(c.CPU.L) },
+	"sp": func(c *Console) int { return int(c.CPU.SP) },
+	"pc": func(c *Console) int { return int(c.CPU.PC) },
+	"zf": func(c *Console) int { return int(c.CPU.PackFlags()>>7) & 1 },
+	"nf": func(c *Console) int { return int(c.CPU.PackFlags()>>6) & 1 },
+	"hf": func(c *Console) int { return int(c.CPU.PackFlags()>>5) & 1 },
+	"cf": func(c *Console) int { return int(c.CPU.PackFlags()>>4) & 1 },
+}
+
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// Binary operators, by increasing precedence
+var exprBinaryOps = []map[string]func(x, y int) int{
+	{"||": func(x, y int) int { return boolToInt(x != 0 || y != 0) }},
+	{"&&": func(x, y int) int { return boolToInt(x != 0 && y != 0) }},
+	{"|": func(x, y int) int { return x | y }},
+	{"^": func(x, y int) int { return x ^ y }},
+	{"&": func(x, y int) int { return x & y }},
+	{
+		"==": func(x, y int) int { return boolToInt(x == y) },
+		"!=": func(x, y int) int { return boolToInt(x != y) },
+	},
+	{
+		"<":  func(x, y int) int { return boolToInt(x < y) },
+		"<=": func(x, y int) int { return boolToInt(x <= y) },
+		">":  func(x, y int) int { return boolToInt(x > y) },
+		">=": func(x, y int) int { return boolToInt(x >= y) },
+	},
+	{
+		"<<": func(x, y int) int { return x << (y & 31) },
+		">>": func(x, y int) int { return x >> (y & 31) },
+	},
+	{
+		"+": func(x, y int) int { return x + y },
+		"-": func(x, y int) int { return x - y },
+	},
+	{
+		"*": func(x, y int) int { return x * y },
+		"/": func(x, y int) int {
+			if y == 0 {
+				return 0
+			}
+			return x / y
+		},
+		"%": func(x, y int) int {
+			if y == 0 {
+				return 0
+			}
+			return x % y
+		},
+	},
+}
+
+var exprOperators = []string{
+	"||", "&&", "==", "!=", "<=", ">=", "<<", ">>",
+	"|", "^", "&", "<", ">", "+", "-", "*", "/", "%", "!", "~", "(", ")", "[", "]",
+}
+
+type exprParser struct {
+	src     string
+	pos     int
+	tok     string
+	symbols *SymbolTable
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c == '.' ||
+		'0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// next reads the following token in tok, an empty token marks the end
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos += 1
+	}
+	if p.pos == len(p.src) {
+		p.tok = ""
+		return
+	}
+	rest := p.src[p.pos:]
+	for _, op := range exprOperators {
+		if strings.HasPrefix(rest, op) {
+			p.tok = op
+			p.pos += len(op)
+			return
+		}
+	}
+	end := p.pos
+	for end < len(p.src) && isIdentChar(p.src[end]) {
+		end += 1
+	}
+	if end == p.pos {
+		// Invalid character, reported by the parser
+		end += 1
+	}
+	p.tok = p.src[p.pos:end]
+	p.pos = end
+}
+
+func (p *exprParser) parseBinary(level int) (exprFunc, error) {
+	if level == len(exprBinaryOps) {
+		return p.parseUnary()
+	}
+	lhs, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := exprBinaryOps[level][p.tok]
+		if !ok {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l := lhs
+		lhs = func(c *Console) int { return op(l(c), rhs(c)) }
+	}
+}
+
+func (p *exprParser) parseUnary() (exprFunc, error) {
+	switch p.tok {
+	case "!", "-", "~":
+		op := p.tok
+		p.next()
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "!":
+			return func(c *Console) int { return boolToInt(arg(c) == 0) }, nil
+		case "-":
+			return func(c *Console) int { return -arg(c) }, nil
+		}
+		return func(c *Console) int { return ^arg(c) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) expect(tok string) error {
+	if p.tok != tok {
+		return DebugError(fmt.Sprintf("Expected \"%s\" in expression", tok))
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprParser) parsePrimary() (exprFunc, error) {
+	tok := p.tok
+	switch tok {
+	case "":
+		return nil, DebugError("Unexpected end of expression")
+	case "(", "[":
+		p.next()
+		arg, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if tok == "(" {
+			return arg, p.expect(")")
+		}
+		return func(c *Console) int { return int(c.Read(uint16(arg(c)))) }, p.expect("]")
+	}
+
+	p.next()
+	if reg, ok := exprRegisters[strings.ToLower(tok)]; ok {
+		return reg, nil
+	}
+	if p.symbols != nil {
+		if sym, ok := p.symbols.Lookup(tok); ok {
+			return func(c *Console) int { return int(sym.Addr) }, nil
+		}
+	}
+	v, err := parseNumber(tok)
+	if err != nil {
+		return nil, DebugError(fmt.Sprintf("Unknown \"%s\" in expression", tok))
+	}
+	return func(c *Console) int { return v }, nil
+}
+
+// parseNumber accepts decimal, hexadecimal (0x or $) and binary (0b) numbers
+func parseNumber(s string) (int, error) {
+	base := 10
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		base, s = 16, s[2:]
+	case strings.HasPrefix(lower, "$"):
+		base, s = 16, s[1:]
+	case strings.HasPrefix(lower, "0b"):
+		base, s = 2, s[2:]
+	}
+	v, err := strconv.ParseInt(s, base, 32)
+	return int(v), err
+}
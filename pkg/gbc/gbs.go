@@ -0,0 +1,371 @@
+package gbc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+)
+
+// GBS files contain the sound engine and the music data of a game. The
+// player builds a cartridge around them, calls the init routine with the
+// track number and then the play routine at every VBlank, or at every
+// Timer interrupt
+
+const GBSHeaderSize = 0x70
+
+var gbsMagic = []byte("GBS")
+
+type GBSHeader struct {
+	Version      uint8
+	NumTracks    uint8
+	FirstTrack   uint8 // starting from 1
+	LoadAddr     uint16
+	InitAddr     uint16
+	PlayAddr     uint16
+	StackPointer uint16
+	TimerModulo  uint8
+	TimerControl uint8
+	Title        string
+	Author       string
+	Copyright    string
+}
+
+type GBS struct {
+	Header GBSHeader
+	Data   []byte
+}
+
+func IsGBS(data []byte) bool {
+	return bytes.HasPrefix(data, gbsMagic)
+}
+
+func gbsString(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+func LoadGBS(data []byte) (*GBS, error) {
+	if !IsGBS(data) {
+		return nil, CartError("Invalid GBS magic")
+	}
+	if len(data) < GBSHeaderSize {
+		return nil, CartError("Unable to read the GBS header: not enough data in file")
+	}
+
+	h := GBSHeader{
+		Version:      data[0x03],
+		NumTracks:    data[0x04],
+		FirstTrack:   data[0x05],
+		LoadAddr:     binary.LittleEndian.Uint16(data[0x06:]),
+		InitAddr:     binary.LittleEndian.Uint16(data[0x08:]),
+		PlayAddr:     binary.LittleEndian.Uint16(data[0x0A:]),
+		StackPointer: binary.LittleEndian.Uint16(data[0x0C:]),
+		TimerModulo:  data[0x0E],
+		TimerControl: data[0x0F],
+		Title:        gbsString(data[0x10:0x30]),
+		Author:       gbsString(data[0x30:0x50]),
+		Copyright:    gbsString(data[0x50:0x70]),
+	}
+	if h.Version != 1 {
+		return nil, CartError(fmt.Sprintf("Unsupported GBS version %d", h.Version))
+	}
+	if h.NumTracks == 0 {
+		return nil, CartError("The GBS file has no tracks")
+	}
+	if h.FirstTrack == 0 || h.FirstTrack > h.NumTracks {
+		h.FirstTrack = 1
+	}
+	// The area below the load address hosts the stubs of the player
+	if h.LoadAddr < 0x400 || h.LoadAddr >= 0x8000 {
+		return nil, CartError(fmt.Sprintf("Invalid GBS load address 0x%04x", h.LoadAddr))
+	}
+	if int(h.LoadAddr)+len(data)-GBSHeaderSize > 256*0x4000 {
+		return nil, CartError("The GBS data does not fit in the cartridge")
+	}
+	if h.StackPointer == 0 {
+		h.StackPointer = 0xFFFE
+	}
+	return &GBS{Header: h, Data: data[GBSHeaderSize:]}, nil
+}
+
+// The play routine is called from the Timer interrupt, instead of VBlank
+func (gbs *GBS) UsesTimer() bool {
+	return gbs.Header.TimerControl&0x04 != 0
+}
+
+// The bit 7 of the timer control selects the CGB double speed mode
+func (gbs *GBS) DoubleSpeed() bool {
+	return gbs.Header.TimerControl&0x80 != 0
+}
+
+const (
+	gbsPlayStub = 0x0070
+	gbsInitStub = 0x0150
+)
+
+// buildROM lays out the GBS data at its load address, plus:
+//   - 0000 - 0038: RST vectors, redirected to load address + vector
+//   - 0040, 0050:  VBlank and Timer interrupts, calling the play stub
+//   - 0070:        play stub, calls the play routine preserving the registers
+//   - 0100:        entry point, jumps to the init stub
+//   - 0150:        init stub, sets up the hardware, calls the init routine
+//     with the track and waits for interrupts
+func (gbs *GBS) buildROM(track int) []byte {
+	h := &gbs.Header
+	size := 0x8000
+	for size < int(h.LoadAddr)+len(gbs.Data) {
+		size += 0x4000
+	}
+	rom := make([]byte, size)
+	copy(rom[h.LoadAddr:], gbs.Data)
+
+	lo := func(v uint16) byte { return byte(v) }
+	hi := func(v uint16) byte { return byte(v >> 8) }
+
+	for vec := uint16(0); vec <= 0x38; vec += 8 {
+		rom[vec] = 0xC3 // JP load+vec
+		rom[vec+1] = lo(h.LoadAddr + vec)
+		rom[vec+2] = hi(h.LoadAddr + vec)
+	}
+	for vec := uint16(0x40); vec <= 0x60; vec += 8 {
+		rom[vec] = 0xD9 // RETI
+	}
+	for _, vec := range []uint16{InterruptVBlank.Addr, InterruptTimer.Addr} {
+		rom[vec] = 0xC3 // JP play stub
+		rom[vec+1] = lo(gbsPlayStub)
+		rom[vec+2] = hi(gbsPlayStub)
+	}
+
+	copy(rom[gbsPlayStub:], []byte{
+		0xF5, 0xC5, 0xD5, 0xE5, // PUSH AF, BC, DE, HL
+		0xCD, lo(h.PlayAddr), hi(h.PlayAddr), // CALL play
+		0xE1, 0xD1, 0xC1, 0xF1, // POP HL, DE, BC, AF
+		0xD9, // RETI
+	})
+
+	copy(rom[0x100:], []byte{0x00, 0xC3, lo(gbsInitStub), hi(gbsInitStub)})
+	rom[0x143] = 0x00
+	if gbs.DoubleSpeed() {
+		rom[0x143] = 0x80
+	}
+	rom[0x147] = 0x19 // MBC5, the closest real cartridge
+
+	ie := InterruptVBlank.Mask
+	if gbs.UsesTimer() {
+		ie = InterruptTimer.Mask
+	}
+	code := []byte{
+		0xF3,                                         // DI
+		0x31, lo(h.StackPointer), hi(h.StackPointer), // LD SP, sp
+	}
+	if gbs.DoubleSpeed() {
+		code = append(code,
+			0x3E, 0x01, // LD A, 1
+			0xE0, 0x4D, // LDH (KEY1), A
+			0x10, 0x00, // STOP
+		)
+	}
+	code = append(code,
+		0xAF, 0xE0, 0x40, // XOR A; LDH (LCDC), A: the LCD is not used
+		0x3E, 0x80, 0xE0, 0x26, // LD A, 0x80; LDH (NR52), A
+		0x3E, 0x77, 0xE0, 0x24, // LD A, 0x77; LDH (NR50), A
+		0x3E, 0xFF, 0xE0, 0x25, // LD A, 0xFF; LDH (NR51), A
+		0x3E, h.TimerModulo, 0xE0, 0x06, // LD A, tma; LDH (TMA), A
+		0x3E, h.TimerControl&0x07, 0xE0, 0x07, // LD A, tac; LDH (TAC), A
+		0x3E, uint8(track), // LD A, track
+		0xCD, lo(h.InitAddr), hi(h.InitAddr), // CALL init
+		0x3E, ie, 0xE0, 0xFF, // LD A, ie; LDH (IE), A
+		0xAF, 0xE0, 0x0F, // XOR A; LDH (IF), A
+		0xFB,       // EI
+		0x76,       // HALT
+		0x18, 0xFD, // JR -3
+	)
+	copy(rom[gbsInitStub:], code)
+	return rom
+}
+
+// GBSMapper maps the GBS data like a MBC1 without RAM enable. When the
+// Timer is not used, it also generates the VBlank interrupts, since the
+// LCD is off
+type GBSMapper struct {
+	cart    *Cart
+	console *Console
+
+	useVBlank   bool
+	vblankTicks int
+
+	romBank int // 2000 - 3FFF
+}
+
+// CPU M-cycles between two VBlanks, in normal speed mode
+const gbsFrameTicks = 70224 / 4
+
+func (m *GBSMapper) MapperSave(encoder *gob.Encoder) {
+	panicIfErr(encoder.Encode(m.romBank))
+	panicIfErr(encoder.Encode(m.vblankTicks))
+}
+
+func (m *GBSMapper) MapperLoad(decoder *gob.Decoder) error {
+	errs := []error{
+		decoder.Decode(&m.romBank),
+		decoder.Decode(&m.vblankTicks),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func MakeGBSMapper(cart *Cart, useVBlank bool) *GBSMapper {
+	return &GBSMapper{
+		cart:      cart,
+		useVBlank: useVBlank,
+		romBank:   1,
+	}
+}
+
+func (m *GBSMapper) ROMBank(addr uint16) int {
+	if addr <= 0x3FFF {
+		return 0
+	}
+	return m.romBank
+}
+
+func (m *GBSMapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	return m.cart.readBanked(bank, addr)
+}
+
+func (m *GBSMapper) MapperRead(addr uint16) uint8 {
+	switch {
+	case addr <= 0x3FFF:
+		return m.cart.ROMBanks[0][addr]
+	case 0x4000 <= addr && addr <= 0x7FFF:
+		return m.cart.ROMBanks[m.romBank][addr&0x3FFF]
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		return m.cart.RAMBanks[0][addr&0x1FFF]
+	}
+	fmt.Printf("Unexpected address in GBSMapper Read: 0x%04x\n", addr)
+	return 0xFF
+}
+
+func (m *GBSMapper) MapperWrite(addr uint16, value uint8) {
+	switch {
+	case 0x2000 <= addr && addr <= 0x3FFF:
+		// As in MBC1, bank 0 selects bank 1
+		m.romBank = int(value) % len(m.cart.ROMBanks)
+		if m.romBank == 0 {
+			m.romBank = 1
+		}
+	case addr <= 0x7FFF:
+		// ignored
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		m.cart.RAMBanks[0][addr&0x1FFF] = value
+	default:
+		fmt.Printf("Unexpected address in GBSMapper Write: 0x%04x <- 0x%02x\n", addr, value)
+	}
+}
+
+func (m *GBSMapper) tick(cpuTicks int) {
+	if !m.useVBlank || m.console == nil {
+		return
+	}
+	frameTicks := gbsFrameTicks
+	if m.console.DoubleSpeedMode {
+		frameTicks *= 2
+	}
+	m.vblankTicks += cpuTicks
+	if m.vblankTicks >= frameTicks {
+		m.vblankTicks -= frameTicks
+		m.console.CPU.SetInterrupt(InterruptVBlank.Mask)
+	}
+}
+
+// MakeGBSConsole creates a console playing a track (starting from 0) of
+// the GBS file
+func MakeGBSConsole(gbs *GBS, track int, frontend Frontend) (*Console, error) {
+	if track < 0 || track >= int(gbs.Header.NumTracks) {
+		return nil, CartError(fmt.Sprintf("Invalid track %d (the file has %d tracks)", track+1, gbs.Header.NumTracks))
+	}
+
+	rom := gbs.buildROM(track)
+	cart := &Cart{clock: RealClock{}}
+	header, err := readHeader(rom, 0)
+	if err != nil {
+		return nil, err
+	}
+	cart.header = header
+	cart.ROMBanks = make([][16384]uint8, len(rom)/16384)
+	for i := range cart.ROMBanks {
+		copy(cart.ROMBanks[i][:], rom[i*16384:])
+	}
+	cart.RAMBanks = make([][8192]uint8, 1)
+	mapper := MakeGBSMapper(cart, !gbs.UsesTimer())
+	cart.Map = mapper
+
+	report := &LoadReport{FileSize: len(rom), ROMBanks: len(cart.ROMBanks)}
+	res := makeConsoleWithCart(rom, cart, report, frontend)
+	mapper.console = res
+
+	// No boot ROM, the init stub sets up the hardware
+	res.InBootROM = false
+	res.CPU.PC = 0x100
+	return res, nil
+}
+
+// GBSPlayer plays the tracks of a GBS file, using a new console for each
+// track
+type GBSPlayer struct {
+	GBS      *GBS
+	Console  *Console
+	Track    int // starting from 0
+	frontend Frontend
+}
+
+func MakeGBSPlayer(gbs *GBS, frontend Frontend) (*GBSPlayer, error) {
+	res := &GBSPlayer{
+		GBS:      gbs,
+		frontend: frontend,
+	}
+	if err := res.PlayTrack(int(gbs.Header.FirstTrack) - 1); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (p *GBSPlayer) NumTracks() int {
+	return int(p.GBS.Header.NumTracks)
+}
+
+// PlayTrack restarts the player from a track, keeping the volume
+func (p *GBSPlayer) PlayTrack(track int) error {
+	console, err := MakeGBSConsole(p.GBS, track, p.frontend)
+	if err != nil {
+		return err
+	}
+	if p.Console != nil {
+		console.APU.playing = p.Console.APU.playing
+		console.APU.globalVolume = p.Console.APU.globalVolume
+	}
+	p.Console = console
+	p.Track = track
+	return nil
+}
+
+// NextTrack and PrevTrack wrap around the list of tracks
+func (p *GBSPlayer) NextTrack() error {
+	return p.PlayTrack((p.Track + 1) % p.NumTracks())
+}
+
+func (p *GBSPlayer) PrevTrack() error {
+	return p.PlayTrack((p.Track + p.NumTracks() - 1) % p.NumTracks())
+}
+
+// Step runs a frame worth of CPU cycles
+func (p *GBSPlayer) Step() int {
+	return p.Console.Step()
+}
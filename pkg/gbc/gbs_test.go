@@ -0,0 +1,140 @@
+package gbc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// The init routine stores the track at C000, the play routine counts its
+// calls at C001
+var gbsTestCode = []byte{
+	0xEA, 0x00, 0xC0, // LD (C000), A
+	0xC9,             // RET
+	0x21, 0x01, 0xC0, // LD HL, C001
+	0x34, // INC (HL)
+	0xC9, // RET
+}
+
+type gbsFields struct {
+	version, tracks, first uint8
+	load, sp               uint16
+	tac                    uint8
+	data                   []byte
+}
+
+func makeGBS(f gbsFields) []byte {
+	res := make([]byte, GBSHeaderSize, GBSHeaderSize+len(f.data))
+	copy(res, gbsMagic)
+	res[0x03], res[0x04], res[0x05] = f.version, f.tracks, f.first
+	binary.LittleEndian.PutUint16(res[0x06:], f.load)
+	binary.LittleEndian.PutUint16(res[0x08:], f.load)   // init
+	binary.LittleEndian.PutUint16(res[0x0A:], f.load+4) // play
+	binary.LittleEndian.PutUint16(res[0x0C:], f.sp)
+	res[0x0E], res[0x0F] = 0xF0, f.tac
+	copy(res[0x10:], "Title")
+	copy(res[0x30:], "Author")
+	copy(res[0x50:], "Copyright")
+	return append(res, f.data...)
+}
+
+func TestLoadGBS(t *testing.T) {
+	valid := gbsFields{1, 4, 2, 0x400, 0xDFFF, 0x00, gbsTestCode}
+	tests := []struct {
+		name  string
+		data  []byte
+		ok    bool
+		first uint8
+		sp    uint16
+	}{
+		{"valid", makeGBS(valid), true, 2, 0xDFFF},
+		{"invalid magic", append([]byte("GBX"), makeGBS(valid)[3:]...), false, 0, 0},
+		{"truncated header", makeGBS(valid)[:GBSHeaderSize-1], false, 0, 0},
+		{"version 2", makeGBS(gbsFields{2, 4, 2, 0x400, 0xDFFF, 0x00, nil}), false, 0, 0},
+		{"no tracks", makeGBS(gbsFields{1, 0, 1, 0x400, 0xDFFF, 0x00, nil}), false, 0, 0},
+		{"first track out of range", makeGBS(gbsFields{1, 4, 5, 0x400, 0xDFFF, 0x00, nil}), true, 1, 0xDFFF},
+		{"default stack pointer", makeGBS(gbsFields{1, 4, 1, 0x400, 0x0000, 0x00, nil}), true, 1, 0xFFFE},
+		{"load address over the stubs", makeGBS(gbsFields{1, 4, 1, 0x3FF, 0xDFFF, 0x00, nil}), false, 0, 0},
+		{"load address over the RAM", makeGBS(gbsFields{1, 4, 1, 0x8000, 0xDFFF, 0x00, nil}), false, 0, 0},
+		{"too big", makeGBS(gbsFields{1, 4, 1, 0x400, 0xDFFF, 0x00, make([]byte, 256*0x4000)}), false, 0, 0},
+	}
+	for _, test := range tests {
+		gbs, err := LoadGBS(test.data)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: LoadGBS returned %v", test.name, err)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		h := gbs.Header
+		if h.FirstTrack != test.first || h.StackPointer != test.sp {
+			t.Errorf("%s: first track %d, stack pointer %04x", test.name, h.FirstTrack, h.StackPointer)
+		}
+		if h.Title != "Title" || h.Author != "Author" || h.Copyright != "Copyright" || h.TimerModulo != 0xF0 {
+			t.Errorf("%s: unexpected header %+v", test.name, h)
+		}
+	}
+}
+
+func TestGBSBuildROM(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  gbsFields
+		size    int
+		play    uint16 // interrupt vector calling the play stub
+		cgbFlag uint8
+	}{
+		{"vblank", gbsFields{1, 4, 1, 0x400, 0xDFFF, 0x00, gbsTestCode}, 0x8000, InterruptVBlank.Addr, 0x00},
+		{"timer", gbsFields{1, 4, 1, 0x400, 0xDFFF, 0x04, gbsTestCode}, 0x8000, InterruptTimer.Addr, 0x00},
+		{"double speed", gbsFields{1, 4, 1, 0x400, 0xDFFF, 0x84, gbsTestCode}, 0x8000, InterruptTimer.Addr, 0x80},
+		{"bigger than 32KB", gbsFields{1, 4, 1, 0x3000, 0xDFFF, 0x00, make([]byte, 0x5001)}, 0xC000, InterruptVBlank.Addr, 0x00},
+	}
+	for _, test := range tests {
+		gbs, err := LoadGBS(makeGBS(test.fields))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		rom := gbs.buildROM(3)
+		if len(rom) != test.size {
+			t.Errorf("%s: the ROM is %d bytes (exp: %d)", test.name, len(rom), test.size)
+			continue
+		}
+		if !bytes.Equal(rom[test.fields.load:int(test.fields.load)+len(gbs.Data)], gbs.Data) {
+			t.Errorf("%s: the data is not at the load address", test.name)
+		}
+		// RST 08 jumps to load address + 8
+		if rom[0x08] != 0xC3 || binary.LittleEndian.Uint16(rom[0x09:]) != test.fields.load+8 {
+			t.Errorf("%s: unexpected RST 08 vector % x", test.name, rom[0x08:0x0B])
+		}
+		if rom[test.play] != 0xC3 || binary.LittleEndian.Uint16(rom[test.play+1:]) != gbsPlayStub {
+			t.Errorf("%s: the interrupt %04x does not call the play stub", test.name, test.play)
+		}
+		if rom[0x143] != test.cgbFlag || rom[0x147] != 0x19 {
+			t.Errorf("%s: CGB flag %02x, cartridge type %02x", test.name, rom[0x143], rom[0x147])
+		}
+		if hasStop := bytes.Contains(rom[gbsInitStub:gbsInitStub+0x40], []byte{0x10, 0x00}); hasStop != (test.cgbFlag != 0) {
+			t.Errorf("%s: the speed switch is %v", test.name, hasStop)
+		}
+	}
+}
+
+func TestGBSConsole(t *testing.T) {
+	gbs, err := LoadGBS(makeGBS(gbsFields{1, 4, 1, 0x400, 0xDFFF, 0x00, gbsTestCode}))
+	if err != nil {
+		t.Fatalf("unable to load the GBS file: %s", err)
+	}
+	if _, err := MakeGBSConsole(gbs, 4, testFrontend{}); err == nil {
+		t.Errorf("invalid track accepted")
+	}
+	cons, err := MakeGBSConsole(gbs, 2, testFrontend{})
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		cons.Step()
+	}
+	if track, calls := cons.Read(0xC000), cons.Read(0xC001); track != 2 || calls < 9 || calls > 10 {
+		t.Errorf("init called with track %d, play called %d times (exp: 2, 10)", track, calls)
+	}
+}
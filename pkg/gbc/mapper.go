@@ -1,8 +1,10 @@
 package gbc
 
 import (
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
+	"time"
 )
 
 type Mapper interface {
@@ -12,6 +14,49 @@ type Mapper interface {
 	MapperLoad(decoder *gob.Decoder) error
 }
 
+// SavMapper is implemented by mappers whose battery backed memory is not
+// (only) the content of Cart.RAMBanks. Console.StoreSav and Console.LoadSav
+// delegate to it when available.
+type SavMapper interface {
+	StoreSav() []byte
+	LoadSav(data []byte) error
+}
+
+// Implemented by mappers that need to be clocked (e.g., to complete an
+// operation after some time)
+type tickingMapper interface {
+	tick(cpuTicks int)
+}
+
+// Implemented by mappers with switchable banks. ROMBank returns the bank
+// mapped at an address of the ROM area (0000 - 7FFF), MapperReadBanked
+// reads an address of the ROM or of the RAM area (A000 - BFFF) in a given
+// bank, regardless of the banks currently mapped
+type bankedMapper interface {
+	ROMBank(addr uint16) int
+	MapperReadBanked(bank int, addr uint16) uint8
+}
+
+// Implemented by mappers of carts with a rumble motor
+type rumbleMapper interface {
+	setRumbleFrontend(frontend RumbleFrontend)
+}
+
+// Implemented by mappers with an accelerometer
+type tiltMapper interface {
+	setTilt(x, y float64)
+}
+
+// Implemented by mappers that keep track of time
+type clockedMapper interface {
+	setClock(clock Clock)
+}
+
+// Implemented by mappers with a camera
+type cameraMapper interface {
+	setCameraSource(source CameraSource)
+}
+
 func calculateMask(value uint) uint {
 	if value == 0 {
 		return 0
@@ -28,6 +73,8 @@ func calculateMask(value uint) uint {
 	return mask
 }
 
+// ROM only carts, optionally with up to 8KB of RAM (ROM+RAM and
+// ROM+RAM+BATTERY). There are no registers, the RAM is always enabled
 type ROMOnlyMapper struct {
 	cart *Cart
 }
@@ -35,13 +82,44 @@ type ROMOnlyMapper struct {
 func (m ROMOnlyMapper) MapperSave(encoder *gob.Encoder)       {}
 func (m ROMOnlyMapper) MapperLoad(decoder *gob.Decoder) error { return nil }
 
+func (m ROMOnlyMapper) ROMBank(addr uint16) int {
+	return int(addr >> 14)
+}
+
+func (m ROMOnlyMapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	return m.cart.readBanked(bank, addr)
+}
+
 func (m ROMOnlyMapper) MapperRead(addr uint16) uint8 {
-	bank_n := addr >> 14
-	return m.cart.ROMBanks[bank_n][addr&0x3fff]
+	switch {
+	case addr <= 0x7FFF:
+		bank_n := addr >> 14
+		return m.cart.ROMBanks[bank_n][addr&0x3fff]
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if len(m.cart.RAMBanks) == 0 {
+			return 0xFF
+		}
+		return m.cart.RAMBanks[0][addr&0x1FFF]
+	}
+
+	fmt.Printf("Unexpected address in ROMOnlyMapper Read: 0x%04x\n", addr)
+	return 0
 }
 
 func (m ROMOnlyMapper) MapperWrite(addr uint16, value uint8) {
-	fmt.Printf("Trying to write on ROMOnlyMapper @ 0x%04x <- 0x%02x\n", addr, value)
+	switch {
+	case addr <= 0x7FFF:
+		// No registers, the write is ignored
+		return
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if len(m.cart.RAMBanks) == 0 {
+			return
+		}
+		m.cart.RAMBanks[0][addr&0x1FFF] = value
+		return
+	}
+
+	fmt.Printf("Unexpected address in ROMOnlyMapper Write: 0x%04x <- 0x%02x\n", addr, value)
 }
 
 type MBC1Mapper struct {
@@ -53,6 +131,10 @@ type MBC1Mapper struct {
 	romBank        uint8 // 2000 - 3FFF
 	ramBank        uint8 // 4000 - 5FFF
 	advBankingMode bool  // 6000 - 7FFF
+
+	// In multicart (MBC1M) carts the bit 4 of the ROM bank register is
+	// not wired and the secondary register is shifted by 4 instead of 5
+	multicart bool
 }
 
 func (m *MBC1Mapper) MapperSave(encoder *gob.Encoder) {
@@ -62,6 +144,7 @@ func (m *MBC1Mapper) MapperSave(encoder *gob.Encoder) {
 	panicIfErr(encoder.Encode(m.romBank))
 	panicIfErr(encoder.Encode(m.ramBank))
 	panicIfErr(encoder.Encode(m.advBankingMode))
+	panicIfErr(encoder.Encode(m.multicart))
 }
 
 func (m *MBC1Mapper) MapperLoad(decoder *gob.Decoder) error {
@@ -72,6 +155,7 @@ func (m *MBC1Mapper) MapperLoad(decoder *gob.Decoder) error {
 		decoder.Decode(&m.romBank),
 		decoder.Decode(&m.ramBank),
 		decoder.Decode(&m.advBankingMode),
+		decoder.Decode(&m.multicart),
 	}
 
 	for _, err := range errs {
@@ -82,7 +166,7 @@ func (m *MBC1Mapper) MapperLoad(decoder *gob.Decoder) error {
 	return nil
 }
 
-func MakeMBC1Mapper(cart *Cart) *MBC1Mapper {
+func MakeMBC1Mapper(cart *Cart, multicart bool) *MBC1Mapper {
 	return &MBC1Mapper{
 		cart:           cart,
 		bankMask:       uint8(calculateMask(uint(len(cart.ROMBanks)))),
@@ -91,23 +175,41 @@ func MakeMBC1Mapper(cart *Cart) *MBC1Mapper {
 		romBank:        1,
 		ramBank:        0,
 		advBankingMode: false,
+		multicart:      multicart,
 	}
 }
 
-func (m *MBC1Mapper) MapperRead(addr uint16) uint8 {
-	switch {
-	case addr <= 0x3FFF:
+func (m *MBC1Mapper) secondaryShift() int {
+	if m.multicart {
+		return 4
+	}
+	return 5
+}
+
+func (m *MBC1Mapper) ROMBank(addr uint16) int {
+	if addr <= 0x3FFF {
 		if !m.advBankingMode {
-			return m.cart.ROMBanks[0][addr]
+			return 0
 		}
-		bankOff := int(m.ramBank) << 5
-		bankOff &= int(m.bankMask)
-		return m.cart.ROMBanks[bankOff][addr]
-	case 0x4000 <= addr && addr <= 0x7FFF:
-		off := addr & 0x3FFF
-		bank := int(m.romBank) | int(m.ramBank<<5)
-		bank &= int(m.bankMask)
-		return m.cart.ROMBanks[bank][off]
+		bankOff := int(m.ramBank) << m.secondaryShift()
+		return bankOff & int(m.bankMask)
+	}
+	romBank := int(m.romBank)
+	if m.multicart {
+		romBank &= 0xF
+	}
+	bank := romBank | int(m.ramBank)<<m.secondaryShift()
+	return bank & int(m.bankMask)
+}
+
+func (m *MBC1Mapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	return m.cart.readBanked(bank, addr)
+}
+
+func (m *MBC1Mapper) MapperRead(addr uint16) uint8 {
+	switch {
+	case addr <= 0x7FFF:
+		return m.cart.ROMBanks[m.ROMBank(addr)][addr&0x3FFF]
 	case 0xA000 <= addr && addr <= 0xBFFF:
 		if len(m.cart.RAMBanks) == 0 || !m.ramEnabled {
 			return 0xFF
@@ -164,6 +266,139 @@ func (m *MBC1Mapper) MapperWrite(addr uint16, value uint8) {
 	fmt.Printf("Unexpected address in MBC1Mapper Write: 0x%04x <- 0x%02x\n", addr, value)
 }
 
+const MBC2RamSize = 512
+
+type MBC2Mapper struct {
+	cart     *Cart
+	bankMask uint8
+
+	ram        [MBC2RamSize]uint8 // 512x4 bits built-in RAM
+	hasBattery bool               // Only MBC2+BATTERY (0x06) keeps the RAM
+
+	ramEnabled bool  // 0000 - 3FFF, address bit 8 clear
+	romBank    uint8 // 0000 - 3FFF, address bit 8 set
+}
+
+func (m *MBC2Mapper) MapperSave(encoder *gob.Encoder) {
+	panicIfErr(encoder.Encode(m.bankMask))
+	panicIfErr(encoder.Encode(m.ram))
+	panicIfErr(encoder.Encode(m.ramEnabled))
+	panicIfErr(encoder.Encode(m.romBank))
+}
+
+func (m *MBC2Mapper) MapperLoad(decoder *gob.Decoder) error {
+	errs := []error{
+		decoder.Decode(&m.bankMask),
+		decoder.Decode(&m.ram),
+		decoder.Decode(&m.ramEnabled),
+		decoder.Decode(&m.romBank),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MBC2Mapper) StoreSav() []byte {
+	if !m.hasBattery {
+		return []byte{}
+	}
+	res := make([]byte, MBC2RamSize)
+	copy(res, m.ram[:])
+	return res
+}
+
+func (m *MBC2Mapper) LoadSav(data []byte) error {
+	if !m.hasBattery {
+		// Nothing is persisted without a battery, a SAV stored by an
+		// older version is ignored
+		return nil
+	}
+	if len(data) != MBC2RamSize {
+		return CartError("Invalid SAV file")
+	}
+	for i := 0; i < MBC2RamSize; i++ {
+		m.ram[i] = data[i] & 0xF
+	}
+	return nil
+}
+
+func MakeMBC2Mapper(cart *Cart) *MBC2Mapper {
+	return &MBC2Mapper{
+		cart:       cart,
+		bankMask:   uint8(calculateMask(uint(len(cart.ROMBanks)))),
+		hasBattery: cart.header.CartridgeType == 0x06,
+		ramEnabled: false,
+		romBank:    1,
+	}
+}
+
+func (m *MBC2Mapper) ROMBank(addr uint16) int {
+	if addr <= 0x3FFF {
+		return 0
+	}
+	return int(m.romBank & m.bankMask)
+}
+
+func (m *MBC2Mapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	if addr <= 0x7FFF {
+		return m.cart.readBanked(bank, addr)
+	}
+	// The built-in RAM has no banks
+	return m.ram[addr&0x1FF] | 0xF0
+}
+
+func (m *MBC2Mapper) MapperRead(addr uint16) uint8 {
+	switch {
+	case addr <= 0x3FFF:
+		return m.cart.ROMBanks[0][addr]
+	case 0x4000 <= addr && addr <= 0x7FFF:
+		off := addr & 0x3FFF
+		bank := m.romBank & m.bankMask
+		return m.cart.ROMBanks[bank][off]
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if !m.ramEnabled {
+			return 0xFF
+		}
+		// Only the lower 9 bits are decoded, the RAM is echoed
+		// in the whole A000-BFFF range. The upper nibble is not
+		// connected and always reads as 1s
+		return m.ram[addr&0x1FF] | 0xF0
+	}
+
+	fmt.Printf("Unexpected address in MBC2Mapper Read: 0x%04x\n", addr)
+	return 0
+}
+
+func (m *MBC2Mapper) MapperWrite(addr uint16, value uint8) {
+	switch {
+	case addr <= 0x3FFF:
+		// Bit 8 of the address selects the register
+		if addr&0x100 == 0 {
+			m.ramEnabled = value&0xF == 0xA
+		} else {
+			m.romBank = value & 0xF
+			if m.romBank == 0 {
+				m.romBank = 1
+			}
+		}
+		return
+	case 0x4000 <= addr && addr <= 0x7FFF:
+		// No registers here
+		return
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if !m.ramEnabled {
+			return
+		}
+		m.ram[addr&0x1FF] = value & 0xF
+		return
+	}
+
+	fmt.Printf("Unexpected address in MBC2Mapper Write: 0x%04x <- 0x%02x\n", addr, value)
+}
+
 type MBC3Mapper struct {
 	cart *Cart
 
@@ -171,6 +406,7 @@ type MBC3Mapper struct {
 	rtcRegVal    uint8
 	rtcLastLatch uint8
 	rtc          RTC
+	hasRTC       bool
 
 	ramEnabled bool   // 0000 - 1FFF
 	rtcEnabled bool   // 0000 - 1FFF
@@ -209,19 +445,63 @@ func (m *MBC3Mapper) MapperLoad(decoder *gob.Decoder) error {
 	return nil
 }
 
+func (m *MBC3Mapper) StoreSav() []byte {
+	res := m.cart.storeRAM()
+	if m.hasRTC {
+		res = append(res, m.rtc.Footer().Bytes()...)
+	}
+	return res
+}
+
+func (m *MBC3Mapper) LoadSav(data []byte) error {
+	ramSize := len(m.cart.RAMBanks) * 8192
+	if len(data) < ramSize {
+		return CartError("Invalid SAV file")
+	}
+
+	// Tolerate saves with and without the RTC footer
+	if len(data) > ramSize {
+		if !m.hasRTC {
+			return CartError("Invalid SAV file")
+		}
+		footer, err := ParseRTCFooter(data[ramSize:])
+		if err != nil {
+			return err
+		}
+		m.rtc.LoadFooter(footer)
+	}
+	return m.cart.loadRAM(data[:ramSize])
+}
+
 func MakeMBC3Mapper(cart *Cart) *MBC3Mapper {
 	res := &MBC3Mapper{
 		cart:       cart,
+		hasRTC:     cart.header.CartridgeType == 0x0F || cart.header.CartridgeType == 0x10,
 		rtcMapped:  false,
 		rtcRegVal:  0x08,
 		ramEnabled: false,
 		romBank:    1,
 		ramBank:    0,
-		rtc:        MakeRTC(),
+		rtc:        MakeRTC(cart.clock),
 	}
 	return res
 }
 
+func (m *MBC3Mapper) setClock(clock Clock) {
+	m.rtc.SetClock(clock)
+}
+
+func (m *MBC3Mapper) ROMBank(addr uint16) int {
+	if addr <= 0x3FFF {
+		return 0
+	}
+	return int(m.romBank)
+}
+
+func (m *MBC3Mapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	return m.cart.readBanked(bank, addr)
+}
+
 func (m *MBC3Mapper) MapperRead(addr uint16) uint8 {
 	switch {
 	case addr <= 0x3FFF:
@@ -266,6 +546,9 @@ func (m *MBC3Mapper) MapperWrite(addr uint16, value uint8) {
 	case 0x4000 <= addr && addr <= 0x5FFF:
 		if value <= 0x3 {
 			m.rtcMapped = false
+			if len(m.cart.RAMBanks) == 0 {
+				return
+			}
 			m.ramBank = value
 			m.ramBank %= uint8(len(m.cart.RAMBanks))
 		} else if 0x8 <= value && value <= 0xC {
@@ -286,6 +569,7 @@ func (m *MBC3Mapper) MapperWrite(addr uint16, value uint8) {
 			}
 			off := addr & 0x1FFF
 			m.cart.RAMBanks[m.ramBank][off] = value
+			return
 		}
 		m.rtc.SetReg(m.rtcRegVal, value)
 		return
@@ -297,6 +581,11 @@ func (m *MBC3Mapper) MapperWrite(addr uint16, value uint8) {
 type MBC5Mapper struct {
 	cart *Cart
 
+	// Rumble carts use the bit 3 of the RAM bank register to drive the motor
+	hasRumble      bool
+	rumble         bool
+	rumbleFrontend RumbleFrontend
+
 	ramEnabled bool   // 0000 - 1FFF
 	romBank    uint16 // 2000 - 3FFF
 	ramBank    uint8  // 4000 - 5FFF
@@ -306,31 +595,62 @@ func (m *MBC5Mapper) MapperSave(encoder *gob.Encoder) {
 	panicIfErr(encoder.Encode(m.ramEnabled))
 	panicIfErr(encoder.Encode(m.romBank))
 	panicIfErr(encoder.Encode(m.ramBank))
+	panicIfErr(encoder.Encode(m.rumble))
 }
 
 func (m *MBC5Mapper) MapperLoad(decoder *gob.Decoder) error {
+	var rumble bool
 	errs := []error{
 		decoder.Decode(&m.ramEnabled),
 		decoder.Decode(&m.romBank),
 		decoder.Decode(&m.ramBank),
+		decoder.Decode(&rumble),
 	}
 	for _, err := range errs {
 		if err != nil {
 			return err
 		}
 	}
+	// The frontend follows the motor of the loaded state
+	m.setRumble(rumble)
 	return nil
 }
 
 func MakeMBC5Mapper(cart *Cart) *MBC5Mapper {
 	return &MBC5Mapper{
 		cart:       cart,
+		hasRumble:  0x1C <= cart.header.CartridgeType && cart.header.CartridgeType <= 0x1E,
 		ramEnabled: false,
 		romBank:    1,
 		ramBank:    0,
 	}
 }
 
+func (m *MBC5Mapper) setRumbleFrontend(frontend RumbleFrontend) {
+	m.rumbleFrontend = frontend
+}
+
+func (m *MBC5Mapper) setRumble(enabled bool) {
+	if m.rumble == enabled {
+		return
+	}
+	m.rumble = enabled
+	if m.rumbleFrontend != nil {
+		m.rumbleFrontend.SetRumble(enabled)
+	}
+}
+
+func (m *MBC5Mapper) ROMBank(addr uint16) int {
+	if addr <= 0x3FFF {
+		return 0
+	}
+	return int(m.romBank)
+}
+
+func (m *MBC5Mapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	return m.cart.readBanked(bank, addr)
+}
+
 func (m *MBC5Mapper) MapperRead(addr uint16) uint8 {
 	switch {
 	case addr <= 0x3FFF:
@@ -369,6 +689,10 @@ func (m *MBC5Mapper) MapperWrite(addr uint16, value uint8) {
 		m.romBank %= uint16(len(m.cart.ROMBanks))
 		return
 	case 0x4000 <= addr && addr <= 0x5FFF:
+		if m.hasRumble {
+			m.setRumble(value&0x8 != 0)
+			value &= 0x7
+		}
 		if len(m.cart.RAMBanks) == 0 {
 			return
 		}
@@ -386,3 +710,1430 @@ func (m *MBC5Mapper) MapperWrite(addr uint16, value uint8) {
 
 	fmt.Printf("Unexpected address in MBC5Mapper Write: 0x%04x <- 0x%02x\n", addr, value)
 }
+
+// Center of the accelerometer range and the offset corresponding to 1g
+const (
+	MBC7AccelCenter  = 0x81D0
+	MBC7AccelGravity = 0x70
+)
+
+type MBC7Mapper struct {
+	cart *Cart
+
+	eeprom EEPROM
+
+	// Current tilt as fed by the frontend, in g (positive x: right,
+	// positive y: down)
+	tiltX, tiltY float64
+
+	ramEnabled1 bool   // 0000 - 1FFF
+	romBank     uint16 // 2000 - 3FFF
+	ramEnabled2 bool   // 4000 - 5FFF
+
+	accelLatched bool
+	accelX       uint16
+	accelY       uint16
+}
+
+func (m *MBC7Mapper) MapperSave(encoder *gob.Encoder) {
+	m.eeprom.Save(encoder)
+	panicIfErr(encoder.Encode(m.ramEnabled1))
+	panicIfErr(encoder.Encode(m.romBank))
+	panicIfErr(encoder.Encode(m.ramEnabled2))
+	panicIfErr(encoder.Encode(m.accelLatched))
+	panicIfErr(encoder.Encode(m.accelX))
+	panicIfErr(encoder.Encode(m.accelY))
+}
+
+func (m *MBC7Mapper) MapperLoad(decoder *gob.Decoder) error {
+	errs := []error{
+		m.eeprom.Load(decoder),
+		decoder.Decode(&m.ramEnabled1),
+		decoder.Decode(&m.romBank),
+		decoder.Decode(&m.ramEnabled2),
+		decoder.Decode(&m.accelLatched),
+		decoder.Decode(&m.accelX),
+		decoder.Decode(&m.accelY),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MBC7Mapper) StoreSav() []byte {
+	return m.eeprom.Bytes()
+}
+
+func (m *MBC7Mapper) LoadSav(data []byte) error {
+	return m.eeprom.LoadBytes(data)
+}
+
+func MakeMBC7Mapper(cart *Cart) *MBC7Mapper {
+	return &MBC7Mapper{
+		cart:    cart,
+		eeprom:  MakeEEPROM(),
+		romBank: 1,
+		accelX:  0x8000,
+		accelY:  0x8000,
+	}
+}
+
+func (m *MBC7Mapper) setTilt(x, y float64) {
+	m.tiltX = x
+	m.tiltY = y
+}
+
+func accelValue(g float64) uint16 {
+	v := MBC7AccelCenter + int(g*MBC7AccelGravity)
+	if v < 0 {
+		v = 0
+	} else if v > 0xFFFF {
+		v = 0xFFFF
+	}
+	return uint16(v)
+}
+
+func (m *MBC7Mapper) ROMBank(addr uint16) int {
+	if addr <= 0x3FFF {
+		return 0
+	}
+	return int(m.romBank)
+}
+
+func (m *MBC7Mapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	if addr <= 0x7FFF {
+		return m.cart.readBanked(bank, addr)
+	}
+	// No RAM banks, the sensor and the EEPROM are read as mapped
+	return m.MapperRead(addr)
+}
+
+func (m *MBC7Mapper) MapperRead(addr uint16) uint8 {
+	switch {
+	case addr <= 0x3FFF:
+		return m.cart.ROMBanks[0][addr]
+	case 0x4000 <= addr && addr <= 0x7FFF:
+		off := addr & 0x3FFF
+		bank := int(m.romBank)
+		return m.cart.ROMBanks[bank][off]
+	case 0xA000 <= addr && addr <= 0xAFFF:
+		if !m.ramEnabled1 || !m.ramEnabled2 {
+			return 0xFF
+		}
+		switch (addr >> 4) & 0xF {
+		case 0x2:
+			return uint8(m.accelX)
+		case 0x3:
+			return uint8(m.accelX >> 8)
+		case 0x4:
+			return uint8(m.accelY)
+		case 0x5:
+			return uint8(m.accelY >> 8)
+		case 0x6:
+			return 0x00
+		case 0x8:
+			return m.eeprom.ReadPins()
+		}
+		return 0xFF
+	case 0xB000 <= addr && addr <= 0xBFFF:
+		return 0xFF
+	}
+
+	fmt.Printf("Unexpected address in MBC7Mapper Read: 0x%04x\n", addr)
+	return 0
+}
+
+func (m *MBC7Mapper) MapperWrite(addr uint16, value uint8) {
+	switch {
+	case addr <= 0x1FFF:
+		m.ramEnabled1 = value == 0x0A
+		return
+	case 0x2000 <= addr && addr <= 0x3FFF:
+		m.romBank = uint16(value) & 0x7F
+		m.romBank %= uint16(len(m.cart.ROMBanks))
+		return
+	case 0x4000 <= addr && addr <= 0x5FFF:
+		m.ramEnabled2 = value == 0x40
+		return
+	case 0x6000 <= addr && addr <= 0x7FFF:
+		return
+	case 0xA000 <= addr && addr <= 0xAFFF:
+		if !m.ramEnabled1 || !m.ramEnabled2 {
+			return
+		}
+		switch (addr >> 4) & 0xF {
+		case 0x0:
+			if value == 0x55 {
+				m.accelLatched = false
+				m.accelX = 0x8000
+				m.accelY = 0x8000
+			}
+		case 0x1:
+			if value == 0xAA && !m.accelLatched {
+				m.accelLatched = true
+				m.accelX = accelValue(m.tiltX)
+				m.accelY = accelValue(m.tiltY)
+			}
+		case 0x8:
+			m.eeprom.WritePins(value)
+		}
+		return
+	case 0xB000 <= addr && addr <= 0xBFFF:
+		return
+	}
+
+	fmt.Printf("Unexpected address in MBC7Mapper Write: 0x%04x <- 0x%02x\n", addr, value)
+}
+
+type HuC1Mapper struct {
+	cart *Cart
+
+	irMode  bool  // 0000 - 1FFF
+	romBank uint8 // 2000 - 3FFF
+	ramBank uint8 // 4000 - 5FFF
+	irLED   bool
+}
+
+func (m *HuC1Mapper) MapperSave(encoder *gob.Encoder) {
+	panicIfErr(encoder.Encode(m.irMode))
+	panicIfErr(encoder.Encode(m.romBank))
+	panicIfErr(encoder.Encode(m.ramBank))
+	panicIfErr(encoder.Encode(m.irLED))
+}
+
+func (m *HuC1Mapper) MapperLoad(decoder *gob.Decoder) error {
+	errs := []error{
+		decoder.Decode(&m.irMode),
+		decoder.Decode(&m.romBank),
+		decoder.Decode(&m.ramBank),
+		decoder.Decode(&m.irLED),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func MakeHuC1Mapper(cart *Cart) *HuC1Mapper {
+	return &HuC1Mapper{
+		cart:    cart,
+		romBank: 1,
+	}
+}
+
+func (m *HuC1Mapper) ROMBank(addr uint16) int {
+	if addr <= 0x3FFF {
+		return 0
+	}
+	return int(m.romBank)
+}
+
+func (m *HuC1Mapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	return m.cart.readBanked(bank, addr)
+}
+
+func (m *HuC1Mapper) MapperRead(addr uint16) uint8 {
+	switch {
+	case addr <= 0x3FFF:
+		return m.cart.ROMBanks[0][addr]
+	case 0x4000 <= addr && addr <= 0x7FFF:
+		off := addr & 0x3FFF
+		bank := int(m.romBank)
+		return m.cart.ROMBanks[bank][off]
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if m.irMode {
+			// No IR light received
+			return 0xC0
+		}
+		if len(m.cart.RAMBanks) == 0 {
+			return 0xFF
+		}
+		off := addr & 0x1FFF
+		return m.cart.RAMBanks[m.ramBank][off]
+	}
+
+	fmt.Printf("Unexpected address in HuC1Mapper Read: 0x%04x\n", addr)
+	return 0
+}
+
+func (m *HuC1Mapper) MapperWrite(addr uint16, value uint8) {
+	switch {
+	case addr <= 0x1FFF:
+		// There is no RAM enable, the register selects between RAM and IR
+		m.irMode = value&0xF == 0xE
+		return
+	case 0x2000 <= addr && addr <= 0x3FFF:
+		m.romBank = value & 0x3F
+		m.romBank %= uint8(len(m.cart.ROMBanks))
+		return
+	case 0x4000 <= addr && addr <= 0x5FFF:
+		if len(m.cart.RAMBanks) == 0 {
+			return
+		}
+		m.ramBank = value & 0x3
+		m.ramBank %= uint8(len(m.cart.RAMBanks))
+		return
+	case 0x6000 <= addr && addr <= 0x7FFF:
+		return
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if m.irMode {
+			m.irLED = value&1 != 0
+			return
+		}
+		if len(m.cart.RAMBanks) == 0 {
+			return
+		}
+		off := addr & 0x1FFF
+		m.cart.RAMBanks[m.ramBank][off] = value
+		return
+	}
+
+	fmt.Printf("Unexpected address in HuC1Mapper Write: 0x%04x <- 0x%02x\n", addr, value)
+}
+
+// HuC3 modes, selected writing to 0000 - 1FFF
+const (
+	huc3ModeRAMReadOnly = 0x0
+	huc3ModeRAM         = 0xA
+	huc3ModeCommand     = 0xB
+	huc3ModeResponse    = 0xC
+	huc3ModeSemaphore   = 0xD
+	huc3ModeIR          = 0xE
+)
+
+type HuC3Mapper struct {
+	cart *Cart
+
+	// The clock counts minutes and days, we keep it as the elapsed
+	// seconds of an RTC
+	rtc RTC
+
+	// 256 nibbles accessed through the command/response protocol. The
+	// time is copied in (and out of) the first six nibbles
+	rtcMem     [256]uint8
+	rtcAddr    uint8
+	rtcCommand uint8
+	rtcResult  uint8
+
+	// The alarm (tone generator) is not emulated, its configuration is
+	// only preserved in the .sav file
+	alarmMinutes, alarmDays uint16
+	alarmEnabled            bool
+
+	mode    uint8 // 0000 - 1FFF
+	romBank uint8 // 2000 - 3FFF
+	ramBank uint8 // 4000 - 5FFF
+	irLED   bool
+}
+
+func (m *HuC3Mapper) MapperSave(encoder *gob.Encoder) {
+	m.rtc.Save(encoder)
+	panicIfErr(encoder.Encode(m.rtcMem))
+	panicIfErr(encoder.Encode(m.rtcAddr))
+	panicIfErr(encoder.Encode(m.rtcCommand))
+	panicIfErr(encoder.Encode(m.rtcResult))
+	panicIfErr(encoder.Encode(m.alarmMinutes))
+	panicIfErr(encoder.Encode(m.alarmDays))
+	panicIfErr(encoder.Encode(m.alarmEnabled))
+	panicIfErr(encoder.Encode(m.mode))
+	panicIfErr(encoder.Encode(m.romBank))
+	panicIfErr(encoder.Encode(m.ramBank))
+	panicIfErr(encoder.Encode(m.irLED))
+}
+
+func (m *HuC3Mapper) MapperLoad(decoder *gob.Decoder) error {
+	errs := []error{
+		m.rtc.Load(decoder),
+		decoder.Decode(&m.rtcMem),
+		decoder.Decode(&m.rtcAddr),
+		decoder.Decode(&m.rtcCommand),
+		decoder.Decode(&m.rtcResult),
+		decoder.Decode(&m.alarmMinutes),
+		decoder.Decode(&m.alarmDays),
+		decoder.Decode(&m.alarmEnabled),
+		decoder.Decode(&m.mode),
+		decoder.Decode(&m.romBank),
+		decoder.Decode(&m.ramBank),
+		decoder.Decode(&m.irLED),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *HuC3Mapper) StoreSav() []byte {
+	minutes, days := m.getTime()
+	footer := &HuC3Footer{
+		Timestamp:    m.rtc.clock.WallTime(),
+		Minutes:      minutes,
+		Days:         days,
+		AlarmMinutes: m.alarmMinutes,
+		AlarmDays:    m.alarmDays,
+		AlarmEnabled: m.alarmEnabled,
+	}
+	return append(m.cart.storeRAM(), footer.Bytes()...)
+}
+
+func (m *HuC3Mapper) LoadSav(data []byte) error {
+	ramSize := len(m.cart.RAMBanks) * 8192
+	if len(data) < ramSize {
+		return CartError("Invalid SAV file")
+	}
+
+	// Tolerate saves without the RTC footer
+	if len(data) > ramSize {
+		footer, err := ParseHuC3Footer(data[ramSize:])
+		if err != nil {
+			return err
+		}
+		// The clock kept running since the file was written
+		elapsed := int64(footer.Days)*60*60*24 + int64(footer.Minutes)*60
+		m.rtc.SetElapsed(elapsed + m.rtc.clock.WallTime() - footer.Timestamp)
+		m.alarmMinutes = footer.AlarmMinutes
+		m.alarmDays = footer.AlarmDays
+		m.alarmEnabled = footer.AlarmEnabled
+	}
+	return m.cart.loadRAM(data[:ramSize])
+}
+
+func MakeHuC3Mapper(cart *Cart) *HuC3Mapper {
+	res := &HuC3Mapper{
+		cart:    cart,
+		rtc:     MakeRTC(cart.clock),
+		romBank: 1,
+	}
+	// The HuC3 clock cannot be halted
+	res.rtc.DaysH = 0
+	res.rtc.SetElapsed(0)
+	return res
+}
+
+func (m *HuC3Mapper) setClock(clock Clock) {
+	m.rtc.SetClock(clock)
+}
+
+func (m *HuC3Mapper) getTime() (minutes, days uint16) {
+	elapsed := m.rtc.Elapsed() / 60
+	minutes = uint16(elapsed % (60 * 24))
+	days = uint16((elapsed / (60 * 24)) & 0xFFF)
+	return
+}
+
+func (m *HuC3Mapper) setTime(minutes, days uint16) {
+	m.rtc.SetElapsed(int64(days)*60*60*24 + int64(minutes)*60)
+}
+
+func (m *HuC3Mapper) executeCommand(value uint8) {
+	m.rtcCommand = (value >> 4) & 0x7
+	arg := value & 0xF
+
+	switch m.rtcCommand {
+	case 0x1: // read and increment the address
+		m.rtcResult = m.rtcMem[m.rtcAddr]
+		m.rtcAddr += 1
+	case 0x3: // write and increment the address
+		m.rtcMem[m.rtcAddr] = arg
+		m.rtcAddr += 1
+	case 0x4: // address, least significant nibble
+		m.rtcAddr = (m.rtcAddr & 0xF0) | arg
+	case 0x5: // address, most significant nibble
+		m.rtcAddr = (m.rtcAddr & 0x0F) | (arg << 4)
+	case 0x6:
+		switch arg {
+		case 0x0: // copy the current time in memory
+			minutes, days := m.getTime()
+			for i := 0; i < 3; i++ {
+				m.rtcMem[i] = uint8(minutes>>(i*4)) & 0xF
+				m.rtcMem[3+i] = uint8(days>>(i*4)) & 0xF
+			}
+		case 0x1: // set the time from memory
+			minutes, days := uint16(0), uint16(0)
+			for i := 0; i < 3; i++ {
+				minutes |= uint16(m.rtcMem[i]&0xF) << (i * 4)
+				days |= uint16(m.rtcMem[3+i]&0xF) << (i * 4)
+			}
+			m.setTime(minutes, days)
+		case 0x2: // status
+			m.rtcResult = 0x1
+		}
+	}
+}
+
+func (m *HuC3Mapper) ROMBank(addr uint16) int {
+	if addr <= 0x3FFF {
+		return 0
+	}
+	return int(m.romBank)
+}
+
+func (m *HuC3Mapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	return m.cart.readBanked(bank, addr)
+}
+
+func (m *HuC3Mapper) MapperRead(addr uint16) uint8 {
+	switch {
+	case addr <= 0x3FFF:
+		return m.cart.ROMBanks[0][addr]
+	case 0x4000 <= addr && addr <= 0x7FFF:
+		off := addr & 0x3FFF
+		bank := int(m.romBank)
+		return m.cart.ROMBanks[bank][off]
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		switch m.mode {
+		case huc3ModeRAM, huc3ModeRAMReadOnly:
+			if len(m.cart.RAMBanks) == 0 {
+				return 0xFF
+			}
+			off := addr & 0x1FFF
+			return m.cart.RAMBanks[m.ramBank][off]
+		case huc3ModeResponse:
+			return 0x80 | m.rtcCommand<<4 | m.rtcResult
+		case huc3ModeSemaphore:
+			// Always ready
+			return 0xFF
+		case huc3ModeIR:
+			// No IR light received
+			return 0xC0
+		}
+		return 0xFF
+	}
+
+	fmt.Printf("Unexpected address in HuC3Mapper Read: 0x%04x\n", addr)
+	return 0
+}
+
+func (m *HuC3Mapper) MapperWrite(addr uint16, value uint8) {
+	switch {
+	case addr <= 0x1FFF:
+		m.mode = value & 0xF
+		return
+	case 0x2000 <= addr && addr <= 0x3FFF:
+		m.romBank = value & 0x7F
+		m.romBank %= uint8(len(m.cart.ROMBanks))
+		return
+	case 0x4000 <= addr && addr <= 0x5FFF:
+		if len(m.cart.RAMBanks) == 0 {
+			return
+		}
+		m.ramBank = value & 0x3
+		m.ramBank %= uint8(len(m.cart.RAMBanks))
+		return
+	case 0x6000 <= addr && addr <= 0x7FFF:
+		return
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		switch m.mode {
+		case huc3ModeRAM:
+			if len(m.cart.RAMBanks) == 0 {
+				return
+			}
+			off := addr & 0x1FFF
+			m.cart.RAMBanks[m.ramBank][off] = value
+		case huc3ModeCommand:
+			m.executeCommand(value)
+		case huc3ModeIR:
+			m.irLED = value&1 != 0
+		}
+		return
+	}
+
+	fmt.Printf("Unexpected address in HuC3Mapper Write: 0x%04x <- 0x%02x\n", addr, value)
+}
+
+type PocketCameraMapper struct {
+	cart *Cart
+
+	source CameraSource
+
+	registers    [cameraRegisters]uint8
+	captureTicks int // remaining ticks of the ongoing capture
+
+	ramEnabled      bool  // 0000 - 1FFF
+	romBank         uint8 // 2000 - 3FFF
+	ramBank         uint8 // 4000 - 5FFF
+	registersMapped bool
+}
+
+func (m *PocketCameraMapper) MapperSave(encoder *gob.Encoder) {
+	panicIfErr(encoder.Encode(m.registers))
+	panicIfErr(encoder.Encode(m.captureTicks))
+	panicIfErr(encoder.Encode(m.ramEnabled))
+	panicIfErr(encoder.Encode(m.romBank))
+	panicIfErr(encoder.Encode(m.ramBank))
+	panicIfErr(encoder.Encode(m.registersMapped))
+}
+
+func (m *PocketCameraMapper) MapperLoad(decoder *gob.Decoder) error {
+	errs := []error{
+		decoder.Decode(&m.registers),
+		decoder.Decode(&m.captureTicks),
+		decoder.Decode(&m.ramEnabled),
+		decoder.Decode(&m.romBank),
+		decoder.Decode(&m.ramBank),
+		decoder.Decode(&m.registersMapped),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func MakePocketCameraMapper(cart *Cart) *PocketCameraMapper {
+	return &PocketCameraMapper{
+		cart:    cart,
+		source:  &TestPatternCameraSource{},
+		romBank: 1,
+	}
+}
+
+func (m *PocketCameraMapper) setCameraSource(source CameraSource) {
+	m.source = source
+}
+
+func (m *PocketCameraMapper) tick(cpuTicks int) {
+	if m.captureTicks <= 0 {
+		return
+	}
+	m.captureTicks -= cpuTicks
+	if m.captureTicks <= 0 {
+		m.capture()
+	}
+}
+
+func (m *PocketCameraMapper) capture() {
+	m.captureTicks = 0
+	m.registers[cameraRegControl] &= ^uint8(1)
+	if len(m.cart.RAMBanks) == 0 {
+		return
+	}
+
+	frame := m.source.CaptureFrame()
+	if len(frame) != CameraWidth*CameraHeight {
+		// Nothing to see
+		frame = make([]uint8, CameraWidth*CameraHeight)
+	}
+	image := cameraProcess(m.registers[:], frame)
+	copy(m.cart.RAMBanks[0][CameraImageOffset:], image)
+}
+
+func (m *PocketCameraMapper) ROMBank(addr uint16) int {
+	if addr <= 0x3FFF {
+		return 0
+	}
+	return int(m.romBank)
+}
+
+func (m *PocketCameraMapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	return m.cart.readBanked(bank, addr)
+}
+
+func (m *PocketCameraMapper) MapperRead(addr uint16) uint8 {
+	switch {
+	case addr <= 0x3FFF:
+		return m.cart.ROMBanks[0][addr]
+	case 0x4000 <= addr && addr <= 0x7FFF:
+		off := addr & 0x3FFF
+		bank := int(m.romBank)
+		return m.cart.ROMBanks[bank][off]
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if m.registersMapped {
+			// Only the control register can be read
+			if addr&0x7F == cameraRegControl {
+				return m.registers[cameraRegControl]
+			}
+			return 0x00
+		}
+		if len(m.cart.RAMBanks) == 0 {
+			return 0xFF
+		}
+		// RAM can be read even when it is not enabled
+		off := addr & 0x1FFF
+		return m.cart.RAMBanks[m.ramBank][off]
+	}
+
+	fmt.Printf("Unexpected address in PocketCameraMapper Read: 0x%04x\n", addr)
+	return 0
+}
+
+func (m *PocketCameraMapper) MapperWrite(addr uint16, value uint8) {
+	switch {
+	case addr <= 0x1FFF:
+		m.ramEnabled = value&0xF == 0xA
+		return
+	case 0x2000 <= addr && addr <= 0x3FFF:
+		m.romBank = value & 0x3F
+		m.romBank %= uint8(len(m.cart.ROMBanks))
+		return
+	case 0x4000 <= addr && addr <= 0x5FFF:
+		m.registersMapped = value&0x10 != 0
+		if !m.registersMapped && len(m.cart.RAMBanks) > 0 {
+			m.ramBank = value & 0xF
+			m.ramBank %= uint8(len(m.cart.RAMBanks))
+		}
+		return
+	case 0x6000 <= addr && addr <= 0x7FFF:
+		return
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if m.registersMapped {
+			reg := addr & 0x7F
+			if reg >= cameraRegisters {
+				return
+			}
+			if reg == cameraRegControl {
+				value &= 0x7
+				if value&1 != 0 && m.captureTicks == 0 {
+					m.captureTicks = cameraCaptureTicks(m.registers[:])
+				} else if value&1 == 0 {
+					// Stop the ongoing capture
+					m.captureTicks = 0
+				}
+			}
+			m.registers[reg] = value
+			return
+		}
+		if !m.ramEnabled || len(m.cart.RAMBanks) == 0 {
+			return
+		}
+		// The RAM cannot be written during a capture
+		if m.captureTicks > 0 {
+			return
+		}
+		off := addr & 0x1FFF
+		m.cart.RAMBanks[m.ramBank][off] = value
+		return
+	}
+
+	fmt.Printf("Unexpected address in PocketCameraMapper Write: 0x%04x <- 0x%02x\n", addr, value)
+}
+
+// MMM01 multi-game carts boot in "unmapped" mode, with the last 32KB of
+// the ROM (the menu) mapped at 0000 - 7FFF. The menu configures the outer
+// banks of the selected game and then enables the mapping: from that
+// moment on the cart behaves like an MBC1 restricted to the game, and the
+// registers selecting the game become read-only until the next reset.
+// The MBC1 mode bit (6000 - 7FFF, bit 0) and its lock are not emulated:
+// the RAM bank register always selects the RAM bank, and 0000 - 3FFF
+// always maps the first bank of the game
+type MMM01Mapper struct {
+	cart *Cart
+
+	mapped bool
+
+	ramEnabled  bool  // 0000 - 1FFF
+	ramMask     uint8 // 0000 - 1FFF (bits 4-5, unmapped only)
+	romBankLow  uint8 // 2000 - 3FFF (bits 0-4)
+	romBankMid  uint8 // 2000 - 3FFF (bits 5-6, unmapped only)
+	ramBankLow  uint8 // 4000 - 5FFF (bits 0-1)
+	ramBankHigh uint8 // 4000 - 5FFF (bits 2-3, unmapped only)
+	romBankHigh uint8 // 4000 - 5FFF (bits 4-5, unmapped only)
+	romMask     uint8 // 6000 - 7FFF (bits 2-5, unmapped only)
+}
+
+func (m *MMM01Mapper) MapperSave(encoder *gob.Encoder) {
+	panicIfErr(encoder.Encode(m.mapped))
+	panicIfErr(encoder.Encode(m.ramEnabled))
+	panicIfErr(encoder.Encode(m.ramMask))
+	panicIfErr(encoder.Encode(m.romBankLow))
+	panicIfErr(encoder.Encode(m.romBankMid))
+	panicIfErr(encoder.Encode(m.ramBankLow))
+	panicIfErr(encoder.Encode(m.ramBankHigh))
+	panicIfErr(encoder.Encode(m.romBankHigh))
+	panicIfErr(encoder.Encode(m.romMask))
+}
+
+func (m *MMM01Mapper) MapperLoad(decoder *gob.Decoder) error {
+	errs := []error{
+		decoder.Decode(&m.mapped),
+		decoder.Decode(&m.ramEnabled),
+		decoder.Decode(&m.ramMask),
+		decoder.Decode(&m.romBankLow),
+		decoder.Decode(&m.romBankMid),
+		decoder.Decode(&m.ramBankLow),
+		decoder.Decode(&m.ramBankHigh),
+		decoder.Decode(&m.romBankHigh),
+		decoder.Decode(&m.romMask),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func MakeMMM01Mapper(cart *Cart) *MMM01Mapper {
+	return &MMM01Mapper{
+		cart:       cart,
+		romBankLow: 1,
+	}
+}
+
+func (m *MMM01Mapper) romBank(high bool) int {
+	if !m.mapped {
+		// The menu is in the last 32KB
+		bank := len(m.cart.ROMBanks) - 2
+		if high {
+			bank += 1
+		}
+		return bank
+	}
+
+	// The bits of romBankLow selected by the mask belong to the game
+	// selection, the other ones are the bank inside the game
+	outer := int(m.romBankHigh)<<7 | int(m.romBankMid)<<5
+	gameBits := (m.romMask << 1) & 0x1E
+	low := m.romBankLow & gameBits
+	if high {
+		inner := m.romBankLow & ^gameBits & 0x1F
+		if inner == 0 {
+			inner = 1
+		}
+		low |= inner
+	}
+	return (outer | int(low)) % len(m.cart.ROMBanks)
+}
+
+func (m *MMM01Mapper) ramBank() int {
+	bank := m.ramBankHigh<<2 | m.ramBankLow
+	return int(bank) % len(m.cart.RAMBanks)
+}
+
+func (m *MMM01Mapper) ROMBank(addr uint16) int {
+	return m.romBank(addr >= 0x4000)
+}
+
+func (m *MMM01Mapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	return m.cart.readBanked(bank, addr)
+}
+
+func (m *MMM01Mapper) MapperRead(addr uint16) uint8 {
+	switch {
+	case addr <= 0x3FFF:
+		return m.cart.ROMBanks[m.romBank(false)][addr]
+	case 0x4000 <= addr && addr <= 0x7FFF:
+		return m.cart.ROMBanks[m.romBank(true)][addr&0x3FFF]
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if !m.ramEnabled || len(m.cart.RAMBanks) == 0 {
+			return 0xFF
+		}
+		return m.cart.RAMBanks[m.ramBank()][addr&0x1FFF]
+	}
+
+	fmt.Printf("Unexpected address in MMM01Mapper Read: 0x%04x\n", addr)
+	return 0
+}
+
+func (m *MMM01Mapper) MapperWrite(addr uint16, value uint8) {
+	switch {
+	case addr <= 0x1FFF:
+		m.ramEnabled = value&0xF == 0xA
+		if !m.mapped {
+			m.ramMask = (value >> 4) & 0x3
+			m.mapped = value&0x40 != 0
+		}
+		return
+	case 0x2000 <= addr && addr <= 0x3FFF:
+		if m.mapped {
+			// The bits selecting the game cannot be changed anymore
+			gameBits := (m.romMask << 1) & 0x1E
+			m.romBankLow = (m.romBankLow & gameBits) | (value & 0x1F & ^gameBits)
+		} else {
+			m.romBankLow = value & 0x1F
+			m.romBankMid = (value >> 5) & 0x3
+		}
+		return
+	case 0x4000 <= addr && addr <= 0x5FFF:
+		if m.mapped {
+			m.ramBankLow = (m.ramBankLow & m.ramMask) | (value & 0x3 & ^m.ramMask)
+		} else {
+			m.ramBankLow = value & 0x3
+			m.ramBankHigh = (value >> 2) & 0x3
+			m.romBankHigh = (value >> 4) & 0x3
+		}
+		return
+	case 0x6000 <= addr && addr <= 0x7FFF:
+		if !m.mapped {
+			m.romMask = (value >> 2) & 0xF
+		}
+		return
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if !m.ramEnabled || len(m.cart.RAMBanks) == 0 {
+			return
+		}
+		m.cart.RAMBanks[m.ramBank()][addr&0x1FFF] = value
+		return
+	}
+
+	fmt.Printf("Unexpected address in MMM01Mapper Write: 0x%04x <- 0x%02x\n", addr, value)
+}
+
+// MBC6 flash memory (MX29F008): 1MB, with the same 8KB banks of the ROM
+const (
+	MBC6FlashSize       = 0x100000
+	mbc6FlashSectorSize = 0x20000
+)
+
+const (
+	mbc6FlashIdle = iota
+	mbc6FlashUnlock1
+	mbc6FlashUnlock2
+	mbc6FlashEraseUnlock0
+	mbc6FlashEraseUnlock1
+	mbc6FlashEraseUnlock2
+	mbc6FlashProgram
+)
+
+type MBC6Mapper struct {
+	cart *Cart
+
+	flash      []uint8
+	flashState int
+	flashID    bool // the chip returns its ID instead of the data
+
+	ramEnabled   bool     // 0000 - 03FF
+	ramBank      [2]uint8 // 0400 - 07FF (A000 - AFFF), 0800 - 0BFF (B000 - BFFF)
+	flashEnabled bool     // 0C00 - 0FFF
+	flashWrite   bool     // 1000
+	romBank      [2]uint8 // 2000 - 27FF (4000 - 5FFF), 3000 - 37FF (6000 - 7FFF)
+	flashMapped  [2]bool  // 2800 - 2FFF, 3800 - 3FFF
+}
+
+func (m *MBC6Mapper) MapperSave(encoder *gob.Encoder) {
+	panicIfErr(encoder.Encode(m.flash))
+	panicIfErr(encoder.Encode(m.flashState))
+	panicIfErr(encoder.Encode(m.flashID))
+	panicIfErr(encoder.Encode(m.ramEnabled))
+	panicIfErr(encoder.Encode(m.ramBank))
+	panicIfErr(encoder.Encode(m.flashEnabled))
+	panicIfErr(encoder.Encode(m.flashWrite))
+	panicIfErr(encoder.Encode(m.romBank))
+	panicIfErr(encoder.Encode(m.flashMapped))
+}
+
+func (m *MBC6Mapper) MapperLoad(decoder *gob.Decoder) error {
+	errs := []error{
+		decoder.Decode(&m.flash),
+		decoder.Decode(&m.flashState),
+		decoder.Decode(&m.flashID),
+		decoder.Decode(&m.ramEnabled),
+		decoder.Decode(&m.ramBank),
+		decoder.Decode(&m.flashEnabled),
+		decoder.Decode(&m.flashWrite),
+		decoder.Decode(&m.romBank),
+		decoder.Decode(&m.flashMapped),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	if len(m.flash) != MBC6FlashSize {
+		return CartError("Invalid MBC6 flash size")
+	}
+	return nil
+}
+
+// The flash is stored in the .sav file after the RAM
+func (m *MBC6Mapper) StoreSav() []byte {
+	return append(m.cart.storeRAM(), m.flash...)
+}
+
+func (m *MBC6Mapper) LoadSav(data []byte) error {
+	ramSize := len(m.cart.RAMBanks) * 8192
+	if len(data) != ramSize && len(data) != ramSize+MBC6FlashSize {
+		return CartError("Invalid SAV file")
+	}
+	if len(data) > ramSize {
+		copy(m.flash, data[ramSize:])
+	}
+	return m.cart.loadRAM(data[:ramSize])
+}
+
+func MakeMBC6Mapper(cart *Cart) *MBC6Mapper {
+	res := &MBC6Mapper{
+		cart:    cart,
+		flash:   make([]uint8, MBC6FlashSize),
+		romBank: [2]uint8{2, 3},
+	}
+	// An erased flash reads as all ones
+	for i := range res.flash {
+		res.flash[i] = 0xFF
+	}
+	return res
+}
+
+func (m *MBC6Mapper) readROM(bank uint8, off uint16) uint8 {
+	n := int(bank) % (len(m.cart.ROMBanks) * 2)
+	return m.cart.ROMBanks[n/2][(n%2)*0x2000+int(off)]
+}
+
+// RAM is accessed in 4KB banks
+func (m *MBC6Mapper) ramAddr(addr uint16) (int, int) {
+	bank := int(m.ramBank[(addr>>12)&1]) % (len(m.cart.RAMBanks) * 2)
+	return bank / 2, (bank%2)*0x1000 + int(addr&0xFFF)
+}
+
+func (m *MBC6Mapper) flashAddr(bank uint8, off uint16) int {
+	return (int(bank)*0x2000 + int(off)) % MBC6FlashSize
+}
+
+func (m *MBC6Mapper) readFlash(bank uint8, off uint16) uint8 {
+	if m.flashID {
+		switch off & 0xFF {
+		case 0x00:
+			return 0xC2 // Macronix
+		case 0x01:
+			return 0x81
+		}
+		return 0x00
+	}
+	return m.flash[m.flashAddr(bank, off)]
+}
+
+func (m *MBC6Mapper) writeFlash(bank uint8, off uint16, value uint8) {
+	if !m.flashWrite {
+		return
+	}
+	addr := m.flashAddr(bank, off)
+	if value == 0xF0 && m.flashState != mbc6FlashProgram {
+		// Reset
+		m.flashState = mbc6FlashIdle
+		m.flashID = false
+		return
+	}
+
+	switch m.flashState {
+	case mbc6FlashIdle, mbc6FlashEraseUnlock0:
+		if addr&0x7FFF == 0x5555 && value == 0xAA {
+			m.flashState += 1
+			return
+		}
+	case mbc6FlashUnlock1, mbc6FlashEraseUnlock1:
+		if addr&0x7FFF == 0x2AAA && value == 0x55 {
+			m.flashState += 1
+			return
+		}
+	case mbc6FlashUnlock2:
+		if addr&0x7FFF == 0x5555 {
+			switch value {
+			case 0x80:
+				m.flashState = mbc6FlashEraseUnlock0
+				return
+			case 0x90:
+				m.flashID = true
+			case 0xA0:
+				m.flashState = mbc6FlashProgram
+				return
+			}
+		}
+	case mbc6FlashEraseUnlock2:
+		switch {
+		case value == 0x30:
+			sector := addr &^ (mbc6FlashSectorSize - 1)
+			for i := sector; i < sector+mbc6FlashSectorSize; i++ {
+				m.flash[i] = 0xFF
+			}
+		case value == 0x10 && addr&0x7FFF == 0x5555:
+			for i := range m.flash {
+				m.flash[i] = 0xFF
+			}
+		}
+	case mbc6FlashProgram:
+		// Programming can only clear bits
+		m.flash[addr] &= value
+	}
+	m.flashState = mbc6FlashIdle
+}
+
+// readBanked reads addr (in 4000 - 7FFF) from bank of the ROM, or of the
+// flash if it is mapped in the window of addr
+func (m *MBC6Mapper) readBanked(bank uint8, addr uint16) uint8 {
+	off := addr & 0x1FFF
+	if m.flashMapped[(addr-0x4000)>>13] {
+		if !m.flashEnabled {
+			return 0xFF
+		}
+		return m.readFlash(bank, off)
+	}
+	return m.readROM(bank, off)
+}
+
+// The ROM banks of MBC6 are 8KB wide
+func (m *MBC6Mapper) ROMBank(addr uint16) int {
+	if addr <= 0x3FFF {
+		return 0
+	}
+	return int(m.romBank[(addr-0x4000)>>13])
+}
+
+func (m *MBC6Mapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	switch {
+	case addr <= 0x3FFF:
+		return m.cart.ROMBanks[0][addr]
+	case addr <= 0x7FFF:
+		return m.readBanked(uint8(bank), addr)
+	}
+	// RAM is accessed in 4KB banks
+	if len(m.cart.RAMBanks) == 0 {
+		return 0xFF
+	}
+	n := bank % (len(m.cart.RAMBanks) * 2)
+	return m.cart.RAMBanks[n/2][(n%2)*0x1000+int(addr&0xFFF)]
+}
+
+func (m *MBC6Mapper) MapperRead(addr uint16) uint8 {
+	switch {
+	case addr <= 0x3FFF:
+		return m.cart.ROMBanks[0][addr]
+	case 0x4000 <= addr && addr <= 0x7FFF:
+		return m.readBanked(m.romBank[(addr-0x4000)>>13], addr)
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if !m.ramEnabled || len(m.cart.RAMBanks) == 0 {
+			return 0xFF
+		}
+		bank, off := m.ramAddr(addr)
+		return m.cart.RAMBanks[bank][off]
+	}
+
+	fmt.Printf("Unexpected address in MBC6Mapper Read: 0x%04x\n", addr)
+	return 0
+}
+
+func (m *MBC6Mapper) MapperWrite(addr uint16, value uint8) {
+	switch {
+	case addr <= 0x03FF:
+		m.ramEnabled = value&0xF == 0xA
+		return
+	case 0x0400 <= addr && addr <= 0x07FF:
+		m.ramBank[0] = value & 0x7
+		return
+	case 0x0800 <= addr && addr <= 0x0BFF:
+		m.ramBank[1] = value & 0x7
+		return
+	case 0x0C00 <= addr && addr <= 0x0FFF:
+		m.flashEnabled = value&1 != 0
+		return
+	case 0x1000 <= addr && addr <= 0x1FFF:
+		if addr == 0x1000 {
+			m.flashWrite = value&1 != 0
+		}
+		return
+	case 0x2000 <= addr && addr <= 0x3FFF:
+		window := (addr - 0x2000) >> 12
+		if addr&0x0800 == 0 {
+			m.romBank[window] = value & 0x7F
+		} else {
+			m.flashMapped[window] = value&0x08 != 0
+		}
+		return
+	case 0x4000 <= addr && addr <= 0x7FFF:
+		window := (addr - 0x4000) >> 13
+		if m.flashMapped[window] && m.flashEnabled {
+			m.writeFlash(m.romBank[window], addr&0x1FFF, value)
+		}
+		return
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if !m.ramEnabled || len(m.cart.RAMBanks) == 0 {
+			return
+		}
+		bank, off := m.ramAddr(addr)
+		m.cart.RAMBanks[bank][off] = value
+		return
+	}
+
+	fmt.Printf("Unexpected address in MBC6Mapper Write: 0x%04x <- 0x%02x\n", addr, value)
+}
+
+// TAMA5 registers, selected writing to A001 and accessed through A000 one
+// nibble at a time
+const (
+	tama5RegROMLow   = 0x0
+	tama5RegROMHigh  = 0x1
+	tama5RegDataLow  = 0x4
+	tama5RegDataHigh = 0x5
+	tama5RegAddrHigh = 0x6 // bit 0: address bit 4, bits 1-3: command
+	tama5RegAddrLow  = 0x7 // writing it executes the command
+	tama5RegReady    = 0xA
+	tama5RegOutLow   = 0xC
+	tama5RegOutHigh  = 0xD
+)
+
+// TAMA5 commands
+const (
+	tama5CmdWriteRAM   = 0x0
+	tama5CmdReadRAM    = 0x1
+	tama5CmdWriteClock = 0x2
+	tama5CmdReadClock  = 0x3
+)
+
+const (
+	TAMA5RAMSize    = 32
+	TAMA5FooterSize = 21
+)
+
+// The clock registers follow the layout of the TC8521 RTC chip, one BCD
+// digit per register. Page 1 holds the alarm
+const (
+	tama5ClockSecUnits   = 0x00
+	tama5ClockSecTens    = 0x01
+	tama5ClockMinUnits   = 0x02
+	tama5ClockMinTens    = 0x03
+	tama5ClockHourUnits  = 0x04
+	tama5ClockHourTens   = 0x05
+	tama5ClockWeekday    = 0x06
+	tama5ClockDayUnits   = 0x07
+	tama5ClockDayTens    = 0x08
+	tama5ClockMonthUnits = 0x09
+	tama5ClockMonthTens  = 0x0A
+	tama5ClockYearUnits  = 0x0B
+	tama5ClockYearTens   = 0x0C
+	tama5ClockMode       = 0x0D // bit 2: alarm enable
+	tama5AlarmMinUnits   = 0x12
+	tama5AlarmHourTens   = 0x15
+)
+
+// Date corresponding to a zero elapsed time
+var tama5Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type TAMA5Mapper struct {
+	cart *Cart
+
+	ram [TAMA5RAMSize]uint8
+	rtc RTC
+
+	// Alarm minutes and hours (BCD digits) and mode register. The alarm
+	// output is not connected to anything observable, it is only kept
+	alarm     [4]uint8
+	clockMode uint8
+
+	registers [16]uint8
+	regSelect uint8 // A001
+}
+
+func (m *TAMA5Mapper) MapperSave(encoder *gob.Encoder) {
+	m.rtc.Save(encoder)
+	panicIfErr(encoder.Encode(m.ram))
+	panicIfErr(encoder.Encode(m.alarm))
+	panicIfErr(encoder.Encode(m.clockMode))
+	panicIfErr(encoder.Encode(m.registers))
+	panicIfErr(encoder.Encode(m.regSelect))
+}
+
+func (m *TAMA5Mapper) MapperLoad(decoder *gob.Decoder) error {
+	errs := []error{
+		m.rtc.Load(decoder),
+		decoder.Decode(&m.ram),
+		decoder.Decode(&m.alarm),
+		decoder.Decode(&m.clockMode),
+		decoder.Decode(&m.registers),
+		decoder.Decode(&m.regSelect),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The .sav file contains the 32 bytes of RAM followed by a footer: the
+// UNIX timestamp of the moment in which the file was written and the
+// seconds elapsed since tama5Epoch (64 bits little endian each), the alarm
+// digits and the mode register
+func (m *TAMA5Mapper) StoreSav() []byte {
+	res := make([]byte, TAMA5RAMSize+TAMA5FooterSize)
+	copy(res, m.ram[:])
+	footer := res[TAMA5RAMSize:]
+	binary.LittleEndian.PutUint64(footer[0:], uint64(m.rtc.clock.WallTime()))
+	binary.LittleEndian.PutUint64(footer[8:], uint64(m.rtc.Elapsed()))
+	copy(footer[16:], m.alarm[:])
+	footer[20] = m.clockMode
+	return res
+}
+
+func (m *TAMA5Mapper) LoadSav(data []byte) error {
+	if len(data) != TAMA5RAMSize && len(data) != TAMA5RAMSize+TAMA5FooterSize {
+		return CartError("Invalid SAV file")
+	}
+	copy(m.ram[:], data)
+	if len(data) > TAMA5RAMSize {
+		footer := data[TAMA5RAMSize:]
+		timestamp := int64(binary.LittleEndian.Uint64(footer[0:]))
+		elapsed := int64(binary.LittleEndian.Uint64(footer[8:]))
+		// The clock kept running since the file was written
+		m.rtc.SetElapsed(elapsed + m.rtc.clock.WallTime() - timestamp)
+		copy(m.alarm[:], footer[16:20])
+		m.clockMode = footer[20]
+	}
+	return nil
+}
+
+func MakeTAMA5Mapper(cart *Cart) *TAMA5Mapper {
+	res := &TAMA5Mapper{
+		cart: cart,
+		rtc:  MakeRTC(cart.clock),
+	}
+	res.rtc.DaysH = 0
+	res.rtc.SetElapsed(0)
+	return res
+}
+
+func (m *TAMA5Mapper) setClock(clock Clock) {
+	m.rtc.SetClock(clock)
+}
+
+func (m *TAMA5Mapper) romBank() int {
+	bank := int(m.registers[tama5RegROMHigh]&1)<<4 | int(m.registers[tama5RegROMLow])
+	return bank % len(m.cart.ROMBanks)
+}
+
+// Clock registers as BCD digits
+func (m *TAMA5Mapper) clockDigits() []uint8 {
+	t := tama5Epoch.Add(time.Duration(m.rtc.Elapsed()) * time.Second)
+	year := t.Year() - tama5Epoch.Year()
+	return []uint8{
+		uint8(t.Second() % 10), uint8(t.Second() / 10),
+		uint8(t.Minute() % 10), uint8(t.Minute() / 10),
+		uint8(t.Hour() % 10), uint8(t.Hour() / 10),
+		uint8(t.Weekday()),
+		uint8(t.Day() % 10), uint8(t.Day() / 10),
+		uint8(int(t.Month()) % 10), uint8(int(t.Month()) / 10),
+		uint8(year % 10), uint8((year / 10) % 10),
+	}
+}
+
+func (m *TAMA5Mapper) readClock(reg uint8) uint8 {
+	switch {
+	case reg <= tama5ClockYearTens:
+		return m.clockDigits()[reg]
+	case reg&0xF == tama5ClockMode:
+		return m.clockMode
+	case tama5AlarmMinUnits <= reg && reg <= tama5AlarmHourTens:
+		return m.alarm[reg-tama5AlarmMinUnits]
+	}
+	return 0
+}
+
+// bcdValue returns the value of two BCD digits, clamped to [min, max]
+func bcdValue(tens, units uint8, min, max int) int {
+	v := int(tens)*10 + int(units)
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func (m *TAMA5Mapper) writeClock(reg, value uint8) {
+	value &= 0xF
+	switch {
+	case reg <= tama5ClockYearTens:
+		if reg == tama5ClockWeekday {
+			// derived from the date
+			return
+		}
+		d := m.clockDigits()
+		d[reg] = value
+		// Invalid digits are clamped, a day past the end of the month
+		// moves to the next one
+		t := time.Date(
+			tama5Epoch.Year()+bcdValue(d[tama5ClockYearTens], d[tama5ClockYearUnits], 0, 99),
+			time.Month(bcdValue(d[tama5ClockMonthTens], d[tama5ClockMonthUnits], 1, 12)),
+			bcdValue(d[tama5ClockDayTens], d[tama5ClockDayUnits], 1, 31),
+			bcdValue(d[tama5ClockHourTens], d[tama5ClockHourUnits], 0, 23),
+			bcdValue(d[tama5ClockMinTens], d[tama5ClockMinUnits], 0, 59),
+			bcdValue(d[tama5ClockSecTens], d[tama5ClockSecUnits], 0, 59),
+			0, time.UTC)
+		m.rtc.SetElapsed(int64(t.Sub(tama5Epoch) / time.Second))
+	case reg&0xF == tama5ClockMode:
+		m.clockMode = value
+	case tama5AlarmMinUnits <= reg && reg <= tama5AlarmHourTens:
+		m.alarm[reg-tama5AlarmMinUnits] = value
+	}
+}
+
+func (m *TAMA5Mapper) executeCommand() {
+	addr := (m.registers[tama5RegAddrHigh]&1)<<4 | m.registers[tama5RegAddrLow]
+	data := m.registers[tama5RegDataHigh]<<4 | m.registers[tama5RegDataLow]
+
+	switch (m.registers[tama5RegAddrHigh] >> 1) & 0x7 {
+	case tama5CmdWriteRAM:
+		m.ram[addr] = data
+	case tama5CmdReadRAM:
+		m.registers[tama5RegOutLow] = m.ram[addr] & 0xF
+		m.registers[tama5RegOutHigh] = m.ram[addr] >> 4
+	case tama5CmdWriteClock:
+		m.writeClock(addr, data)
+	case tama5CmdReadClock:
+		m.registers[tama5RegOutLow] = m.readClock(addr)
+		m.registers[tama5RegOutHigh] = 0
+	}
+}
+
+func (m *TAMA5Mapper) ROMBank(addr uint16) int {
+	if addr <= 0x3FFF {
+		return 0
+	}
+	return m.romBank()
+}
+
+func (m *TAMA5Mapper) MapperReadBanked(bank int, addr uint16) uint8 {
+	if addr <= 0x7FFF {
+		return m.cart.readBanked(bank, addr)
+	}
+	// No RAM banks, the registers are read as mapped
+	return m.MapperRead(addr)
+}
+
+func (m *TAMA5Mapper) MapperRead(addr uint16) uint8 {
+	switch {
+	case addr <= 0x3FFF:
+		return m.cart.ROMBanks[0][addr]
+	case 0x4000 <= addr && addr <= 0x7FFF:
+		return m.cart.ROMBanks[m.romBank()][addr&0x3FFF]
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if addr&1 != 0 {
+			return 0xFF
+		}
+		switch m.regSelect {
+		case tama5RegReady:
+			return 0xF1
+		case tama5RegOutLow, tama5RegOutHigh:
+			return 0xF0 | m.registers[m.regSelect]
+		}
+		return 0xFF
+	}
+
+	fmt.Printf("Unexpected address in TAMA5Mapper Read: 0x%04x\n", addr)
+	return 0
+}
+
+func (m *TAMA5Mapper) MapperWrite(addr uint16, value uint8) {
+	switch {
+	case addr <= 0x7FFF:
+		return
+	case 0xA000 <= addr && addr <= 0xBFFF:
+		if addr&1 != 0 {
+			m.regSelect = value & 0xF
+			return
+		}
+		m.registers[m.regSelect] = value & 0xF
+		if m.regSelect == tama5RegAddrLow {
+			m.executeCommand()
+		}
+		return
+	}
+
+	fmt.Printf("Unexpected address in TAMA5Mapper Write: 0x%04x <- 0x%02x\n", addr, value)
+}
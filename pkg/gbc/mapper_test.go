@@ -0,0 +1,426 @@
+package gbc
+
+import (
+	"fmt"
+	"testing"
+)
+
+// rumbleFrontend records every change of the rumble motor state, in order
+type rumbleFrontend struct {
+	testFrontend
+	events []bool
+}
+
+func (f *rumbleFrontend) SetRumble(enabled bool) {
+	f.events = append(f.events, enabled)
+}
+
+func TestRumble(t *testing.T) {
+	frontend := &rumbleFrontend{}
+	rom := makeTestROM(0x1E, 4, 0x04, nil) // MBC5+RUMBLE+RAM+BATTERY, 16 RAM banks
+	cons, err := MakeConsole(rom, frontend)
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+
+	cons.Write(0x0000, 0x0A)
+	cons.Write(0x4000, 0x01)
+	cons.Write(0xA000, 0x42)
+	// Bit 3 drives the motor and does not select the RAM bank
+	for _, value := range []uint8{0x09, 0x09, 0x01, 0x08} {
+		cons.Write(0x4000, value)
+	}
+	cons.Write(0x4000, 0x09)
+	if v := cons.Read(0xA000); v != 0x42 {
+		t.Errorf("read %02x from RAM bank 9 (exp: 0x42 of bank 1)", v)
+	}
+
+	// Loading a state turns the motor back on
+	state := cons.SaveState()
+	cons.Write(0x4000, 0x01)
+	if err := cons.LoadState(state); err != nil {
+		t.Fatalf("unable to load the state: %s", err)
+	}
+
+	exp := []bool{true, false, true, false, true}
+	if fmt.Sprint(frontend.events) != fmt.Sprint(exp) {
+		t.Errorf("rumble events %v (exp: %v)", frontend.events, exp)
+	}
+}
+
+type memAccess struct {
+	write bool
+	addr  uint16
+	value uint8 // written, or expected when reading
+}
+
+func w(addr uint16, value uint8) memAccess { return memAccess{true, addr, value} }
+func r(addr uint16, value uint8) memAccess { return memAccess{false, addr, value} }
+
+// checkAccesses runs the accesses in order, checking the values read
+func checkAccesses(t *testing.T, name string, cons *Console, accesses []memAccess) {
+	t.Helper()
+	for i, acc := range accesses {
+		if acc.write {
+			cons.Write(acc.addr, acc.value)
+		} else if v := cons.Read(acc.addr); v != acc.value {
+			t.Errorf("%s: access %d read %02x at %04x (exp: %02x)", name, i, v, acc.addr, acc.value)
+		}
+	}
+}
+
+func makeMapperConsole(t *testing.T, cartType uint8, banks int, ramSize uint8) *Console {
+	t.Helper()
+	rom := makeTestROM(cartType, banks, ramSize, nil)
+	for bank := 1; bank < banks; bank++ {
+		rom[bank*0x4000] = uint8(bank)
+	}
+	cons, err := makeTestConsole(rom)
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+	return cons
+}
+
+func TestHuC1(t *testing.T) {
+	tests := []struct {
+		name     string
+		accesses []memAccess
+	}{
+		{"rom bank", []memAccess{r(0x4000, 1), w(0x2000, 3), r(0x4000, 3), w(0x2000, 0x45), r(0x4000, 5)}},
+		{"ram bank", []memAccess{w(0x4000, 2), w(0xA000, 0x42), w(0x4000, 1), r(0xA000, 0), w(0x4000, 2), r(0xA000, 0x42)}},
+		{"ir mode", []memAccess{w(0x0000, 0x0E), r(0xA000, 0xC0), w(0xA000, 0x01), w(0x0000, 0x00), w(0x4000, 0), r(0xA000, 0)}},
+	}
+	for _, test := range tests {
+		cons := makeMapperConsole(t, 0xFF, 8, 0x03) // HuC1+RAM+BATTERY
+		checkAccesses(t, test.name, cons, test.accesses)
+	}
+	cons := makeMapperConsole(t, 0xFF, 8, 0x03)
+	checkAccesses(t, "ir led", cons, []memAccess{w(0x0000, 0x0E), w(0xA000, 0x01)})
+	if !cons.Cart.Map.(*HuC1Mapper).irLED {
+		t.Errorf("the IR LED is off")
+	}
+}
+
+// huc3Command runs a command of the HuC3 clock and returns its result
+func huc3Command(cons *Console, value uint8) uint8 {
+	cons.Write(0x0000, huc3ModeCommand)
+	cons.Write(0xA000, value)
+	cons.Write(0x0000, huc3ModeResponse)
+	return cons.Read(0xA000)
+}
+
+func TestHuC3Clock(t *testing.T) {
+	cons := makeMapperConsole(t, 0xFE, 8, 0x03) // HuC3
+	clock := MakeManualClock(1000)
+	cons.SetClock(clock)
+
+	// Set 4 days, 0x123 minutes
+	huc3Command(cons, 0x40)
+	huc3Command(cons, 0x50)
+	for _, nibble := range []uint8{3, 2, 1, 4, 0, 0} {
+		huc3Command(cons, 0x30|nibble)
+	}
+	huc3Command(cons, 0x61)
+	clock.Add(120)
+
+	tests := []struct {
+		name  string
+		value uint8
+		exp   uint8
+	}{
+		{"copy the time", 0x60, 0x80 | 0x60},
+		{"address low", 0x40, 0x80 | 0x40},
+		{"address high", 0x50, 0x80 | 0x50},
+		{"minutes 0", 0x10, 0x80 | 0x10 | 5},
+		{"minutes 1", 0x10, 0x80 | 0x10 | 2},
+		{"minutes 2", 0x10, 0x80 | 0x10 | 1},
+		{"days 0", 0x10, 0x80 | 0x10 | 4},
+		{"status", 0x62, 0x80 | 0x60 | 1},
+	}
+	for _, test := range tests {
+		if v := huc3Command(cons, test.value); v != test.exp {
+			t.Errorf("%s: read %02x (exp: %02x)", test.name, v, test.exp)
+		}
+	}
+	checkAccesses(t, "semaphore and ir", cons, []memAccess{
+		w(0x0000, huc3ModeSemaphore), r(0xA000, 0xFF),
+		w(0x0000, huc3ModeIR), r(0xA000, 0xC0),
+		w(0x0000, huc3ModeRAM), w(0xA000, 0x42),
+		w(0x0000, huc3ModeRAMReadOnly), w(0xA000, 0x00), r(0xA000, 0x42),
+	})
+}
+
+func TestHuC3Sav(t *testing.T) {
+	cons := makeMapperConsole(t, 0xFE, 8, 0x03)
+	clock := MakeManualClock(1000)
+	cons.SetClock(clock)
+	m := cons.Cart.Map.(*HuC3Mapper)
+	m.setTime(0x123, 4)
+	m.alarmMinutes, m.alarmEnabled = 0x30, true
+
+	sav, _ := cons.StoreSav()
+	if len(sav) != 4*0x2000+HuC3FooterSize {
+		t.Fatalf("the .sav file is %d bytes", len(sav))
+	}
+	footer, err := ParseHuC3Footer(sav[4*0x2000:])
+	if err != nil || footer.Timestamp != 1000 || footer.Minutes != 0x123 || footer.Days != 4 ||
+		footer.AlarmMinutes != 0x30 || !footer.AlarmEnabled {
+		t.Errorf("unexpected footer %s: %v", footer, err)
+	}
+
+	tests := []struct {
+		name    string
+		sav     []byte
+		ok      bool
+		minutes uint16
+	}{
+		{"an hour later", sav, true, 0x123 + 60},
+		{"without footer", sav[:4*0x2000], true, 0x123 + 60},
+		{"truncated footer", sav[:len(sav)-1], false, 0},
+		{"truncated RAM", sav[:0x2000], false, 0},
+	}
+	loaded := makeMapperConsole(t, 0xFE, 8, 0x03)
+	loaded.SetClock(MakeManualClock(1000 + 60*60))
+	lm := loaded.Cart.Map.(*HuC3Mapper)
+	for _, test := range tests {
+		if err := lm.LoadSav(test.sav); (err == nil) != test.ok {
+			t.Errorf("%s: LoadSav returned %v", test.name, err)
+			continue
+		}
+		if minutes, _ := lm.getTime(); test.ok && minutes != test.minutes {
+			t.Errorf("%s: %x minutes (exp: %x)", test.name, minutes, test.minutes)
+		}
+	}
+	if !lm.alarmEnabled || lm.alarmMinutes != 0x30 {
+		t.Errorf("the alarm is not loaded")
+	}
+}
+
+func TestROMRAM(t *testing.T) {
+	tests := []struct {
+		name     string
+		cartType uint8
+		ramSize  uint8
+		accesses []memAccess
+	}{
+		{"rom only", 0x00, 0x00, []memAccess{w(0x2000, 0x01), r(0x4000, 1), w(0xA000, 0x42), r(0xA000, 0xFF)}},
+		{"rom+ram", 0x08, 0x02, []memAccess{w(0xA000, 0x42), r(0xA000, 0x42), w(0xBFFF, 0x43), r(0xBFFF, 0x43)}},
+		{"rom+ram+battery", 0x09, 0x02, []memAccess{w(0x0000, 0x00), w(0xA000, 0x42), r(0xA000, 0x42)}},
+	}
+	for _, test := range tests {
+		cons := makeMapperConsole(t, test.cartType, 2, test.ramSize)
+		checkAccesses(t, test.name, cons, test.accesses)
+	}
+}
+
+func TestMBC2Sav(t *testing.T) {
+	tests := []struct {
+		name     string
+		cartType uint8
+		savSize  int
+	}{
+		{"mbc2", 0x05, 0},
+		{"mbc2+battery", 0x06, MBC2RamSize},
+	}
+	for _, test := range tests {
+		cons := makeMapperConsole(t, test.cartType, 4, 0x00)
+		checkAccesses(t, test.name, cons, []memAccess{w(0x0000, 0x0A), w(0xA000, 0x07), r(0xA000, 0xF7)})
+		sav, err := cons.StoreSav()
+		if err != nil || len(sav) != test.savSize {
+			t.Errorf("%s: the .sav file is %d bytes: %v", test.name, len(sav), err)
+		}
+		if err := cons.LoadSav(make([]byte, MBC2RamSize)); err != nil {
+			t.Errorf("%s: unable to load the .sav file: %s", test.name, err)
+		}
+	}
+}
+
+// makeMMM01Console builds a 512KB MMM01 cart, with the menu in the last
+// 32KB. Every bank starts with its number
+func makeMMM01Console(t *testing.T) *Console {
+	rom := makeTestROM(0x01, 32, 0x00, nil)
+	menu := makeTestROM(0x0D, 32, 0x03, nil) // MMM01+RAM+BATTERY
+	copy(rom[len(rom)-0x8000:], menu[:0x150])
+	for bank := 0; bank < 32; bank++ {
+		rom[bank*0x4000] = uint8(bank)
+	}
+	cons, err := makeTestConsole(rom)
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+	return cons
+}
+
+func TestMMM01(t *testing.T) {
+	// The game in banks 8-15: the bits 3-4 of the ROM bank select it
+	selectGame := []memAccess{w(0x2000, 0x08), w(0x6000, 0x0C<<2), w(0x0000, 0x4A)}
+	tests := []struct {
+		name     string
+		accesses []memAccess
+	}{
+		{"menu", []memAccess{r(0x0000, 30), r(0x4000, 31), w(0x2000, 0x05), r(0x4000, 31)}},
+		{"game", append(selectGame, r(0x0000, 8), r(0x4000, 9))},
+		{"game bank", append(selectGame, w(0x2000, 0x03), r(0x0000, 8), r(0x4000, 11))},
+		{"game bits locked", append(selectGame, w(0x2000, 0x1F), r(0x0000, 8), r(0x4000, 15))},
+		{"mapping locked", append(selectGame, w(0x0000, 0x00), r(0x0000, 8))},
+		{"ram", append(selectGame, w(0x4000, 0x01), w(0xA000, 0x42), w(0x4000, 0x00), r(0xA000, 0x00), w(0x4000, 0x01), r(0xA000, 0x42))},
+		{"ram disabled", append(selectGame, w(0xA000, 0x42), w(0x0000, 0x00), r(0xA000, 0xFF))},
+	}
+	for _, test := range tests {
+		checkAccesses(t, test.name, makeMMM01Console(t), test.accesses)
+	}
+}
+
+func TestMBC6Flash(t *testing.T) {
+	// Flash bank 2 at 4000 - 5FFF (sector 0), flash bank 0x11 at 6000 - 7FFF
+	// (sector 1). 5555 and 6AAA are the addresses of the unlock sequence
+	setup := []memAccess{
+		w(0x0C00, 0x01), w(0x1000, 0x01),
+		w(0x2000, 0x02), w(0x2800, 0x08), w(0x3000, 0x11), w(0x3800, 0x08),
+	}
+	unlock := []memAccess{w(0x5555, 0xAA), w(0x6AAA, 0x55)}
+	program := func(addr uint16, value uint8) []memAccess {
+		return append(unlock, w(0x5555, 0xA0), w(addr, value))
+	}
+	erase := func(addr uint16, value uint8) []memAccess {
+		res := append(unlock, w(0x5555, 0x80))
+		return append(append(res, unlock...), w(addr, value))
+	}
+	programmed := append(program(0x4000, 0x42), program(0x6000, 0x43)...)
+
+	tests := []struct {
+		name     string
+		accesses [][]memAccess
+	}{
+		{"rom", [][]memAccess{{w(0x2800, 0x00), w(0x2000, 0x04), r(0x4000, 2), r(0x5555, 0x00)}}},
+		{"erased", [][]memAccess{{r(0x4000, 0xFF), r(0x7FFF, 0xFF)}}},
+		{"id", [][]memAccess{unlock, {w(0x5555, 0x90), r(0x4000, 0xC2), r(0x4001, 0x81), w(0x4000, 0xF0), r(0x4000, 0xFF)}}},
+		{"program", [][]memAccess{program(0x4000, 0x42), {r(0x4000, 0x42)}, program(0x4000, 0x0F), {r(0x4000, 0x02)}}},
+		{"without unlock", [][]memAccess{{w(0x5555, 0xA0), w(0x4000, 0x42), r(0x4000, 0xFF)}}},
+		{"reset", [][]memAccess{unlock, {w(0x4000, 0xF0), w(0x5555, 0xA0), w(0x4000, 0x42), r(0x4000, 0xFF)}}},
+		{"write protected", [][]memAccess{{w(0x1000, 0x00)}, program(0x4000, 0x42), {r(0x4000, 0xFF)}}},
+		{"disabled", [][]memAccess{program(0x4000, 0x42), {w(0x0C00, 0x00), r(0x4000, 0xFF), w(0x0C00, 0x01), r(0x4000, 0x42)}}},
+		{"sector erase", [][]memAccess{programmed, erase(0x4000, 0x30), {r(0x4000, 0xFF), r(0x6000, 0x43)}}},
+		{"chip erase", [][]memAccess{programmed, erase(0x5555, 0x10), {r(0x4000, 0xFF), r(0x6000, 0xFF)}}},
+	}
+	for _, test := range tests {
+		cons := makeMapperConsole(t, 0x20, 8, 0x03) // MBC6
+		checkAccesses(t, test.name, cons, setup)
+		for _, accesses := range test.accesses {
+			checkAccesses(t, test.name, cons, accesses)
+		}
+	}
+
+	cons := makeMapperConsole(t, 0x20, 8, 0x03)
+	checkAccesses(t, "sav", cons, append(setup, program(0x4000, 0x42)...))
+	sav, _ := cons.StoreSav()
+	if len(sav) != 4*0x2000+MBC6FlashSize || sav[4*0x2000+0x4000] != 0x42 {
+		t.Fatalf("the flash is not in the .sav file")
+	}
+	loaded := makeMapperConsole(t, 0x20, 8, 0x03)
+	if err := loaded.LoadSav(sav); err != nil {
+		t.Fatalf("unable to load the .sav file: %s", err)
+	}
+	checkAccesses(t, "loaded sav", loaded, append(setup, r(0x4000, 0x42)))
+	if err := loaded.LoadSav(sav[:4*0x2000+1]); err == nil {
+		t.Errorf("truncated flash accepted")
+	}
+}
+
+// tama5Command runs a command of the TAMA5 and returns its output
+func tama5Command(cons *Console, cmd, addr, data uint8) uint8 {
+	set := func(reg, value uint8) {
+		cons.Write(0xA001, reg)
+		cons.Write(0xA000, value)
+	}
+	set(tama5RegDataLow, data&0xF)
+	set(tama5RegDataHigh, data>>4)
+	set(tama5RegAddrHigh, cmd<<1|addr>>4)
+	set(tama5RegAddrLow, addr&0xF)
+	cons.Write(0xA001, tama5RegOutLow)
+	low := cons.Read(0xA000) & 0xF
+	cons.Write(0xA001, tama5RegOutHigh)
+	return (cons.Read(0xA000)&0xF)<<4 | low
+}
+
+func TestTAMA5(t *testing.T) {
+	cons := makeMapperConsole(t, 0xFD, 32, 0x00) // BANDAI TAMA5
+	clock := MakeManualClock(1000)
+	cons.SetClock(clock)
+	checkAccesses(t, "rom bank", cons, []memAccess{
+		w(0xA001, tama5RegROMLow), w(0xA000, 0x03), r(0x4000, 3),
+		w(0xA001, tama5RegROMHigh), w(0xA000, 0x01), r(0x4000, 19),
+		w(0xA001, tama5RegReady), r(0xA000, 0xF1),
+	})
+
+	// Saturday 2000-01-01 00:00:00 + 1d 01:01:01
+	clock.Add(24*60*60 + 60*60 + 60 + 1)
+	tests := []struct {
+		name string
+		cmd  uint8
+		addr uint8
+		data uint8
+		exp  uint8
+	}{
+		{"write ram", tama5CmdWriteRAM, 0x1F, 0x42, 0x00},
+		{"read ram", tama5CmdReadRAM, 0x1F, 0x00, 0x42},
+		{"seconds", tama5CmdReadClock, tama5ClockSecUnits, 0x00, 1},
+		{"minutes", tama5CmdReadClock, tama5ClockMinUnits, 0x00, 1},
+		{"hours", tama5CmdReadClock, tama5ClockHourUnits, 0x00, 1},
+		{"weekday", tama5CmdReadClock, tama5ClockWeekday, 0x00, 0},
+		{"day", tama5CmdReadClock, tama5ClockDayUnits, 0x00, 2},
+		{"month", tama5CmdReadClock, tama5ClockMonthUnits, 0x00, 1},
+		{"set the year", tama5CmdWriteClock, tama5ClockYearUnits, 0x05, 0x00},
+		{"year", tama5CmdReadClock, tama5ClockYearUnits, 0x00, 5},
+		{"weekday of 2005", tama5CmdReadClock, tama5ClockWeekday, 0x00, 0},
+		{"weekday is read only", tama5CmdWriteClock, tama5ClockWeekday, 0x03, 0x00},
+		{"same day", tama5CmdReadClock, tama5ClockDayUnits, 0x00, 2},
+		{"set month 00", tama5CmdWriteClock, tama5ClockMonthUnits, 0x00, 0x00},
+		{"month clamped", tama5CmdReadClock, tama5ClockMonthUnits, 0x00, 1},
+		{"set day 00", tama5CmdWriteClock, tama5ClockDayUnits, 0x00, 0x00},
+		{"day clamped", tama5CmdReadClock, tama5ClockDayUnits, 0x00, 1},
+		{"same year", tama5CmdReadClock, tama5ClockYearUnits, 0x00, 5},
+		{"set the mode", tama5CmdWriteClock, tama5ClockMode, 0x04, 0x00},
+		{"mode", tama5CmdReadClock, tama5ClockMode, 0x00, 0x04},
+		{"set the alarm", tama5CmdWriteClock, tama5AlarmHourTens, 0x02, 0x00},
+		{"alarm", tama5CmdReadClock, tama5AlarmHourTens, 0x00, 0x02},
+	}
+	for _, test := range tests {
+		v := tama5Command(cons, test.cmd, test.addr, test.data)
+		if test.cmd == tama5CmdWriteRAM || test.cmd == tama5CmdWriteClock {
+			// the output registers keep the previous result
+			continue
+		}
+		if v != test.exp {
+			t.Errorf("%s: read %02x (exp: %02x)", test.name, v, test.exp)
+		}
+	}
+
+	sav, _ := cons.StoreSav()
+	if len(sav) != TAMA5RAMSize+TAMA5FooterSize || sav[0x1F] != 0x42 {
+		t.Fatalf("unexpected .sav file")
+	}
+	loaded := makeMapperConsole(t, 0xFD, 32, 0x00)
+	loaded.SetClock(MakeManualClock(clock.Time + 60))
+	if err := loaded.LoadSav(sav); err != nil {
+		t.Fatalf("unable to load the .sav file: %s", err)
+	}
+	for _, test := range []struct {
+		name string
+		addr uint8
+		exp  uint8
+	}{
+		{"loaded minutes", tama5ClockMinUnits, 2},
+		{"loaded year", tama5ClockYearUnits, 5},
+		{"loaded mode", tama5ClockMode, 0x04},
+		{"loaded alarm", tama5AlarmHourTens, 0x02},
+	} {
+		if v := tama5Command(loaded, tama5CmdReadClock, test.addr, 0); v != test.exp {
+			t.Errorf("%s: read %02x (exp: %02x)", test.name, v, test.exp)
+		}
+	}
+	if err := loaded.LoadSav(sav[:TAMA5RAMSize+1]); err == nil {
+		t.Errorf("truncated footer accepted")
+	}
+}
@@ -1,6 +1,7 @@
 package gbc
 
 import (
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
@@ -8,6 +9,8 @@ import (
 )
 
 type RTC struct {
+	clock Clock
+
 	BaseTime, HaltTime int64
 	Seconds            uint8 // $08 RTC S   Seconds   0-59 ($00-$3B)
 	Minutes            uint8 // $09 RTC M   Minutes   0-59 ($00-$3B)
@@ -29,7 +32,7 @@ func (rtc RTC) Save(encoder *gob.Encoder) {
 	panicIfErr(encoder.Encode(rtc.DaysH))
 }
 
-func (rtc RTC) Load(decoder *gob.Decoder) error {
+func (rtc *RTC) Load(decoder *gob.Decoder) error {
 	errs := []error{
 		decoder.Decode(&rtc.BaseTime),
 		decoder.Decode(&rtc.HaltTime),
@@ -47,9 +50,10 @@ func (rtc RTC) Load(decoder *gob.Decoder) error {
 	return nil
 }
 
-func MakeRTC() RTC {
+func MakeRTC(clock Clock) RTC {
 	res := RTC{
-		BaseTime: time.Now().Unix(),
+		clock:    clock,
+		BaseTime: clock.Now(),
 		DaysH:    64,
 	}
 	res.SyncTime()
@@ -69,25 +73,53 @@ func (rtc *RTC) getTime() int64 {
 	if rtc.IsHalted() {
 		return rtc.HaltTime
 	}
-	return time.Now().Unix()
+	return rtc.clock.Now()
 }
 
-func (rtc *RTC) recomputeBaseDate() {
-	base := rtc.getTime()
+// SetClock changes the source of time of the RTC, preserving the
+// elapsed time
+func (rtc *RTC) SetClock(clock Clock) {
+	elapsed := rtc.getTime() - rtc.BaseTime
+	rtc.clock = clock
+	if rtc.IsHalted() {
+		rtc.HaltTime = clock.Now()
+	}
+	rtc.BaseTime = rtc.getTime() - elapsed
+}
 
-	days := int64(rtc.DaysL)
-	days += int64(rtc.DaysH&1) * 0x100
-	days += int64((rtc.DaysH>>7)&1) * 0x200
+func regsToSeconds(seconds, minutes, hours, daysL, daysH uint8) int64 {
+	days := int64(daysL)
+	days += int64(daysH&1) * 0x100
+	days += int64((daysH>>7)&1) * 0x200
 
-	base -= days * 60 * 60 * 24
-	base -= int64(rtc.Hours) * 60 * 60
-	base -= int64(rtc.Minutes) * 60
-	base -= int64(rtc.Seconds)
+	res := days * 60 * 60 * 24
+	res += int64(hours) * 60 * 60
+	res += int64(minutes) * 60
+	res += int64(seconds)
+	return res
+}
 
-	rtc.BaseTime = base
+// Elapsed returns the number of seconds counted by the clock
+func (rtc *RTC) Elapsed() int64 {
+	now := rtc.getTime()
+	if now < rtc.BaseTime {
+		rtc.BaseTime = now
+	}
+	return now - rtc.BaseTime
 }
 
-func (rtc *RTC) SyncTime() {
+// SetElapsed sets the number of seconds counted by the clock
+func (rtc *RTC) SetElapsed(seconds int64) {
+	rtc.BaseTime = rtc.getTime() - seconds
+}
+
+func (rtc *RTC) recomputeBaseDate() {
+	rtc.BaseTime = rtc.getTime() - regsToSeconds(
+		rtc.Seconds, rtc.Minutes, rtc.Hours, rtc.DaysL, rtc.DaysH)
+}
+
+// Compute the value of the (non latched) registers from the elapsed time
+func (rtc *RTC) currentRegs() (seconds, minutes, hours, daysL, daysH uint8) {
 	now := rtc.getTime()
 	if now >= rtc.BaseTime {
 		now = now - rtc.BaseTime
@@ -98,18 +130,23 @@ func (rtc *RTC) SyncTime() {
 		now = 0
 	}
 
-	rtc.Seconds = uint8(now % 60)
+	seconds = uint8(now % 60)
 	now /= 60
-	rtc.Minutes = uint8(now % 60)
+	minutes = uint8(now % 60)
 	now /= 60
-	rtc.Hours = uint8(now % 24)
+	hours = uint8(now % 24)
 	now /= 24
-	rtc.DaysL = uint8(now & 0xff)
-	rtc.DaysH &= 0x40
-	rtc.DaysH |= uint8((now >> 8) & 1)
+	daysL = uint8(now & 0xff)
+	daysH = rtc.DaysH & 0x40
+	daysH |= uint8((now >> 8) & 1)
 	if now > 0x1ff {
-		rtc.DaysH |= 0x80
+		daysH |= 0x80
 	}
+	return
+}
+
+func (rtc *RTC) SyncTime() {
+	rtc.Seconds, rtc.Minutes, rtc.Hours, rtc.DaysL, rtc.DaysH = rtc.currentRegs()
 }
 
 func (rtc *RTC) SetReg(addr uint8, value uint8) {
@@ -127,7 +164,7 @@ func (rtc *RTC) SetReg(addr uint8, value uint8) {
 	case 0x0C:
 		rtc.DaysH = value
 		if !wasHalted && rtc.IsHalted() {
-			rtc.HaltTime = time.Now().Unix()
+			rtc.HaltTime = rtc.clock.Now()
 		}
 	default:
 		fmt.Printf("unexpected write to RTC @ 0x%02x <- %02x\n", addr, value)
@@ -155,6 +192,102 @@ func (rtc *RTC) GetReg(addr uint8) uint8 {
 	return 0xFF
 }
 
+// Size of the RTC footer that VBA, BGB and most of the other emulators
+// append to the .sav file of MBC3+TIMER carts. Some of them write a 32 bit
+// timestamp, resulting in a shorter footer
+const (
+	RTCFooterSize   = 48
+	RTCFooterSize32 = 44
+)
+
+// RTCFooter is the content of the RTC footer of a .sav file. Every register
+// is stored as a little endian 32 bit value, followed by the UNIX timestamp
+// of the moment in which the file was written
+type RTCFooter struct {
+	Seconds, Minutes, Hours, DaysL, DaysH uint8
+
+	LatchedSeconds, LatchedMinutes, LatchedHours uint8
+	LatchedDaysL, LatchedDaysH                   uint8
+
+	Timestamp int64
+}
+
+func ParseRTCFooter(data []byte) (*RTCFooter, error) {
+	if len(data) != RTCFooterSize && len(data) != RTCFooterSize32 {
+		return nil, CartError("Invalid RTC footer size")
+	}
+
+	regs := make([]uint8, 10)
+	for i := 0; i < 10; i++ {
+		regs[i] = uint8(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	res := &RTCFooter{
+		Seconds:        regs[0],
+		Minutes:        regs[1],
+		Hours:          regs[2],
+		DaysL:          regs[3],
+		DaysH:          regs[4],
+		LatchedSeconds: regs[5],
+		LatchedMinutes: regs[6],
+		LatchedHours:   regs[7],
+		LatchedDaysL:   regs[8],
+		LatchedDaysH:   regs[9],
+	}
+	if len(data) == RTCFooterSize {
+		res.Timestamp = int64(binary.LittleEndian.Uint64(data[40:]))
+	} else {
+		res.Timestamp = int64(binary.LittleEndian.Uint32(data[40:]))
+	}
+	return res, nil
+}
+
+func (f *RTCFooter) Bytes() []byte {
+	res := make([]byte, RTCFooterSize)
+	regs := []uint8{
+		f.Seconds, f.Minutes, f.Hours, f.DaysL, f.DaysH,
+		f.LatchedSeconds, f.LatchedMinutes, f.LatchedHours,
+		f.LatchedDaysL, f.LatchedDaysH,
+	}
+	for i, reg := range regs {
+		binary.LittleEndian.PutUint32(res[i*4:], uint32(reg))
+	}
+	binary.LittleEndian.PutUint64(res[40:], uint64(f.Timestamp))
+	return res
+}
+
+func (f *RTCFooter) String() string {
+	return fmt.Sprintf("%dd %02d:%02d:%02d (halted: %v), saved at %s",
+		int(f.DaysL)|int(f.DaysH&1)<<8, f.Hours, f.Minutes, f.Seconds,
+		f.DaysH&0x40 != 0, time.Unix(f.Timestamp, 0).UTC().Format(time.RFC3339))
+}
+
+func (rtc *RTC) Footer() *RTCFooter {
+	res := &RTCFooter{
+		LatchedSeconds: rtc.Seconds,
+		LatchedMinutes: rtc.Minutes,
+		LatchedHours:   rtc.Hours,
+		LatchedDaysL:   rtc.DaysL,
+		LatchedDaysH:   rtc.DaysH,
+		Timestamp:      rtc.clock.WallTime(),
+	}
+	res.Seconds, res.Minutes, res.Hours, res.DaysL, res.DaysH = rtc.currentRegs()
+	return res
+}
+
+func (rtc *RTC) LoadFooter(f *RTCFooter) {
+	rtc.Seconds = f.LatchedSeconds
+	rtc.Minutes = f.LatchedMinutes
+	rtc.Hours = f.LatchedHours
+	rtc.DaysL = f.LatchedDaysL
+	// the halt flag is not latched
+	rtc.DaysH = (f.LatchedDaysH & ^uint8(0x40)) | (f.DaysH & 0x40)
+
+	// The clock kept running (unless halted) since the file was written
+	elapsed := regsToSeconds(f.Seconds, f.Minutes, f.Hours, f.DaysL, f.DaysH)
+	rtc.HaltTime = fromWallTime(rtc.clock, f.Timestamp)
+	rtc.BaseTime = rtc.HaltTime - elapsed
+}
+
 func (rtc *RTC) Marshal() ([]byte, error) {
 	return json.Marshal(rtc)
 }
@@ -166,3 +299,51 @@ func (rtc *RTC) UnMarshal(data []byte) error {
 	}
 	return nil
 }
+
+// Size of the HuC3 clock footer appended to the .sav file (same layout
+// used by SameBoy)
+const HuC3FooterSize = 17
+
+// HuC3Footer is the content of the HuC3 footer of a .sav file: the UNIX
+// timestamp of the moment in which the file was written (64 bits), the
+// time and the alarm (16 bits each) and the alarm enable flag (8 bits),
+// all little endian
+type HuC3Footer struct {
+	Timestamp               int64
+	Minutes, Days           uint16
+	AlarmMinutes, AlarmDays uint16
+	AlarmEnabled            bool
+}
+
+func ParseHuC3Footer(data []byte) (*HuC3Footer, error) {
+	if len(data) != HuC3FooterSize {
+		return nil, CartError("Invalid HuC3 footer size")
+	}
+	return &HuC3Footer{
+		Timestamp:    int64(binary.LittleEndian.Uint64(data[0:])),
+		Minutes:      binary.LittleEndian.Uint16(data[8:]),
+		Days:         binary.LittleEndian.Uint16(data[10:]),
+		AlarmMinutes: binary.LittleEndian.Uint16(data[12:]),
+		AlarmDays:    binary.LittleEndian.Uint16(data[14:]),
+		AlarmEnabled: data[16] != 0,
+	}, nil
+}
+
+func (f *HuC3Footer) Bytes() []byte {
+	res := make([]byte, HuC3FooterSize)
+	binary.LittleEndian.PutUint64(res[0:], uint64(f.Timestamp))
+	binary.LittleEndian.PutUint16(res[8:], f.Minutes)
+	binary.LittleEndian.PutUint16(res[10:], f.Days)
+	binary.LittleEndian.PutUint16(res[12:], f.AlarmMinutes)
+	binary.LittleEndian.PutUint16(res[14:], f.AlarmDays)
+	if f.AlarmEnabled {
+		res[16] = 1
+	}
+	return res
+}
+
+func (f *HuC3Footer) String() string {
+	return fmt.Sprintf("%dd %02d:%02d, saved at %s",
+		f.Days, f.Minutes/60, f.Minutes%60,
+		time.Unix(f.Timestamp, 0).UTC().Format(time.RFC3339))
+}
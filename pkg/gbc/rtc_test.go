@@ -0,0 +1,161 @@
+package gbc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"testing"
+	"time"
+)
+
+// makeRTCFooter builds a footer with the time 0d 00:01:05, latched at
+// 0d 00:01:00, written at timestamp
+func makeRTCFooter(size int, daysH uint8, timestamp int64) []byte {
+	res := make([]byte, size)
+	for i, reg := range []uint8{5, 1, 0, 0, daysH, 0, 1, 0, 0, daysH} {
+		binary.LittleEndian.PutUint32(res[i*4:], uint32(reg))
+	}
+	if size == RTCFooterSize {
+		binary.LittleEndian.PutUint64(res[40:], uint64(timestamp))
+	} else {
+		binary.LittleEndian.PutUint32(res[40:], uint32(timestamp))
+	}
+	return res
+}
+
+func TestRTCFooter(t *testing.T) {
+	tests := []struct {
+		name    string
+		footer  []byte
+		elapsed int64 // 100 seconds after the footer was written
+	}{
+		{"64 bit timestamp", makeRTCFooter(RTCFooterSize, 0x00, 1000), 165},
+		{"32 bit timestamp", makeRTCFooter(RTCFooterSize32, 0x00, 1000), 165},
+		{"halted", makeRTCFooter(RTCFooterSize, 0x40, 1000), 65},
+	}
+	for _, test := range tests {
+		footer, err := ParseRTCFooter(test.footer)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if footer.Seconds != 5 || footer.Minutes != 1 || footer.LatchedSeconds != 0 || footer.Timestamp != 1000 {
+			t.Errorf("%s: unexpected footer %s", test.name, footer)
+		}
+		if res := footer.Bytes(); !bytes.Equal(res[:40], test.footer[:40]) || len(res) != RTCFooterSize {
+			t.Errorf("%s: the footer is not written back", test.name)
+		}
+
+		rtc := MakeRTC(MakeManualClock(1100))
+		rtc.LoadFooter(footer)
+		if rtc.Minutes != 1 || rtc.Seconds != 0 {
+			t.Errorf("%s: latched %s", test.name, &rtc)
+		}
+		if elapsed := rtc.getTime() - rtc.BaseTime; elapsed != test.elapsed {
+			t.Errorf("%s: elapsed %d (exp: %d)", test.name, elapsed, test.elapsed)
+		}
+	}
+
+	if _, err := ParseRTCFooter(make([]byte, 40)); err == nil {
+		t.Errorf("invalid footer size accepted")
+	}
+}
+
+func TestMBC3Sav(t *testing.T) {
+	cons, err := makeTestConsole(makeTestROM(0x10, 4, 0x02, nil)) // MBC3+TIMER+RAM+BATTERY
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+	cons.SetClock(MakeManualClock(1100))
+
+	tests := []struct {
+		name string
+		sav  []byte
+		ok   bool
+	}{
+		{"without footer", make([]byte, 0x2000), true},
+		{"64 bit footer", append(make([]byte, 0x2000), makeRTCFooter(RTCFooterSize, 0x00, 1000)...), true},
+		{"32 bit footer", append(make([]byte, 0x2000), makeRTCFooter(RTCFooterSize32, 0x00, 1000)...), true},
+		{"truncated footer", make([]byte, 0x2000+40), false},
+		{"truncated RAM", make([]byte, 0x1000), false},
+	}
+	for _, test := range tests {
+		if err := cons.Cart.Map.(*MBC3Mapper).LoadSav(test.sav); (err == nil) != test.ok {
+			t.Errorf("%s: LoadSav returned %v", test.name, err)
+		}
+	}
+
+	sav, _ := cons.StoreSav()
+	if len(sav) != 0x2000+RTCFooterSize {
+		t.Fatalf("the .sav file is %d bytes", len(sav))
+	}
+	footer, _ := ParseRTCFooter(sav[0x2000:])
+	if footer.Timestamp != 1100 || footer.Minutes != 2 || footer.Seconds != 45 {
+		t.Errorf("unexpected footer %s", footer)
+	}
+}
+
+func TestMBC3TimerWithoutRAM(t *testing.T) {
+	cons, err := makeTestConsole(makeTestROM(0x0F, 4, 0x00, nil)) // MBC3+TIMER+BATTERY
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+	clock := MakeManualClock(0)
+	cons.SetClock(clock)
+
+	cons.Write(0x0000, 0x0A)
+	cons.Write(0x4000, 0x01) // there is no RAM bank to select
+	if v := cons.Read(0xA000); v != 0x00 {
+		t.Errorf("read %02x from the missing RAM", v)
+	}
+	cons.Write(0x4000, 0x0C)
+	cons.Write(0xA000, 0x00) // start the clock
+	clock.Add(61)
+	cons.Write(0x6000, 0x00)
+	cons.Write(0x6000, 0x01)
+	cons.Write(0x4000, 0x09)
+	if v := cons.Read(0xA000); v != 1 {
+		t.Errorf("RTC minutes=%d (exp: 1)", v)
+	}
+	if sav, err := cons.StoreSav(); err != nil || len(sav) != RTCFooterSize {
+		t.Errorf("the .sav file is %d bytes (exp: only the RTC footer)", len(sav))
+	}
+}
+
+const ticksPerHour = GBCPU_FREQ / 4 * 60 * 60
+
+func TestFooterWallTime(t *testing.T) {
+	// An emulated clock one hour ahead of the host, as after fast-forward
+	clock := MakeEmulatedClock(time.Now().Unix())
+	rtc := MakeRTC(clock)
+	rtc.DaysH = 0
+	rtc.SetElapsed(100)
+	clock.Advance(ticksPerHour)
+
+	footer := rtc.Footer()
+	if d := footer.Timestamp - time.Now().Unix(); d < -2 || d > 2 {
+		t.Errorf("the footer timestamp is %ds off the host time", d)
+	}
+
+	// Loaded by the next run, with a clock starting from the host time
+	loaded := MakeRTC(MakeEmulatedClock(time.Now().Unix()))
+	loaded.LoadFooter(footer)
+	if elapsed := loaded.Elapsed(); elapsed < 3700 || elapsed > 3702 {
+		t.Errorf("elapsed %d seconds after the reload (exp: 3700)", elapsed)
+	}
+}
+
+func TestSaveClock(t *testing.T) {
+	clock := MakeEmulatedClock(1000)
+	clock.Advance(ticksPerHour)
+
+	buf := &bytes.Buffer{}
+	saveClock(gob.NewEncoder(buf), clock)
+	loaded := MakeEmulatedClock(5000)
+	if err := loadClock(gob.NewDecoder(buf), loaded); err != nil {
+		t.Fatalf("unable to load the clock: %s", err)
+	}
+	if *loaded != *clock {
+		t.Errorf("loaded %+v (exp: %+v)", *loaded, *clock)
+	}
+}
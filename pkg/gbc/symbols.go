@@ -0,0 +1,197 @@
+package gbc
+
+import (
+	"borzGBC/pkg/z80cpu"
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbol files, as generated by rgblink (-n) and no$gmb. Each line is
+// "bank:addr label" in hexadecimal, the lines starting with ';' are
+// comments and the ones starting with '[' are section headers:
+//
+//	; File generated by rgblink
+//	00:0150 Main
+//	02:4000 LoadLevel
+
+type Symbol struct {
+	Name string
+	Bank int
+	Addr uint16
+}
+
+func (s Symbol) String() string {
+	return fmt.Sprintf("%02x:%04x %s", s.Bank, s.Addr, s.Name)
+}
+
+type SymbolTable struct {
+	byName map[string]Symbol
+	sorted []Symbol // By bank and address, in file order at the same address
+}
+
+func ParseSymbols(data []byte) (*SymbolTable, error) {
+	st := &SymbolTable{byName: make(map[string]Symbol)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" || line[0] == '[' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		bank, addr, found := strings.Cut(fields[0], ":")
+		if len(fields) != 2 || !found {
+			return nil, DebugError(fmt.Sprintf("Invalid symbol at line %d", n))
+		}
+		b, errBank := strconv.ParseUint(bank, 16, 16)
+		a, errAddr := strconv.ParseUint(addr, 16, 16)
+		if errBank != nil || errAddr != nil {
+			return nil, DebugError(fmt.Sprintf("Invalid address at line %d", n))
+		}
+		sym := Symbol{Name: fields[1], Bank: int(b), Addr: uint16(a)}
+		if _, ok := st.byName[sym.Name]; !ok {
+			st.byName[sym.Name] = sym
+		}
+		st.sorted = append(st.sorted, sym)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(st.sorted, func(i, j int) bool {
+		x, y := st.sorted[i], st.sorted[j]
+		return x.Bank < y.Bank || x.Bank == y.Bank && x.Addr < y.Addr
+	})
+	return st, nil
+}
+
+func (st *SymbolTable) Symbols() []Symbol {
+	return st.sorted
+}
+
+// Lookup returns the symbol called name
+func (st *SymbolTable) Lookup(name string) (Symbol, bool) {
+	sym, ok := st.byName[name]
+	return sym, ok
+}
+
+// search returns the index of the first symbol at or after bank:addr
+func (st *SymbolTable) search(bank int, addr uint16) int {
+	return sort.Search(len(st.sorted), func(i int) bool {
+		s := st.sorted[i]
+		return s.Bank > bank || s.Bank == bank && s.Addr >= addr
+	})
+}
+
+// Label returns the name of the first symbol at bank:addr
+func (st *SymbolTable) Label(bank int, addr uint16) (string, bool) {
+	i := st.search(bank, addr)
+	if i < len(st.sorted) && st.sorted[i].Bank == bank && st.sorted[i].Addr == addr {
+		return st.sorted[i].Name, true
+	}
+	return "", false
+}
+
+// memoryRegion returns the first address of the region of addr, symbols
+// in other regions (e.g. ROM0 and WRAM0, both in bank 0) are never nearest
+func memoryRegion(addr uint16) uint16 {
+	for _, start := range []uint16{0xFF80, 0xFF00, 0xFE00, 0xE000, 0xD000, 0xC000, 0xA000, 0x8000, 0x4000} {
+		if addr >= start {
+			return start
+		}
+	}
+	return 0
+}
+
+// Nearest returns the symbol preceding bank:addr (or at bank:addr) in its
+// memory region
+func (st *SymbolTable) Nearest(bank int, addr uint16) (Symbol, bool) {
+	i := st.search(bank, addr+1)
+	if addr == 0xFFFF {
+		i = st.search(bank+1, 0)
+	}
+	if i == 0 {
+		return Symbol{}, false
+	}
+	// The first of the symbols at the same address
+	sym := st.sorted[i-1]
+	for i > 1 && st.sorted[i-2].Bank == sym.Bank && st.sorted[i-2].Addr == sym.Addr {
+		i -= 1
+		sym = st.sorted[i-1]
+	}
+	if sym.Bank != bank || memoryRegion(sym.Addr) != memoryRegion(addr) {
+		return Symbol{}, false
+	}
+	return sym, true
+}
+
+// Describe formats bank:addr as "label" or "label+offset", or returns an
+// empty string if there is no symbol before it
+func (st *SymbolTable) Describe(bank int, addr uint16) string {
+	sym, ok := st.Nearest(bank, addr)
+	if !ok {
+		return ""
+	}
+	if sym.Addr == addr {
+		return sym.Name
+	}
+	return fmt.Sprintf("%s+%x", sym.Name, addr-sym.Addr)
+}
+
+// consoleSymbols names the addresses for the disassembler, in the banks
+// mapped by the console or, in the switchable ROM bank, in romBank
+type consoleSymbols struct {
+	cons    *Console
+	romBank int
+}
+
+func (cs consoleSymbols) Symbolize(addr uint16) (string, bool) {
+	bank := cs.cons.Bank(addr)
+	if cs.romBank != AnyBank && 0x4000 <= addr && addr <= 0x7FFF {
+		bank = cs.romBank
+	}
+	return cs.cons.Symbols.Label(bank, addr)
+}
+
+// LoadSymbols parses a symbol file, used by the disassembler, the
+// expressions and the debug traces
+func (cons *Console) LoadSymbols(data []byte) error {
+	st, err := ParseSymbols(data)
+	if err != nil {
+		return err
+	}
+	cons.Symbols = st
+	cons.CPU.Disas.Symbols = consoleSymbols{cons: cons, romBank: AnyBank}
+	return nil
+}
+
+// DescribeAddr formats addr, in the banks currently mapped, as
+// "label+offset", or returns an empty string without symbols
+func (cons *Console) DescribeAddr(addr uint16) string {
+	if cons.Symbols == nil {
+		return ""
+	}
+	return cons.Symbols.Describe(cons.Bank(addr), addr)
+}
+
+// DisassembleBanked disassembles the instruction at addr in a given bank
+// (see ReadBanked). It returns the length of the instruction
+func (cons *Console) DisassembleBanked(bank int, addr uint16) (int, string) {
+	data := make([]byte, 3)
+	for i := range data {
+		data[i] = cons.ReadBanked(bank, addr+uint16(i))
+	}
+	if cons.Symbols != nil {
+		disas := &cons.CPU.Disas
+		defer func(prev z80cpu.Symbolizer) { disas.Symbols = prev }(disas.Symbols)
+		disas.Symbols = consoleSymbols{cons: cons, romBank: bank}
+	}
+	return cons.CPU.Disas.DisassembleOneFromData(addr, data)
+}
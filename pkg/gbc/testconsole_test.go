@@ -0,0 +1,40 @@
+package gbc
+
+import "math/bits"
+
+// Consoles running small synthetic ROMs, shared by the tests
+
+// testFrontend discards the video, the audio and the serial output
+type testFrontend struct{}
+
+func (testFrontend) SetPixel(x, y int, c uint32)                {}
+func (testFrontend) CommitScreen()                              {}
+func (testFrontend) NotifyAudioSample(l, r int8)                {}
+func (testFrontend) ExchangeSerial(sb, sc uint8) (uint8, uint8) { return 0, 0 }
+
+// makeTestROM builds a ROM of the given cartridge type and number of 16KB
+// banks (a power of two), with a valid header. ramSize is the RAM size code
+// of the header, the entry point jumps to code, copied at 0x150
+func makeTestROM(cartType uint8, banks int, ramSize uint8, code []byte) []byte {
+	rom := make([]byte, banks*0x4000)
+	copy(rom[0x100:], []byte{0x00, 0xC3, 0x50, 0x01}) // jp 0x150
+	copy(rom[0x104:], NintendoLogo[:])
+	rom[0x147] = cartType
+	rom[0x148] = uint8(bits.Len(uint(banks)) - 2)
+	rom[0x149] = ramSize
+	rom[0x14D] = computeHeaderChecksum(rom)
+	copy(rom[0x150:], code)
+	return rom
+}
+
+// makeTestConsole creates a console running rom, past the boot ROM
+func makeTestConsole(rom []byte) (*Console, error) {
+	cons, err := MakeConsole(rom, testFrontend{})
+	if err != nil {
+		return nil, err
+	}
+	cons.InBootROM = false
+	cons.CPU.PC = 0x100
+	cons.CPU.SP = 0xFFFE
+	return cons, nil
+}
@@ -52,6 +52,12 @@ func (t *Timer) reset() {
 	t.TIMA = t.TMA
 }
 
+// resetDiv is used by STOP, without the side effects of writing DIV
+func (t *Timer) resetDiv() {
+	t.divCounter = 0
+	t.DIV = 0
+}
+
 func (t *Timer) updateDiv(ticks int) {
 	t.divCounter += ticks * 4
 	for t.divCounter >= DIV_THRESHOLD {
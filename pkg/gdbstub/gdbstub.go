@@ -0,0 +1,533 @@
+package gdbstub
+
+import (
+	"borzGBC/pkg/gbc"
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Server implements the GDB remote serial protocol on top of a Debugger.
+// The registers are AF, BC, DE, HL, SP and PC, 16 bits each (the first ones
+// of the z80 target of GDB). Breakpoint addresses above 0xFFFF select a ROM
+// bank: 0x24000 is 0x4000 in bank 2
+//
+// The target is stopped when the connection is established. Frontends with
+// their own main loop call Frame once per frame, the other ones call Run
+
+const targetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.borzgbc.sm83">
+    <reg name="af" bitsize="16" type="int"/>
+    <reg name="bc" bitsize="16" type="int"/>
+    <reg name="de" bitsize="16" type="int"/>
+    <reg name="hl" bitsize="16" type="int"/>
+    <reg name="sp" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>
+`
+
+const numRegisters = 6
+
+type GDBError string
+
+func (err GDBError) Error() string {
+	return string(err)
+}
+
+// Signals of the stop replies
+const (
+	sigInt  = 2
+	sigIll  = 4
+	sigTrap = 5
+)
+
+type Server struct {
+	dbg  *gbc.Debugger
+	conn io.ReadWriteCloser
+
+	writeLock sync.Mutex
+	noAck     atomic.Bool
+
+	packets    chan string
+	interrupts chan struct{}
+	closed     chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+
+	running bool
+
+	// Breakpoints and watchpoints set by GDB, by the arguments of Z
+	points map[string]int
+
+	Verbose bool
+}
+
+// Listen waits for a GDB connection on addr (e.g., "localhost:2345")
+func Listen(addr string, dbg *gbc.Debugger) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return MakeServer(conn, dbg), nil
+}
+
+// MakeServer serves a GDB connection, the target is initially stopped
+func MakeServer(conn io.ReadWriteCloser, dbg *gbc.Debugger) *Server {
+	s := &Server{
+		dbg:        dbg,
+		conn:       conn,
+		packets:    make(chan string),
+		interrupts: make(chan struct{}, 1),
+		closed:     make(chan struct{}),
+		done:       make(chan struct{}),
+		points:     make(map[string]int),
+	}
+	go s.readPackets()
+	return s
+}
+
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.conn.Close()
+	})
+}
+
+// Running is false while GDB holds the target stopped
+func (s *Server) Running() bool {
+	return s.running
+}
+
+func checksum(data string) uint8 {
+	res := uint8(0)
+	for i := 0; i < len(data); i++ {
+		res += data[i]
+	}
+	return res
+}
+
+func (s *Server) write(data string) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	s.conn.Write([]byte(data))
+}
+
+func (s *Server) send(data string) {
+	if s.Verbose {
+		log.Printf("gdb <- %s\n", data)
+	}
+	s.write(fmt.Sprintf("$%s#%02x", data, checksum(data)))
+}
+
+func (s *Server) readPackets() {
+	defer close(s.closed)
+	r := bufio.NewReader(s.conn)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch c {
+		case 0x03:
+			select {
+			case s.interrupts <- struct{}{}:
+			default:
+			}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(r, sum); err != nil {
+				return
+			}
+			if !s.noAck.Load() {
+				if fmt.Sprintf("%02x", checksum(data)) != strings.ToLower(string(sum)) {
+					s.write("-")
+					continue
+				}
+				s.write("+")
+			}
+			select {
+			case s.packets <- data:
+			case <-s.done:
+				return
+			}
+		}
+		// Acks ('+' and '-') are ignored, the connection is reliable
+	}
+}
+
+// Frame handles the pending requests and, unless the target is stopped,
+// runs a frame. It returns the M-cycles run, and io.EOF when GDB is gone
+func (s *Server) Frame() (int, error) {
+	for pending := true; pending; {
+		select {
+		case pkt := <-s.packets:
+			s.handle(pkt)
+		case <-s.interrupts:
+			s.interrupt()
+		case <-s.closed:
+			return 0, io.EOF
+		default:
+			pending = false
+		}
+	}
+	if !s.running {
+		return 0, nil
+	}
+	ev := s.dbg.RunFrame()
+	if ev.Reason != gbc.StopFrame {
+		s.stopped(ev)
+	}
+	return ev.Ticks, nil
+}
+
+// Run serves GDB until it detaches or kills the target
+func (s *Server) Run() error {
+	for {
+		if s.running {
+			if _, err := s.Frame(); err != nil {
+				return nil
+			}
+			continue
+		}
+		select {
+		case pkt := <-s.packets:
+			s.handle(pkt)
+		case <-s.interrupts:
+		case <-s.closed:
+			return nil
+		}
+	}
+}
+
+func (s *Server) interrupt() {
+	if s.running {
+		s.running = false
+		s.send(fmt.Sprintf("S%02x", sigInt))
+	}
+}
+
+func stopReply(ev gbc.StopEvent) string {
+	switch ev.Reason {
+	case gbc.StopLocked:
+		return fmt.Sprintf("S%02x", sigIll)
+	case gbc.StopWatchpoint:
+		kind := ""
+		switch ev.Watchpoint.Kind & (gbc.WatchRead | gbc.WatchWrite) {
+		case gbc.WatchWrite:
+			kind = "watch"
+		case gbc.WatchRead:
+			kind = "rwatch"
+		case gbc.WatchRead | gbc.WatchWrite:
+			kind = "awatch"
+		}
+		if kind != "" {
+			return fmt.Sprintf("T%02x%s:%04x;", sigTrap, kind, ev.Addr)
+		}
+	}
+	return fmt.Sprintf("S%02x", sigTrap)
+}
+
+func (s *Server) stopped(ev gbc.StopEvent) {
+	s.running = false
+	s.send(stopReply(ev))
+}
+
+func (s *Server) handle(pkt string) {
+	if s.Verbose {
+		log.Printf("gdb -> %s\n", pkt)
+	}
+	if pkt == "" {
+		s.send("")
+		return
+	}
+	cmd, args := pkt[0], pkt[1:]
+	switch cmd {
+	case '?':
+		s.send(fmt.Sprintf("S%02x", sigTrap))
+	case 'g':
+		s.send(s.readRegisters())
+	case 'G':
+		s.send(s.writeRegisters(args))
+	case 'p':
+		s.send(s.readRegister(args))
+	case 'P':
+		s.send(s.writeRegister(args))
+	case 'm':
+		s.send(s.readMemory(args))
+	case 'M':
+		s.send(s.writeMemory(args))
+	case 'Z', 'z':
+		s.send(s.setPoint(args, cmd == 'Z'))
+	case 'c', 's':
+		if args != "" {
+			addr, err := strconv.ParseUint(args, 16, 32)
+			if err != nil {
+				s.send("E01")
+				return
+			}
+			s.dbg.Console.CPU.PC = uint16(addr)
+		}
+		if cmd == 's' {
+			s.stopped(s.dbg.Step())
+			return
+		}
+		s.running = true
+	case 'H':
+		s.send("OK")
+	case 'D':
+		s.send("OK")
+		s.Close()
+	case 'k':
+		s.Close()
+	case 'q', 'Q':
+		s.send(s.query(pkt))
+	default:
+		// Unsupported, e.g., vCont and X
+		s.send("")
+	}
+}
+
+func (s *Server) query(pkt string) string {
+	switch {
+	case strings.HasPrefix(pkt, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+"
+	case pkt == "QStartNoAckMode":
+		s.noAck.Store(true)
+		return "OK"
+	case pkt == "qAttached":
+		return "1"
+	case pkt == "qC":
+		return "QC1"
+	case pkt == "qfThreadInfo":
+		return "m1"
+	case pkt == "qsThreadInfo":
+		return "l"
+	case strings.HasPrefix(pkt, "qXfer:features:read:target.xml:"):
+		var off, length int
+		arg := strings.TrimPrefix(pkt, "qXfer:features:read:target.xml:")
+		if _, err := fmt.Sscanf(arg, "%x,%x", &off, &length); err != nil {
+			return "E01"
+		}
+		if off >= len(targetXML) {
+			return "l"
+		}
+		if off+length >= len(targetXML) {
+			return "l" + targetXML[off:]
+		}
+		return "m" + targetXML[off:off+length]
+	}
+	return ""
+}
+
+func (s *Server) getRegister(n int) uint16 {
+	cpu := s.dbg.Console.CPU
+	switch n {
+	case 0:
+		return uint16(cpu.A)<<8 | uint16(cpu.PackFlags())
+	case 1:
+		return uint16(cpu.B)<<8 | uint16(cpu.C)
+	case 2:
+		return uint16(cpu.D)<<8 | uint16(cpu.E)
+	case 3:
+		return uint16(cpu.H)<<8 | uint16(cpu.L)
+	case 4:
+		return cpu.SP
+	}
+	return cpu.PC
+}
+
+func (s *Server) setRegister(n int, value uint16) {
+	cpu := s.dbg.Console.CPU
+	high, low := uint8(value>>8), uint8(value)
+	switch n {
+	case 0:
+		cpu.A = high
+		cpu.UnpackFlags(low)
+	case 1:
+		cpu.B, cpu.C = high, low
+	case 2:
+		cpu.D, cpu.E = high, low
+	case 3:
+		cpu.H, cpu.L = high, low
+	case 4:
+		cpu.SP = value
+	case 5:
+		cpu.PC = value
+	}
+}
+
+// Registers are sent in target byte order, little endian
+func encodeRegister(value uint16) string {
+	return fmt.Sprintf("%02x%02x", uint8(value), uint8(value>>8))
+}
+
+func decodeRegister(data string) (uint16, bool) {
+	b, err := hex.DecodeString(data)
+	if err != nil || len(b) != 2 {
+		return 0, false
+	}
+	return uint16(b[1])<<8 | uint16(b[0]), true
+}
+
+func (s *Server) readRegisters() string {
+	res := ""
+	for n := 0; n < numRegisters; n++ {
+		res += encodeRegister(s.getRegister(n))
+	}
+	return res
+}
+
+func (s *Server) writeRegisters(args string) string {
+	if len(args) != numRegisters*4 {
+		return "E01"
+	}
+	for n := 0; n < numRegisters; n++ {
+		value, ok := decodeRegister(args[n*4 : n*4+4])
+		if !ok {
+			return "E01"
+		}
+		s.setRegister(n, value)
+	}
+	return "OK"
+}
+
+func (s *Server) readRegister(args string) string {
+	n, err := strconv.ParseUint(args, 16, 8)
+	if err != nil || n >= numRegisters {
+		return "E01"
+	}
+	return encodeRegister(s.getRegister(int(n)))
+}
+
+func (s *Server) writeRegister(args string) string {
+	reg, data, found := strings.Cut(args, "=")
+	n, err := strconv.ParseUint(reg, 16, 8)
+	if !found || err != nil || n >= numRegisters {
+		return "E01"
+	}
+	value, ok := decodeRegister(data)
+	if !ok {
+		return "E01"
+	}
+	s.setRegister(int(n), value)
+	return "OK"
+}
+
+// The longest memory access, the whole address space
+const maxRangeLength = 0x10000
+
+// parseRange parses "addr,length"
+func parseRange(args string) (uint32, int, error) {
+	var addr uint32
+	var length int
+	if _, err := fmt.Sscanf(args, "%x,%x", &addr, &length); err != nil {
+		return 0, 0, err
+	}
+	if length < 0 || length > maxRangeLength {
+		return 0, 0, GDBError("Invalid length")
+	}
+	return addr, length, nil
+}
+
+func (s *Server) readMemory(args string) string {
+	addr, length, err := parseRange(args)
+	if err != nil {
+		return "E01"
+	}
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = s.dbg.Console.Read(uint16(addr) + uint16(i))
+	}
+	return hex.EncodeToString(data)
+}
+
+func (s *Server) writeMemory(args string) string {
+	rng, payload, found := strings.Cut(args, ":")
+	addr, length, err := parseRange(rng)
+	if !found || err != nil {
+		return "E01"
+	}
+	data, err := hex.DecodeString(payload)
+	if err != nil || len(data) != length {
+		return "E01"
+	}
+	for i, b := range data {
+		s.dbg.Console.Write(uint16(addr)+uint16(i), b)
+	}
+	return "OK"
+}
+
+// setPoint handles "type,addr,kind": software (0) and hardware (1)
+// breakpoints, write (2), read (3) and access (4) watchpoints
+func (s *Server) setPoint(args string, insert bool) string {
+	var typ int
+	var addr uint32
+	var length int
+	if _, err := fmt.Sscanf(args, "%x,%x,%x", &typ, &addr, &length); err != nil {
+		return "E01"
+	}
+	if !insert {
+		id, ok := s.points[args]
+		if !ok {
+			return "E01"
+		}
+		s.dbg.Remove(id)
+		delete(s.points, args)
+		return "OK"
+	}
+	if _, ok := s.points[args]; ok {
+		return "OK"
+	}
+
+	id := 0
+	switch typ {
+	case 0, 1:
+		bank := gbc.AnyBank
+		if addr > 0xFFFF {
+			bank = int(addr >> 16)
+		}
+		bp, err := s.dbg.AddBreakpoint(uint16(addr), bank, "")
+		if err != nil {
+			return "E01"
+		}
+		id = bp.ID
+	case 2, 3, 4:
+		kind := map[int]gbc.WatchKind{
+			2: gbc.WatchWrite,
+			3: gbc.WatchRead,
+			4: gbc.WatchRead | gbc.WatchWrite,
+		}[typ]
+		if length < 1 || int(addr)+length > 0x10000 {
+			return "E01"
+		}
+		wp, err := s.dbg.AddWatchpoint(uint16(addr), uint16(int(addr)+length-1), kind)
+		if err != nil {
+			return "E01"
+		}
+		id = wp.ID
+	default:
+		return ""
+	}
+	s.points[args] = id
+	return "OK"
+}
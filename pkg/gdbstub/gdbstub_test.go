@@ -0,0 +1,151 @@
+package gdbstub
+
+import (
+	"borzGBC/internal/gbctest"
+	"borzGBC/pkg/gbc"
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// A loopback client, speaking the protocol as GDB does
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *testClient) request(pkt string) string {
+	c.t.Helper()
+	fmt.Fprintf(c.conn, "$%s#%02x", pkt, checksum(pkt))
+	if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+		c.t.Fatalf("missing ack for %s", pkt)
+	}
+	return c.reply()
+}
+
+func (c *testClient) reply() string {
+	c.t.Helper()
+	if b, err := c.r.ReadByte(); err != nil || b != '$' {
+		c.t.Fatalf("invalid reply")
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatalf("invalid reply: %s", err)
+	}
+	data = data[:len(data)-1]
+	sum := make([]byte, 2)
+	c.r.Read(sum)
+	if fmt.Sprintf("%02x", checksum(data)) != string(sum) {
+		c.t.Fatalf("invalid checksum for %s", data)
+	}
+	c.conn.Write([]byte("+"))
+	return data
+}
+
+func (c *testClient) expect(pkt, exp string) {
+	c.t.Helper()
+	if res := c.request(pkt); res != exp {
+		c.t.Errorf("%s: %s (exp: %s)", pkt, res, exp)
+	}
+}
+
+// startServer runs a program writing to 0xC000 and locking the CPU
+func startServer(t *testing.T) (*testClient, *gbc.Debugger, chan error) {
+	rom := gbctest.MakeROM(0x00, 2, []byte{
+		0x3e, 0x42, //     ld a, 0x42
+		0xea, 0x00, 0xc0, // ld (0xc000), a
+		0x3c, //           inc a
+		0xd3, //           illegal
+	})
+	cons, err := gbctest.MakeConsole(rom)
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+	dbg := gbc.MakeDebugger(cons)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	done := make(chan error)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- MakeServer(conn, dbg).Run()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}, dbg, done
+}
+
+func TestSession(t *testing.T) {
+	c, dbg, done := startServer(t)
+	defer c.conn.Close()
+
+	c.expect("?", "S05")
+	c.expect("g", "0000000000000000feff0001")
+	c.expect("P1=3412", "OK")
+	c.expect("p1", "3412")
+	c.expect("m104,4", "ceed6666")
+	c.expect("Mc100,2:abcd", "OK")
+	c.expect("mc100,2", "abcd")
+	c.expect("m0,-1", "E01")
+	c.expect("m0,10001", "E01")
+	c.expect("Mc100,-1:", "E01")
+
+	c.expect("s", "S05")
+	c.expect("p5", "0101")
+	c.expect("Z0,155,1", "OK")
+	c.expect("c", "S05")
+	c.expect("p5", "5501")
+	c.expect("p0", "0042")
+	c.expect("z0,155,1", "OK")
+	c.expect("z0,155,1", "E01")
+
+	// Resume from the start with a watchpoint
+	c.expect("P5=0001", "OK")
+	c.expect("Z2,c000,1", "OK")
+	c.expect("c", "T05watch:c000;")
+	c.expect("p5", "5501")
+	c.expect("c", "S04")
+
+	if cpu := dbg.Console.CPU; cpu.B != 0x12 || cpu.C != 0x34 || cpu.A != 0x43 {
+		t.Errorf("unexpected registers: B=%02x C=%02x A=%02x", cpu.B, cpu.C, cpu.A)
+	}
+
+	c.expect("D", "OK")
+	if err := <-done; err != nil {
+		t.Errorf("server error: %s", err)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	c, _, done := startServer(t)
+	defer c.conn.Close()
+
+	// An endless loop
+	c.expect("Mc000,2:18fe", "OK")
+	c.expect("P5=00c0", "OK")
+	fmt.Fprintf(c.conn, "$c#%02x", checksum("c"))
+	c.r.ReadByte()
+	c.conn.Write([]byte{0x03})
+	if res := c.reply(); res != "S02" {
+		t.Errorf("interrupt: %s (exp: S02)", res)
+	}
+	c.expect("p5", "00c0")
+
+	c.request("QStartNoAckMode")
+	fmt.Fprintf(c.conn, "$k#%02x", checksum("k"))
+	if err := <-done; err != nil {
+		t.Errorf("server error: %s", err)
+	}
+}
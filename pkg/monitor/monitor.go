@@ -0,0 +1,636 @@
+package monitor
+
+import (
+	"borzGBC/pkg/gbc"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Monitor is a command line debugger on top of gbc.Debugger. Addresses are
+// hexadecimal, optionally qualified by a bank ("03:4567"), or expressions
+// (e.g., "hl+2"). Counts and values are expressions, decimal by default
+
+type MonitorError string
+
+func (err MonitorError) Error() string {
+	return string(err)
+}
+
+type Monitor struct {
+	Debugger *gbc.Debugger
+	Console  *gbc.Console
+
+	// When set, "continue" ends Run instead of running the console, for
+	// frontends that keep running it with Debugger.RunFrame
+	ResumeOnContinue bool
+
+	in   *bufio.Scanner
+	out  io.Writer
+	last string
+	quit bool
+	cont bool
+}
+
+func MakeMonitor(dbg *gbc.Debugger, in io.Reader, out io.Writer) *Monitor {
+	return &Monitor{
+		Debugger: dbg,
+		Console:  dbg.Console,
+		in:       bufio.NewScanner(in),
+		out:      out,
+	}
+}
+
+type command struct {
+	names []string
+	args  string
+	help  string
+	run   func(m *Monitor, args []string) error
+}
+
+var commands = []command{
+	{[]string{"regs", "r"}, "", "show the CPU registers", (*Monitor).cmdRegs},
+	{[]string{"x"}, "addr [count]", "hexdump the memory", (*Monitor).cmdHexdump},
+	{[]string{"d"}, "[addr] [count]", "disassemble, by default from PC", (*Monitor).cmdDisas},
+	{[]string{"write"}, "addr value...", "write bytes to the memory", (*Monitor).cmdWrite},
+	{[]string{"set"}, "reg value", "set a register", (*Monitor).cmdSet},
+	{[]string{"p"}, "expr", "evaluate an expression", (*Monitor).cmdPrint},
+	{[]string{"b"}, "addr [if cond]", "add a breakpoint", (*Monitor).cmdBreak},
+	{[]string{"w"}, "addr[-end] [r|w|x]", "add a watchpoint (default: w)", (*Monitor).cmdWatch},
+	{[]string{"l"}, "", "list the breakpoints and the watchpoints", (*Monitor).cmdList},
+	{[]string{"del"}, "id", "delete a breakpoint or a watchpoint", (*Monitor).cmdDelete},
+	{[]string{"en"}, "id", "enable a breakpoint or a watchpoint", (*Monitor).cmdEnable},
+	{[]string{"dis"}, "id", "disable a breakpoint or a watchpoint", (*Monitor).cmdDisable},
+	{[]string{"s"}, "[count]", "step into", (*Monitor).cmdStep},
+	{[]string{"n"}, "", "step over", (*Monitor).cmdNext},
+	{[]string{"fin"}, "", "step out of the current function", (*Monitor).cmdFinish},
+	{[]string{"c"}, "", "continue until a breakpoint", (*Monitor).cmdContinue},
+	{[]string{"frame"}, "[count]", "run frames, stopping at the breakpoints", (*Monitor).cmdFrame},
+	{[]string{"ppu"}, "", "show the PPU registers", (*Monitor).cmdPPU},
+	{[]string{"apu"}, "", "show the APU registers", (*Monitor).cmdAPU},
+	{[]string{"timer"}, "", "show the timer and interrupt registers", (*Monitor).cmdTimer},
+	{[]string{"bgmap"}, "", "dump the current background tile map (tile:attributes)", (*Monitor).cmdBgMap},
+	{[]string{"q"}, "", "quit the monitor", (*Monitor).cmdQuit},
+}
+
+func (m *Monitor) printf(format string, args ...interface{}) {
+	fmt.Fprintf(m.out, format, args...)
+}
+
+func (m *Monitor) help() {
+	m.printf("Commands (an empty line repeats the last one):\n")
+	for _, cmd := range commands {
+		m.printf("  %-28s %s\n", strings.Join(cmd.names, "|")+" "+cmd.args, cmd.help)
+	}
+}
+
+// Run reads and executes the commands until quit, or the end of the input.
+// With ResumeOnContinue, continue ends Run too: in that case, it returns true
+func (m *Monitor) Run() bool {
+	m.quit, m.cont = false, false
+	m.showPC()
+	for !m.quit && !m.cont {
+		m.printf("> ")
+		if !m.in.Scan() {
+			m.printf("\n")
+			return false
+		}
+		m.Exec(m.in.Text())
+	}
+	return m.cont
+}
+
+// Exec runs a command, printing its output or its error
+func (m *Monitor) Exec(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		line = m.last
+	}
+	if line == "" {
+		return
+	}
+	m.last = line
+
+	fields := strings.Fields(line)
+	name, args := fields[0], fields[1:]
+	if name == "help" || name == "h" || name == "?" {
+		m.help()
+		return
+	}
+	for _, cmd := range commands {
+		for _, cmdName := range cmd.names {
+			if cmdName != name {
+				continue
+			}
+			if err := cmd.run(m, args); err != nil {
+				m.printf("error: %s\n", err)
+			}
+			return
+		}
+	}
+	m.printf("unknown command \"%s\", try help\n", name)
+}
+
+func (m *Monitor) parseValue(s string) (int, error) {
+	expr, err := gbc.ParseExprWithSymbols(s, m.Console.Symbols)
+	if err != nil {
+		return 0, err
+	}
+	return expr.Eval(m.Console), nil
+}
+
+var registerNames = []string{
+	"a", "f", "b", "c", "d", "e", "h", "l", "af", "bc", "de", "hl", "sp", "pc",
+}
+
+func isRegister(s string) bool {
+	for _, reg := range registerNames {
+		if strings.EqualFold(s, reg) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddress parses [bank:]addr or a label, the bank is gbc.AnyBank if
+// missing
+func (m *Monitor) parseAddress(s string) (int, uint16, error) {
+	if m.Console.Symbols != nil && !isRegister(s) {
+		if sym, ok := m.Console.Symbols.Lookup(s); ok {
+			return sym.Bank, sym.Addr, nil
+		}
+	}
+	bank := gbc.AnyBank
+	if b, addr, found := strings.Cut(s, ":"); found {
+		v, err := strconv.ParseUint(b, 16, 16)
+		if err != nil {
+			return 0, 0, MonitorError(fmt.Sprintf("Invalid bank \"%s\"", b))
+		}
+		bank, s = int(v), addr
+	}
+	if v, err := strconv.ParseUint(s, 16, 16); err == nil && !isRegister(s) {
+		return bank, uint16(v), nil
+	}
+	v, err := m.parseValue(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	return bank, uint16(v), nil
+}
+
+// parseCount parses the optional count at args[i]
+func (m *Monitor) parseCount(args []string, i, def int) (int, error) {
+	if len(args) <= i {
+		return def, nil
+	}
+	n, err := m.parseValue(args[i])
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, MonitorError("The count must be positive")
+	}
+	return n, nil
+}
+
+func (m *Monitor) read(bank int, addr uint16) uint8 {
+	return m.Console.ReadBanked(bank, addr)
+}
+
+// bankOf returns the bank to display for addr
+func (m *Monitor) bankOf(bank int, addr uint16) int {
+	if bank == gbc.AnyBank {
+		return m.Console.Bank(addr)
+	}
+	return bank
+}
+
+// disassemble formats the instruction at addr and returns its length
+func (m *Monitor) disassemble(bank int, addr uint16) (int, string) {
+	n, instr := m.Console.DisassembleBanked(bank, addr)
+	if n == 0 {
+		n = 1
+	}
+	return n, fmt.Sprintf("%02x:%s", m.bankOf(bank, addr), instr)
+}
+
+func (m *Monitor) showPC() {
+	pc := m.Console.CPU.PC
+	_, instr := m.disassemble(gbc.AnyBank, pc)
+	if label := m.Console.DescribeAddr(pc); label != "" {
+		instr = fmt.Sprintf("%s ; %s", instr, label)
+	}
+	m.printf("=> %s\n", instr)
+}
+
+// PrintStop reports why the console stopped, for frontends entering the
+// monitor after Debugger.RunFrame
+func (m *Monitor) PrintStop(ev gbc.StopEvent) {
+	if ev.Reason != gbc.StopStep {
+		m.printf("stopped: %s\n", ev)
+	}
+}
+
+func (m *Monitor) showStop(ev gbc.StopEvent) {
+	m.PrintStop(ev)
+	m.showPC()
+}
+
+func (m *Monitor) cmdRegs(args []string) error {
+	cpu := m.Console.CPU
+	pc := cpu.PC
+	m.printf("AF=%02x%02x BC=%02x%02x DE=%02x%02x HL=%02x%02x SP=%04x PC=%04x\n",
+		cpu.A, cpu.PackFlags(), cpu.B, cpu.C, cpu.D, cpu.E, cpu.H, cpu.L, cpu.SP, pc)
+
+	f := cpu.PackFlags()
+	flag := func(mask uint8, name string) string {
+		if f&mask != 0 {
+			return name
+		}
+		return "-"
+	}
+	state := "running"
+	switch {
+	case cpu.IsLocked:
+		state = "locked"
+	case cpu.IsStopped:
+		state = "stopped"
+	case cpu.IsHalted:
+		state = "halted"
+	}
+	ime := 0
+	if cpu.InterruptsEnabled() {
+		ime = 1
+	}
+	m.printf("F=%s%s%s%s IME=%d IE=%02x IF=%02x ROM bank=%02x CPU %s\n",
+		flag(0x80, "Z"), flag(0x40, "N"), flag(0x20, "H"), flag(0x10, "C"),
+		ime, cpu.IE, cpu.IF, m.Console.Bank(0x4000), state)
+	m.showPC()
+	return nil
+}
+
+func (m *Monitor) cmdHexdump(args []string) error {
+	if len(args) < 1 {
+		return MonitorError("Missing address")
+	}
+	bank, addr, err := m.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	n, err := m.parseCount(args, 1, 128)
+	if err != nil {
+		return err
+	}
+	for off := 0; off < n; off += 16 {
+		line := addr + uint16(off)
+		hex, ascii := "", ""
+		for i := 0; i < 16 && off+i < n; i++ {
+			v := m.read(bank, line+uint16(i))
+			hex += fmt.Sprintf("%02x ", v)
+			if v >= 0x20 && v < 0x7F {
+				ascii += string(rune(v))
+			} else {
+				ascii += "."
+			}
+		}
+		m.printf("%02x:%04x  %-48s |%s|\n", m.bankOf(bank, line), line, hex, ascii)
+	}
+	return nil
+}
+
+func (m *Monitor) cmdDisas(args []string) error {
+	bank, addr := gbc.AnyBank, m.Console.CPU.PC
+	if len(args) > 0 {
+		var err error
+		bank, addr, err = m.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+	}
+	n, err := m.parseCount(args, 1, 10)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if m.Console.Symbols != nil {
+			if label, ok := m.Console.Symbols.Label(m.bankOf(bank, addr), addr); ok {
+				m.printf("%s:\n", label)
+			}
+		}
+		size, instr := m.disassemble(bank, addr)
+		marker := "  "
+		if addr == m.Console.CPU.PC && bank == gbc.AnyBank {
+			marker = "=>"
+		}
+		m.printf("%s %s\n", marker, instr)
+		addr += uint16(size)
+	}
+	return nil
+}
+
+func (m *Monitor) cmdWrite(args []string) error {
+	if len(args) < 2 {
+		return MonitorError("Missing address or value")
+	}
+	bank, addr, err := m.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	if bank != gbc.AnyBank {
+		return MonitorError("Writes use the current banks")
+	}
+	for i, arg := range args[1:] {
+		v, err := m.parseValue(arg)
+		if err != nil {
+			return err
+		}
+		m.Console.Write(addr+uint16(i), uint8(v))
+	}
+	return nil
+}
+
+func (m *Monitor) cmdSet(args []string) error {
+	if len(args) != 2 {
+		return MonitorError("Usage: set reg value")
+	}
+	v, err := m.parseValue(args[1])
+	if err != nil {
+		return err
+	}
+	cpu := m.Console.CPU
+	high, low := uint8(v>>8), uint8(v)
+	switch strings.ToLower(args[0]) {
+	case "a":
+		cpu.A = low
+	case "f":
+		cpu.UnpackFlags(low)
+	case "b":
+		cpu.B = low
+	case "c":
+		cpu.C = low
+	case "d":
+		cpu.D = low
+	case "e":
+		cpu.E = low
+	case "h":
+		cpu.H = low
+	case "l":
+		cpu.L = low
+	case "af":
+		cpu.A = high
+		cpu.UnpackFlags(low)
+	case "bc":
+		cpu.B, cpu.C = high, low
+	case "de":
+		cpu.D, cpu.E = high, low
+	case "hl":
+		cpu.H, cpu.L = high, low
+	case "sp":
+		cpu.SP = uint16(v)
+	case "pc":
+		cpu.PC = uint16(v)
+	default:
+		return MonitorError(fmt.Sprintf("Unknown register \"%s\"", args[0]))
+	}
+	return nil
+}
+
+func (m *Monitor) cmdPrint(args []string) error {
+	v, err := m.parseValue(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	m.printf("%d (0x%x)\n", v, v)
+	return nil
+}
+
+func (m *Monitor) cmdBreak(args []string) error {
+	if len(args) < 1 {
+		return MonitorError("Missing address")
+	}
+	bank, addr, err := m.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	cond := ""
+	if len(args) > 1 {
+		if args[1] != "if" || len(args) < 3 {
+			return MonitorError("Usage: b addr [if cond]")
+		}
+		cond = strings.Join(args[2:], " ")
+	}
+	bp, err := m.Debugger.AddBreakpoint(addr, bank, cond)
+	if err != nil {
+		return err
+	}
+	m.printf("breakpoint %s\n", bp)
+	return nil
+}
+
+func (m *Monitor) cmdWatch(args []string) error {
+	if len(args) < 1 {
+		return MonitorError("Missing address")
+	}
+	start, end, found := strings.Cut(args[0], "-")
+	_, startAddr, err := m.parseAddress(start)
+	if err != nil {
+		return err
+	}
+	endAddr := startAddr
+	if found {
+		if _, endAddr, err = m.parseAddress(end); err != nil {
+			return err
+		}
+	}
+	kind := gbc.WatchWrite
+	if len(args) > 1 {
+		kind = 0
+		for _, c := range args[1] {
+			switch c {
+			case 'r':
+				kind |= gbc.WatchRead
+			case 'w':
+				kind |= gbc.WatchWrite
+			case 'x':
+				kind |= gbc.WatchExec
+			default:
+				return MonitorError(fmt.Sprintf("Invalid access kind \"%c\"", c))
+			}
+		}
+	}
+	wp, err := m.Debugger.AddWatchpoint(startAddr, endAddr, kind)
+	if err != nil {
+		return err
+	}
+	m.printf("watchpoint %s\n", wp)
+	return nil
+}
+
+func (m *Monitor) cmdList(args []string) error {
+	for _, bp := range m.Debugger.Breakpoints() {
+		m.printf("breakpoint %s, hits: %d\n", bp, bp.Hits)
+	}
+	for _, wp := range m.Debugger.Watchpoints() {
+		m.printf("watchpoint %s, hits: %d\n", wp, wp.Hits)
+	}
+	return nil
+}
+
+func (m *Monitor) parseID(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, MonitorError("Missing id")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, MonitorError(fmt.Sprintf("Invalid id \"%s\"", args[0]))
+	}
+	return id, nil
+}
+
+func (m *Monitor) cmdDelete(args []string) error {
+	id, err := m.parseID(args)
+	if err != nil {
+		return err
+	}
+	if !m.Debugger.Remove(id) {
+		return MonitorError(fmt.Sprintf("No breakpoint or watchpoint %d", id))
+	}
+	return nil
+}
+
+func (m *Monitor) setEnabled(args []string, enabled bool) error {
+	id, err := m.parseID(args)
+	if err != nil {
+		return err
+	}
+	if !m.Debugger.SetEnabled(id, enabled) {
+		return MonitorError(fmt.Sprintf("No breakpoint or watchpoint %d", id))
+	}
+	return nil
+}
+
+func (m *Monitor) cmdEnable(args []string) error {
+	return m.setEnabled(args, true)
+}
+
+func (m *Monitor) cmdDisable(args []string) error {
+	return m.setEnabled(args, false)
+}
+
+func (m *Monitor) cmdStep(args []string) error {
+	n, err := m.parseCount(args, 0, 1)
+	if err != nil {
+		return err
+	}
+	ev := gbc.StopEvent{}
+	for i := 0; i < n; i++ {
+		ev = m.Debugger.Step()
+		if ev.Reason != gbc.StopStep {
+			break
+		}
+	}
+	m.showStop(ev)
+	return nil
+}
+
+func (m *Monitor) cmdNext(args []string) error {
+	m.showStop(m.Debugger.StepOver())
+	return nil
+}
+
+func (m *Monitor) cmdFinish(args []string) error {
+	m.showStop(m.Debugger.StepOut())
+	return nil
+}
+
+func (m *Monitor) cmdContinue(args []string) error {
+	if m.ResumeOnContinue {
+		m.cont = true
+		return nil
+	}
+	m.showStop(m.Debugger.RunUntilBreak(0))
+	return nil
+}
+
+func (m *Monitor) cmdFrame(args []string) error {
+	n, err := m.parseCount(args, 0, 1)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		ev := m.Debugger.RunFrame()
+		if ev.Reason != gbc.StopFrame || i == n-1 {
+			m.showStop(ev)
+			break
+		}
+	}
+	return nil
+}
+
+type ioRegister struct {
+	addr uint16
+	name string
+}
+
+func (m *Monitor) dumpRegisters(regs []ioRegister) {
+	for i, reg := range regs {
+		m.printf("%-5s(%04x)=%02x", reg.name, reg.addr, m.Console.Read(reg.addr))
+		if i%4 == 3 || i == len(regs)-1 {
+			m.printf("\n")
+		} else {
+			m.printf("  ")
+		}
+	}
+}
+
+func (m *Monitor) cmdPPU(args []string) error {
+	m.dumpRegisters([]ioRegister{
+		{0xFF40, "LCDC"}, {0xFF41, "STAT"}, {0xFF42, "SCY"}, {0xFF43, "SCX"},
+		{0xFF44, "LY"}, {0xFF45, "LYC"}, {0xFF4A, "WY"}, {0xFF4B, "WX"},
+		{0xFF47, "BGP"}, {0xFF48, "OBP0"}, {0xFF49, "OBP1"}, {0xFF4F, "VBK"},
+		{0xFF68, "BCPS"}, {0xFF6A, "OCPS"}, {0xFF55, "HDMA5"}, {0xFF70, "SVBK"},
+	})
+	ppu := m.Console.PPU
+	m.printf("frame=%d cycle=%d\n", ppu.FrameCount, ppu.CycleCount)
+	return nil
+}
+
+func (m *Monitor) cmdAPU(args []string) error {
+	regs := make([]ioRegister, 0)
+	for i, name := range []string{
+		"NR10", "NR11", "NR12", "NR13", "NR14", "", "NR21", "NR22", "NR23", "NR24",
+		"NR30", "NR31", "NR32", "NR33", "NR34", "", "NR41", "NR42", "NR43", "NR44",
+		"NR50", "NR51", "NR52",
+	} {
+		if name != "" {
+			regs = append(regs, ioRegister{0xFF10 + uint16(i), name})
+		}
+	}
+	m.dumpRegisters(regs)
+	wave := ""
+	for addr := uint16(0xFF30); addr <= 0xFF3F; addr++ {
+		wave += fmt.Sprintf("%02x", m.Console.Read(addr))
+	}
+	m.printf("wave(ff30)=%s\n", wave)
+	return nil
+}
+
+func (m *Monitor) cmdTimer(args []string) error {
+	m.dumpRegisters([]ioRegister{
+		{0xFF04, "DIV"}, {0xFF05, "TIMA"}, {0xFF06, "TMA"}, {0xFF07, "TAC"},
+		{0xFF0F, "IF"}, {0xFFFF, "IE"}, {0xFF4D, "KEY1"},
+	})
+	return nil
+}
+
+func (m *Monitor) cmdBgMap(args []string) error {
+	m.printf("%s", m.Console.GetBackgroundMapStr())
+	return nil
+}
+
+func (m *Monitor) cmdQuit(args []string) error {
+	m.quit = true
+	return nil
+}
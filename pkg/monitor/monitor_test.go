@@ -0,0 +1,69 @@
+package monitor
+
+import (
+	"borzGBC/internal/gbctest"
+	"borzGBC/pkg/gbc"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// runScript runs the monitor commands on a MBC1 cart that calls a function
+// in bank 2
+func runScript(t *testing.T, script string) string {
+	rom := gbctest.MakeROM(0x01, 4, []byte{ // MBC1
+		0x3e, 0x02, //       ld a, 2
+		0xea, 0x00, 0x20, // ld (0x2000), a
+		0xcd, 0x00, 0x40, // call 0x4000
+		0x18, 0xfe, //       jr -2
+	})
+	copy(rom[2*0x4000:], []byte{
+		0x21, 0x00, 0xc0, // ld hl, 0xc000
+		0x36, 0x42, //       ld (hl), 0x42
+		0xc9, //             ret
+	})
+	cons, err := gbctest.MakeConsole(rom)
+	if err != nil {
+		t.Fatalf("unable to create the console: %s", err)
+	}
+
+	out := &bytes.Buffer{}
+	m := MakeMonitor(gbc.MakeDebugger(cons), strings.NewReader(script), out)
+	m.Run()
+	return out.String()
+}
+
+func TestMonitor(t *testing.T) {
+	out := runScript(t, strings.Join([]string{
+		"b 02:4000",
+		"w c000",
+		"c",
+		"d pc 2",
+		"x 02:4000 6",
+		"c",
+		"p [hl] + 1",
+		"fin",
+		"s",
+		"",
+		"r",
+		"foo",
+	}, "\n"))
+
+	for _, exp := range []string{
+		"breakpoint 1: 02:4000",
+		"watchpoint 2: c000-c000 w",
+		"stopped: breakpoint 1 at 02:4000",
+		"=> 02:4000: 21 00 c0    LD HL,$c000",
+		"   02:4003: 36 42       LD (HL),$42",
+		"02:4000  21 00 c0 36 42 c9",
+		"stopped: watchpoint at 02:4005, watchpoint 2 (w c000 = 42)",
+		"67 (0x43)",
+		"=> 00:0158: 18 fe       JR $fe",
+		"AF=",
+		"unknown command \"foo\"",
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("missing \"%s\" in the output:\n%s", exp, out)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Supported formats:
+//   - IPS: with the RLE records and the truncation extension
+//   - UPS: source and target CRC32 are verified
+//   - BPS: source and target CRC32 are verified
+
+type PatchError string
+
+func (err PatchError) Error() string {
+	return string(err)
+}
+
+var (
+	ipsMagic = []byte("PATCH")
+	ipsEOF   = []byte("EOF")
+	upsMagic = []byte("UPS1")
+	bpsMagic = []byte("BPS1")
+)
+
+// Apply detects the format of the patch and applies it to rom. The
+// original data is not modified
+func Apply(rom, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return ApplyIPS(rom, patch)
+	case bytes.HasPrefix(patch, upsMagic):
+		return ApplyUPS(rom, patch)
+	case bytes.HasPrefix(patch, bpsMagic):
+		return ApplyBPS(rom, patch)
+	}
+	return nil, PatchError("Unknown patch format")
+}
+
+func ApplyIPS(rom, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, ipsMagic) {
+		return nil, PatchError("Invalid IPS magic")
+	}
+	target := make([]byte, len(rom))
+	copy(target, rom)
+
+	// grow the target if a record writes past its end
+	write := func(off int, data []byte) {
+		if off+len(data) > len(target) {
+			target = append(target, make([]byte, off+len(data)-len(target))...)
+		}
+		copy(target[off:], data)
+	}
+
+	off := len(ipsMagic)
+	for {
+		if off+3 > len(patch) {
+			return nil, PatchError("Truncated IPS patch")
+		}
+		if bytes.Equal(patch[off:off+3], ipsEOF) {
+			off += 3
+			break
+		}
+		if off+5 > len(patch) {
+			return nil, PatchError("Truncated IPS record")
+		}
+		recordOff := int(patch[off])<<16 | int(patch[off+1])<<8 | int(patch[off+2])
+		size := int(binary.BigEndian.Uint16(patch[off+3:]))
+		off += 5
+
+		if size == 0 {
+			// RLE record
+			if off+3 > len(patch) {
+				return nil, PatchError("Truncated IPS RLE record")
+			}
+			count := int(binary.BigEndian.Uint16(patch[off:]))
+			value := patch[off+2]
+			off += 3
+			write(recordOff, bytes.Repeat([]byte{value}, count))
+			continue
+		}
+
+		if off+size > len(patch) {
+			return nil, PatchError("Truncated IPS record")
+		}
+		write(recordOff, patch[off:off+size])
+		off += size
+	}
+
+	// Truncation extension
+	if off+3 == len(patch) {
+		size := int(patch[off])<<16 | int(patch[off+1])<<8 | int(patch[off+2])
+		if size < len(target) {
+			target = target[:size]
+		}
+	} else if off != len(patch) {
+		return nil, PatchError("Unexpected data after the IPS EOF marker")
+	}
+	return target, nil
+}
+
+// Variable length integers used by UPS and BPS
+type reader struct {
+	data []byte
+	off  int
+	end  int // the footer is not part of the stream
+}
+
+func (r *reader) readByte() (byte, error) {
+	if r.off >= r.end {
+		return 0, PatchError("Truncated patch")
+	}
+	b := r.data[r.off]
+	r.off += 1
+	return b, nil
+}
+
+func (r *reader) readNumber() (int, error) {
+	data, shift := 0, 1
+	for {
+		x, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		data += int(x&0x7F) * shift
+		if x&0x80 != 0 {
+			break
+		}
+		shift <<= 7
+		data += shift
+		if shift > 1<<42 {
+			return 0, PatchError("Invalid number in patch")
+		}
+	}
+	return data, nil
+}
+
+// UPS and BPS end with the CRC32 of the source, of the target and of the
+// patch itself
+type footer struct {
+	source, target, patch uint32
+}
+
+func readFooter(patch []byte, magic []byte) (*footer, error) {
+	if len(patch) < len(magic)+12 {
+		return nil, PatchError("Truncated patch")
+	}
+	f := patch[len(patch)-12:]
+	res := &footer{
+		source: binary.LittleEndian.Uint32(f[0:]),
+		target: binary.LittleEndian.Uint32(f[4:]),
+		patch:  binary.LittleEndian.Uint32(f[8:]),
+	}
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != res.patch {
+		return nil, PatchError("Patch checksum mismatch")
+	}
+	return res, nil
+}
+
+func ApplyUPS(rom, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, upsMagic) {
+		return nil, PatchError("Invalid UPS magic")
+	}
+	f, err := readFooter(patch, upsMagic)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(rom) != f.source {
+		return nil, PatchError("Source checksum mismatch (the patch is for another ROM)")
+	}
+
+	r := &reader{data: patch, off: len(upsMagic), end: len(patch) - 12}
+	sourceSize, err := r.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	targetSize, err := r.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	if sourceSize != len(rom) {
+		return nil, PatchError("Source size mismatch")
+	}
+
+	target := make([]byte, targetSize)
+	copy(target, rom)
+	pos := 0
+	for r.off < r.end {
+		skip, err := r.readNumber()
+		if err != nil {
+			return nil, err
+		}
+		pos += skip
+		for {
+			x, err := r.readByte()
+			if err != nil {
+				return nil, err
+			}
+			if x == 0 {
+				pos += 1
+				break
+			}
+			if pos < len(target) {
+				target[pos] ^= x
+			}
+			pos += 1
+		}
+	}
+
+	if crc32.ChecksumIEEE(target) != f.target {
+		return nil, PatchError("Target checksum mismatch")
+	}
+	return target, nil
+}
+
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+func ApplyBPS(rom, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, bpsMagic) {
+		return nil, PatchError("Invalid BPS magic")
+	}
+	f, err := readFooter(patch, bpsMagic)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(rom) != f.source {
+		return nil, PatchError("Source checksum mismatch (the patch is for another ROM)")
+	}
+
+	r := &reader{data: patch, off: len(bpsMagic), end: len(patch) - 12}
+	sourceSize, err := r.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	targetSize, err := r.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	metadataSize, err := r.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	if sourceSize != len(rom) {
+		return nil, PatchError("Source size mismatch")
+	}
+	if metadataSize > r.end-r.off {
+		return nil, PatchError("Truncated patch")
+	}
+	r.off += metadataSize
+
+	target := make([]byte, targetSize)
+	outOff, sourceRel, targetRel := 0, 0, 0
+	for r.off < r.end {
+		data, err := r.readNumber()
+		if err != nil {
+			return nil, err
+		}
+		command := data & 3
+		length := (data >> 2) + 1
+		if outOff+length > len(target) {
+			return nil, PatchError("BPS action writes past the end of the target")
+		}
+
+		switch command {
+		case bpsSourceRead:
+			if outOff+length > len(rom) {
+				return nil, PatchError("BPS action reads past the end of the source")
+			}
+			copy(target[outOff:], rom[outOff:outOff+length])
+		case bpsTargetRead:
+			if r.off+length > r.end {
+				return nil, PatchError("Truncated patch")
+			}
+			copy(target[outOff:], patch[r.off:r.off+length])
+			r.off += length
+		case bpsSourceCopy, bpsTargetCopy:
+			d, err := r.readNumber()
+			if err != nil {
+				return nil, err
+			}
+			delta := d >> 1
+			if d&1 != 0 {
+				delta = -delta
+			}
+			if command == bpsSourceCopy {
+				sourceRel += delta
+				if sourceRel < 0 || sourceRel+length > len(rom) {
+					return nil, PatchError("BPS action reads past the end of the source")
+				}
+				copy(target[outOff:], rom[sourceRel:sourceRel+length])
+				sourceRel += length
+			} else {
+				targetRel += delta
+				if targetRel < 0 || targetRel >= outOff {
+					return nil, PatchError("BPS action reads past the end of the target")
+				}
+				// byte by byte, the areas can overlap
+				for i := 0; i < length; i++ {
+					target[outOff+i] = target[targetRel]
+					targetRel += 1
+				}
+			}
+		}
+		outOff += length
+	}
+
+	if crc32.ChecksumIEEE(target) != f.target {
+		return nil, PatchError("Target checksum mismatch")
+	}
+	return target, nil
+}
@@ -0,0 +1,146 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func encodeNumber(n int) []byte {
+	res := make([]byte, 0)
+	for {
+		x := byte(n & 0x7F)
+		n >>= 7
+		if n == 0 {
+			return append(res, 0x80|x)
+		}
+		res = append(res, x)
+		n -= 1
+	}
+}
+
+func appendFooter(patch, source, target []byte) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(source))
+	patch = append(patch, buf...)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(target))
+	patch = append(patch, buf...)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(patch))
+	return append(patch, buf...)
+}
+
+func makeUPS(source, target []byte) []byte {
+	patch := append([]byte{}, upsMagic...)
+	patch = append(patch, encodeNumber(len(source))...)
+	patch = append(patch, encodeNumber(len(target))...)
+
+	at := func(data []byte, i int) byte {
+		if i < len(data) {
+			return data[i]
+		}
+		return 0
+	}
+	last := 0
+	for i := 0; i < len(target); i++ {
+		if at(source, i) == target[i] {
+			continue
+		}
+		patch = append(patch, encodeNumber(i-last)...)
+		for ; i < len(target) && at(source, i) != target[i]; i++ {
+			patch = append(patch, at(source, i)^target[i])
+		}
+		patch = append(patch, 0)
+		last = i + 1
+	}
+	return appendFooter(patch, source, target)
+}
+
+func TestIPS(t *testing.T) {
+	rom := []byte("0123456789")
+	patch := append([]byte{}, ipsMagic...)
+	patch = append(patch, 0x00, 0x00, 0x02, 0x00, 0x02, 'A', 'B') // 2: "AB"
+	patch = append(patch, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x03, 'Z')
+	patch = append(patch, 0x00, 0x00, 0x0B, 0x00, 0x01, '!') // past the end
+	patch = append(patch, ipsEOF...)
+
+	res, err := Apply(rom, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []byte("01AB4ZZZ89\x00!")
+	if !bytes.Equal(res, exp) {
+		t.Errorf("res=%q (exp: %q)", res, exp)
+	}
+	if !bytes.Equal(rom, []byte("0123456789")) {
+		t.Error("the source has been modified")
+	}
+
+	// Truncation
+	patch = append(patch, 0x00, 0x00, 0x04)
+	res, err = Apply(rom, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, []byte("01AB")) {
+		t.Errorf("res=%q (exp: %q)", res, "01AB")
+	}
+}
+
+func TestUPS(t *testing.T) {
+	source := []byte("hello world, hello world")
+	target := []byte("hello WORLD, hello world!!")
+	res, err := Apply(source, makeUPS(source, target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, target) {
+		t.Errorf("res=%q (exp: %q)", res, target)
+	}
+
+	_, err = Apply([]byte("another rom with the same size"), makeUPS(source, target))
+	if err == nil {
+		t.Error("the source checksum has not been verified")
+	}
+}
+
+func TestBPS(t *testing.T) {
+	source := []byte("ABCDEFGH")
+	target := []byte("ABCDxyxyxyEFGH")
+
+	patch := append([]byte{}, bpsMagic...)
+	patch = append(patch, encodeNumber(len(source))...)
+	patch = append(patch, encodeNumber(len(target))...)
+	patch = append(patch, encodeNumber(4)...) // metadata
+	patch = append(patch, "meta"...)
+	patch = append(patch, encodeNumber((4-1)<<2|bpsSourceRead)...) // ABCD
+	patch = append(patch, encodeNumber((2-1)<<2|bpsTargetRead)...) // xy
+	patch = append(patch, "xy"...)
+	patch = append(patch, encodeNumber((4-1)<<2|bpsTargetCopy)...) // xyxy
+	patch = append(patch, encodeNumber(4<<1)...)
+	patch = append(patch, encodeNumber((4-1)<<2|bpsSourceCopy)...) // EFGH
+	patch = append(patch, encodeNumber(4<<1)...)
+	patch = appendFooter(patch, source, target)
+
+	res, err := Apply(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, target) {
+		t.Errorf("res=%q (exp: %q)", res, target)
+	}
+
+	// Corrupted patch
+	patch[len(bpsMagic)+10] ^= 0xFF
+	_, err = Apply(source, patch)
+	if err == nil {
+		t.Error("the patch checksum has not been verified")
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := Apply([]byte("rom"), []byte("not a patch"))
+	if err == nil {
+		t.Error("unexpected success")
+	}
+}
@@ -0,0 +1,156 @@
+package romfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Extensions of the ROMs (and GBS files) searched in archives
+var romExtensions = []string{".gb", ".gbc", ".cgb", ".sgb", ".gbs"}
+
+// Uncompressed ROMs are never bigger than 8 MB (512 MBC5 banks), this is a
+// bound to avoid unpacking huge (or malicious) archives
+const maxRomSize = 8 << 20
+
+type RomFileError string
+
+func (err RomFileError) Error() string {
+	return string(err)
+}
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1F, 0x8B}
+)
+
+func isRomName(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, romExt := range romExtensions {
+		if ext == romExt {
+			return true
+		}
+	}
+	return false
+}
+
+func readAll(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxRomSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxRomSize {
+		return nil, RomFileError("The ROM is too big")
+	}
+	return data, nil
+}
+
+// Load reads the ROM at path. Zip and gzip archives are transparently
+// extracted: in zip archives the first .gb/.gbc entry is used, unless
+// entry is not empty
+func Load(path, entry string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Extract(data, entry)
+}
+
+// LoadSymbols reads the symbol file next to the ROM at path ("game.sym" or
+// "game.gb.sym" for "game.gb"), it returns nil if there is none
+func LoadSymbols(romPath string) ([]byte, error) {
+	base := strings.TrimSuffix(romPath, filepath.Ext(romPath))
+	for _, symPath := range []string{base + ".sym", romPath + ".sym"} {
+		data, err := os.ReadFile(symPath)
+		if err == nil {
+			return data, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// Extract returns the ROM contained in data, that can be a zip archive, a
+// gzip file or a raw ROM (returned as is)
+func Extract(data []byte, entry string) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, zipMagic):
+		return extractZip(data, entry)
+	case bytes.HasPrefix(data, gzipMagic):
+		if entry != "" {
+			return nil, RomFileError("Entries can be selected only in zip archives")
+		}
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readAll(r)
+	}
+	if entry != "" {
+		return nil, RomFileError("Entries can be selected only in zip archives")
+	}
+	return data, nil
+}
+
+func extractZip(data []byte, entry string) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var file *zip.File
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if entry != "" && f.Name == entry {
+			file = f
+			break
+		}
+		if entry == "" && isRomName(f.Name) {
+			file = f
+			break
+		}
+	}
+	if file == nil {
+		if entry != "" {
+			return nil, RomFileError("No entry named " + entry + " in the archive")
+		}
+		return nil, RomFileError("No ROM found in the archive")
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readAll(rc)
+}
+
+// List returns the names of the ROMs contained in a zip archive
+func List(data []byte) ([]string, error) {
+	if !bytes.HasPrefix(data, zipMagic) {
+		return nil, RomFileError("Not a zip archive")
+	}
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0)
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() && isRomName(f.Name) {
+			res = append(res, f.Name)
+		}
+	}
+	return res, nil
+}
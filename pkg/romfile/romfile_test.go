@@ -0,0 +1,102 @@
+package romfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func makeZip(t *testing.T, files map[string][]byte, order []string) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, name := range order {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(files[name])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZip(t *testing.T) {
+	files := map[string][]byte{
+		"readme.txt":    []byte("not a rom"),
+		"game (E).gbc":  []byte("rom E"),
+		"game (U).GB":   []byte("rom U"),
+		"dir/other.bin": []byte("data"),
+	}
+	archive := makeZip(t, files, []string{"readme.txt", "game (E).gbc", "game (U).GB", "dir/other.bin"})
+
+	rom, err := Extract(archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rom) != "rom E" {
+		t.Errorf("rom=%q (exp: %q)", rom, "rom E")
+	}
+
+	rom, err = Extract(archive, "game (U).GB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rom) != "rom U" {
+		t.Errorf("rom=%q (exp: %q)", rom, "rom U")
+	}
+
+	if _, err = Extract(archive, "missing.gb"); err == nil {
+		t.Error("unexpected success with a missing entry")
+	}
+
+	names, err := List(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "game (E).gbc" || names[1] != "game (U).GB" {
+		t.Errorf("unexpected list %v", names)
+	}
+
+	archive = makeZip(t, files, []string{"readme.txt"})
+	if _, err = Extract(archive, ""); err == nil {
+		t.Error("unexpected success without ROMs")
+	}
+}
+
+func TestGzip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write([]byte("compressed rom"))
+	w.Close()
+
+	rom, err := Extract(buf.Bytes(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rom) != "compressed rom" {
+		t.Errorf("rom=%q (exp: %q)", rom, "compressed rom")
+	}
+
+	for _, size := range []int{maxRomSize, maxRomSize + 1} {
+		buf.Reset()
+		w.Reset(buf)
+		w.Write(make([]byte, size))
+		w.Close()
+		if _, err := Extract(buf.Bytes(), ""); (err == nil) != (size <= maxRomSize) {
+			t.Errorf("size %d: unexpected error %v", size, err)
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	rom, err := Extract([]byte("raw rom"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rom) != "raw rom" {
+		t.Errorf("rom=%q (exp: %q)", rom, "raw rom")
+	}
+}
@@ -10,6 +10,13 @@ type Memory interface {
 	Write(uint16, uint8)
 }
 
+// Ticker can be implemented by the Memory to advance the rest of the
+// system while an instruction is executed. Tick is called for every
+// M-cycle, before the memory access of the cycle (if any)
+type Ticker interface {
+	Tick(cycles int)
+}
+
 type Z80Interrupt struct {
 	Name string
 	Mask uint8
@@ -18,6 +25,7 @@ type Z80Interrupt struct {
 
 type Z80Cpu struct {
 	Mem                 Memory
+	ticker              Ticker
 	A, B, C, D, E, H, L uint8
 	SP, PC              uint16
 
@@ -28,23 +36,44 @@ type Z80Cpu struct {
 
 	branchWasTaken    bool
 	IsHalted          bool
-	IsStopped         bool
+	IsStopped         bool // cleared by the system (joypad, speed switch)
 	interruptsEnabled bool
 
+	// Set by the illegal opcodes: the CPU hangs until the next reset,
+	// interrupts included
+	IsLocked bool
+
+	// Instructions before EI takes effect, and HALT bug pending
+	eiDelay int
+	haltBug bool
+
 	Interrupts []Z80Interrupt
 
-	// Used to hold "OUT" data
+	// Used to hold "OUT" data.
+	//
+	// Deprecated: OUT (D3) is not an instruction of the Game Boy CPU, it
+	// locks up the CPU and the buffer stays empty
 	OutBuffer []byte
 
 	// Z80 disassembler, for debugging
 	EnableDisas bool
 	Disas       Z80Disas
+
+	// M-cycles elapsed in the current instruction
+	cycles int
+
+	// Set while the CPU reads the instruction stream, to tell the code
+	// fetches from the data reads (e.g., for watchpoints)
+	Fetching bool
 }
 
 func MakeZ80Cpu(mem Memory) *Z80Cpu {
 	cpu := &Z80Cpu{
 		Mem: mem,
 	}
+	if ticker, ok := mem.(Ticker); ok {
+		cpu.ticker = ticker
+	}
 	cpu.Reset()
 	return cpu
 }
@@ -54,6 +83,8 @@ func (cpu *Z80Cpu) Reset() {
 	cpu.PC = 0
 	cpu.OutBuffer = make([]byte, 0)
 	cpu.IsHalted = false
+	cpu.IsStopped = false
+	cpu.IsLocked = false
 }
 
 func panicIfErr(e error) {
@@ -82,6 +113,9 @@ func (cpu *Z80Cpu) Save(encoder *gob.Encoder) {
 	panicIfErr(encoder.Encode(cpu.IsHalted))
 	panicIfErr(encoder.Encode(cpu.IsStopped))
 	panicIfErr(encoder.Encode(cpu.interruptsEnabled))
+	panicIfErr(encoder.Encode(cpu.eiDelay))
+	panicIfErr(encoder.Encode(cpu.haltBug))
+	panicIfErr(encoder.Encode(cpu.IsLocked))
 }
 
 func (cpu *Z80Cpu) Load(decoder *gob.Decoder) error {
@@ -105,6 +139,9 @@ func (cpu *Z80Cpu) Load(decoder *gob.Decoder) error {
 		decoder.Decode(&cpu.IsHalted),
 		decoder.Decode(&cpu.IsStopped),
 		decoder.Decode(&cpu.interruptsEnabled),
+		decoder.Decode(&cpu.eiDelay),
+		decoder.Decode(&cpu.haltBug),
+		decoder.Decode(&cpu.IsLocked),
 	}
 
 	for _, err := range errs {
@@ -115,6 +152,11 @@ func (cpu *Z80Cpu) Load(decoder *gob.Decoder) error {
 	return nil
 }
 
+// InterruptsEnabled returns IME
+func (cpu *Z80Cpu) InterruptsEnabled() bool {
+	return cpu.interruptsEnabled
+}
+
 func (cpu *Z80Cpu) RegisterInterrupt(interrupt Z80Interrupt) {
 	cpu.Interrupts = append(cpu.Interrupts, interrupt)
 }
@@ -123,14 +165,57 @@ func (cpu *Z80Cpu) SetInterrupt(mask uint8) {
 	cpu.IF |= mask
 }
 
+// tick advances the system by one M-cycle. It is called directly for the
+// internal cycles of the instructions, that do not access the memory
+func (cpu *Z80Cpu) tick() {
+	cpu.cycles += 1
+	if cpu.ticker != nil {
+		cpu.ticker.Tick(1)
+	}
+}
+
+// tickUntil accounts for the internal cycles at the end of an instruction
+func (cpu *Z80Cpu) tickUntil(cycles int) {
+	for cpu.cycles < cycles {
+		cpu.tick()
+	}
+}
+
+func (cpu *Z80Cpu) read(addr uint16) uint8 {
+	cpu.tick()
+	return cpu.Mem.Read(addr)
+}
+
+func (cpu *Z80Cpu) write(addr uint16, value uint8) {
+	cpu.tick()
+	cpu.Mem.Write(addr, value)
+}
+
+// fetch reads a byte of the instruction stream
+func (cpu *Z80Cpu) fetch(addr uint16) uint8 {
+	cpu.tick()
+	cpu.Fetching = true
+	v := cpu.Mem.Read(addr)
+	cpu.Fetching = false
+	return v
+}
+
 func (cpu *Z80Cpu) fetchOpcode() uint8 {
-	opcode := cpu.Mem.Read(cpu.PC)
+	opcode := cpu.fetch(cpu.PC)
+	if cpu.haltBug {
+		// PC is not incremented, the byte is read twice
+		cpu.haltBug = false
+		return opcode
+	}
 	cpu.PC += 1
 	return opcode
 }
 
+// Only the lower 5 bits of IE and IF are connected to interrupts
+const interruptMask = 0x1F
+
 func (cpu *Z80Cpu) handleInterrupts() bool {
-	if cpu.IE&cpu.IF&0xF != 0 {
+	if cpu.IE&cpu.IF&interruptMask != 0 {
 		cpu.IsHalted = false
 	}
 
@@ -138,38 +223,68 @@ func (cpu *Z80Cpu) handleInterrupts() bool {
 		return false
 	}
 
-	interruptValue := cpu.IE & cpu.IF
-	if interruptValue == 0 {
+	if cpu.IE&cpu.IF&interruptMask == 0 {
 		return false
 	}
 
 	cpu.IsHalted = false
-	cpu.StackPush16(cpu.PC)
 	cpu.interruptsEnabled = false
 
-	interruptWasFound := false
+	// With the HALT bug, the return address is the HALT itself
+	if cpu.haltBug {
+		cpu.haltBug = false
+		cpu.PC -= 1
+	}
+
+	// 5 M-cycles: two internal cycles, the push of PC and the jump
+	cpu.tick()
+	cpu.tick()
+	cpu.SP -= 1
+	cpu.write(cpu.SP, uint8(cpu.PC>>8))
+
+	// The interrupt is selected after pushing the upper byte of PC, which
+	// can overwrite IE (when SP is 0x0000). If nothing is left to service,
+	// the dispatch is cancelled and the CPU jumps to 0x0000
+	interruptValue := cpu.IE & cpu.IF & interruptMask
+	cpu.SP -= 1
+	cpu.write(cpu.SP, uint8(cpu.PC))
+
+	cpu.PC = 0
 	for _, interrupt := range cpu.Interrupts {
 		if interruptValue&interrupt.Mask != 0 {
 			cpu.IF &= ^interrupt.Mask
 			cpu.PC = interrupt.Addr
-			interruptWasFound = true
 			break
 		}
 	}
-
-	if !interruptWasFound {
-		panic("Invalid interrupt")
-	}
+	cpu.tick()
 	return true
 }
 
 func (cpu *Z80Cpu) ExecOne() int {
+	cpu.cycles = 0
+	if cpu.IsLocked || cpu.IsStopped {
+		// the system takes the CPU out of STOP (see IsStopped)
+		cpu.tickUntil(1)
+		return 1
+	}
+
+	// EI takes effect after the following instruction
+	if cpu.eiDelay > 0 {
+		cpu.eiDelay -= 1
+		if cpu.eiDelay == 0 {
+			cpu.interruptsEnabled = true
+		}
+	}
+
 	inInterrupt := cpu.handleInterrupts()
 	if inInterrupt {
+		cpu.tickUntil(5)
 		return 5
 	}
 
 	if cpu.IsHalted {
+		cpu.tickUntil(1)
 		return 1
 	}
 
@@ -183,7 +298,9 @@ func (cpu *Z80Cpu) ExecOne() int {
 	opcode := cpu.fetchOpcode()
 	if opcode == 0xcb {
 		isCBOpcode = true
+		cpu.Fetching = true
 		cb_opcode = cpu.Mem.Read(cpu.PC)
+		cpu.Fetching = false
 	}
 
 	cpu.branchWasTaken = false
@@ -199,6 +316,7 @@ func (cpu *Z80Cpu) ExecOne() int {
 		ticks += int(ticks_opcode[opcode])
 	}
 
+	cpu.tickUntil(ticks)
 	return ticks
 }
 
@@ -227,32 +345,35 @@ func (cpu *Z80Cpu) UnpackFlags(f uint8) {
 }
 
 func (cpu *Z80Cpu) getPC8() uint8 {
-	v := cpu.Mem.Read(cpu.PC)
+	v := cpu.fetch(cpu.PC)
 
 	cpu.PC += 1
 	return v
 }
 
 func (cpu *Z80Cpu) getPC16() uint16 {
-	l := cpu.Mem.Read(cpu.PC)
-	h := cpu.Mem.Read(cpu.PC + 1)
+	l := cpu.fetch(cpu.PC)
+	h := cpu.fetch(cpu.PC + 1)
 
 	cpu.PC += 2
 	return (uint16(h) << 8) | uint16(l)
 }
 
 func (cpu *Z80Cpu) StackPush16(val uint16) {
+	// internal cycle (SP decrement) before the writes
+	cpu.tick()
+
 	cpu.SP -= 1
-	cpu.Mem.Write(cpu.SP, uint8(val>>8))
+	cpu.write(cpu.SP, uint8(val>>8))
 
 	cpu.SP -= 1
-	cpu.Mem.Write(cpu.SP, uint8(val&0xff))
+	cpu.write(cpu.SP, uint8(val&0xff))
 }
 
 func (cpu *Z80Cpu) StackPop16() uint16 {
-	low := cpu.Mem.Read(cpu.SP)
+	low := cpu.read(cpu.SP)
 	cpu.SP += 1
-	high := cpu.Mem.Read(cpu.SP)
+	high := cpu.read(cpu.SP)
 	cpu.SP += 1
 
 	return uint16(high)<<8 | uint16(low)
@@ -284,37 +405,37 @@ func handler_ld_R_8(cpu *Z80Cpu, dst *uint8, value uint8) {
 }
 
 func handler_ld_MEM_8(cpu *Z80Cpu, dst_addr uint16, src uint8) {
-	cpu.Mem.Write(dst_addr, src)
+	cpu.write(dst_addr, src)
 }
 
 func handler_ld_MEM_16(cpu *Z80Cpu, dst_addr uint16, src uint16) {
-	cpu.Mem.Write(dst_addr, uint8(src&0xff))
-	cpu.Mem.Write(dst_addr+1, uint8((src>>8)&0xff))
+	cpu.write(dst_addr, uint8(src&0xff))
+	cpu.write(dst_addr+1, uint8((src>>8)&0xff))
 }
 
 func handler_ld_R_MEM_8(cpu *Z80Cpu, dst *uint8, addr uint16) {
-	*dst = cpu.Mem.Read(addr)
+	*dst = cpu.read(addr)
 }
 
 // LDI
 func handler_ldi_R_MEM(cpu *Z80Cpu, dst *uint8, addr uint16) {
-	*dst = cpu.Mem.Read(addr)
+	*dst = cpu.read(addr)
 	cpu.H, cpu.L = unpack_regcouple(pack_regcouple(cpu.H, cpu.L) + 1)
 }
 
 func handler_ldi_MEM_R(cpu *Z80Cpu, addr uint16, val uint8) {
-	cpu.Mem.Write(addr, val)
+	cpu.write(addr, val)
 	cpu.H, cpu.L = unpack_regcouple(pack_regcouple(cpu.H, cpu.L) + 1)
 }
 
 // LDD
 func handler_ldd_R_MEM(cpu *Z80Cpu, dst *uint8, addr uint16) {
-	*dst = cpu.Mem.Read(addr)
+	*dst = cpu.read(addr)
 	cpu.H, cpu.L = unpack_regcouple(pack_regcouple(cpu.H, cpu.L) - 1)
 }
 
 func handler_ldd_MEM_R(cpu *Z80Cpu, addr uint16, val uint8) {
-	cpu.Mem.Write(addr, val)
+	cpu.write(addr, val)
 	cpu.H, cpu.L = unpack_regcouple(pack_regcouple(cpu.H, cpu.L) - 1)
 }
 
@@ -352,8 +473,8 @@ func handler_inc_R_16_2(cpu *Z80Cpu, dst *uint16) {
 }
 
 func handler_inc_MEM(cpu *Z80Cpu, addr uint16) {
-	val := cpu.Mem.Read(addr) + 1
-	cpu.Mem.Write(addr, val)
+	val := cpu.read(addr) + 1
+	cpu.write(addr, val)
 
 	cpu.flagWasZero = val == 0
 	cpu.flagWasSub = false
@@ -380,8 +501,8 @@ func handler_dec_R_16_2(cpu *Z80Cpu, dst *uint16) {
 }
 
 func handler_dec_MEM(cpu *Z80Cpu, addr uint16) {
-	val := cpu.Mem.Read(addr) - 1
-	cpu.Mem.Write(addr, val)
+	val := cpu.read(addr) - 1
+	cpu.write(addr, val)
 
 	cpu.flagWasZero = val == 0
 	cpu.flagWasSub = true
@@ -564,10 +685,10 @@ func handler_srl_R(cpu *Z80Cpu, dst *uint8) {
 }
 
 func handler_srl_MEM(cpu *Z80Cpu, addr uint16) {
-	val := cpu.Mem.Read(addr)
+	val := cpu.read(addr)
 	c := val & 1
 	val >>= 1
-	cpu.Mem.Write(addr, val)
+	cpu.write(addr, val)
 
 	cpu.flagCarry = c != 0
 	cpu.flagWasZero = val == 0
@@ -588,10 +709,10 @@ func handler_rlc_R(cpu *Z80Cpu, dst *uint8) {
 }
 
 func handler_rlc_MEM(cpu *Z80Cpu, addr uint16) {
-	val := cpu.Mem.Read(addr)
+	val := cpu.read(addr)
 	carry := val >> 7
 	res := val<<1 | carry
-	cpu.Mem.Write(addr, res)
+	cpu.write(addr, res)
 
 	cpu.flagWasZero = res == 0
 	cpu.flagWasSub = false
@@ -614,7 +735,7 @@ func handler_rl_R(cpu *Z80Cpu, dst *uint8) {
 }
 
 func handler_rl_MEM(cpu *Z80Cpu, addr uint16) {
-	val := cpu.Mem.Read(addr)
+	val := cpu.read(addr)
 	carry := val >> 7
 
 	val = val << 1
@@ -622,7 +743,7 @@ func handler_rl_MEM(cpu *Z80Cpu, addr uint16) {
 		val |= 1
 	}
 
-	cpu.Mem.Write(addr, val)
+	cpu.write(addr, val)
 
 	cpu.flagWasZero = val == 0
 	cpu.flagWasSub = false
@@ -643,10 +764,10 @@ func handler_rrc_R(cpu *Z80Cpu, dst *uint8) {
 }
 
 func handler_rrc_MEM(cpu *Z80Cpu, addr uint16) {
-	val := cpu.Mem.Read(addr)
+	val := cpu.read(addr)
 	carry := val & 1
 	res := carry<<7 | val>>1
-	cpu.Mem.Write(addr, res)
+	cpu.write(addr, res)
 
 	cpu.flagWasZero = res == 0
 	cpu.flagWasSub = false
@@ -669,14 +790,14 @@ func handler_rr_R(cpu *Z80Cpu, dst *uint8) {
 }
 
 func handler_rr_MEM(cpu *Z80Cpu, addr uint16) {
-	val := cpu.Mem.Read(addr)
+	val := cpu.read(addr)
 	carry := val & 1
 
 	val >>= 1
 	if cpu.flagCarry {
 		val |= 1 << 7
 	}
-	cpu.Mem.Write(addr, val)
+	cpu.write(addr, val)
 
 	cpu.flagWasZero = val == 0
 	cpu.flagWasSub = false
@@ -696,10 +817,10 @@ func handler_sla_R(cpu *Z80Cpu, dst *uint8) {
 }
 
 func handler_sla_MEM(cpu *Z80Cpu, addr uint16) {
-	val := cpu.Mem.Read(addr)
+	val := cpu.read(addr)
 	carry := val >> 7
 	val <<= 1
-	cpu.Mem.Write(addr, val)
+	cpu.write(addr, val)
 
 	cpu.flagWasZero = val == 0
 	cpu.flagCarry = carry != 0
@@ -721,12 +842,12 @@ func handler_sra_R(cpu *Z80Cpu, dst *uint8) {
 }
 
 func handler_sra_MEM(cpu *Z80Cpu, addr uint16) {
-	val := cpu.Mem.Read(addr)
+	val := cpu.read(addr)
 	carry := val & 1
 	signbit := val >> 7
 	val >>= 1
 	val |= signbit << 7
-	cpu.Mem.Write(addr, val)
+	cpu.write(addr, val)
 
 	cpu.flagWasZero = val == 0
 	cpu.flagCarry = carry != 0
@@ -750,8 +871,8 @@ func handler_res_R(cpu *Z80Cpu, bit int, dst *uint8) {
 func handler_res_MEM(cpu *Z80Cpu, bit int, addr uint16) {
 	var mask uint8 = ^(1 << bit)
 
-	val := cpu.Mem.Read(addr)
-	cpu.Mem.Write(addr, val&mask)
+	val := cpu.read(addr)
+	cpu.write(addr, val&mask)
 }
 
 // SET
@@ -760,9 +881,9 @@ func handler_set_R(cpu *Z80Cpu, bit int, dst *uint8) {
 }
 
 func handler_set_MEM(cpu *Z80Cpu, bit int, addr uint16) {
-	val := cpu.Mem.Read(addr)
+	val := cpu.read(addr)
 	val |= 1 << bit
-	cpu.Mem.Write(addr, val)
+	cpu.write(addr, val)
 }
 
 // SWAP
@@ -778,11 +899,11 @@ func handler_swap_R(cpu *Z80Cpu, dst *uint8) {
 }
 
 func handler_swap_MEM(cpu *Z80Cpu, addr uint16) {
-	val := cpu.Mem.Read(addr)
+	val := cpu.read(addr)
 	low := val & 0xF
 	hig := val >> 4
 	val = (low << 4) | hig
-	cpu.Mem.Write(addr, val)
+	cpu.write(addr, val)
 
 	cpu.flagWasZero = val == 0
 	cpu.flagCarry = false
@@ -796,6 +917,8 @@ func handler_ret(cpu *Z80Cpu) {
 }
 
 func handler_ret_IF(cpu *Z80Cpu, cond bool) {
+	// internal cycle to check the condition, before popping
+	cpu.tick()
 	if cond {
 		cpu.branchWasTaken = true
 		cpu.PC = cpu.StackPop16()
@@ -847,10 +970,21 @@ func handler_jp_IF(cpu *Z80Cpu, dst uint16, cond bool) {
 // OTHER
 func handler_di(cpu *Z80Cpu) {
 	cpu.interruptsEnabled = false
+	cpu.eiDelay = 0
 }
 
 func handler_ei(cpu *Z80Cpu) {
+	if !cpu.interruptsEnabled && cpu.eiDelay == 0 {
+		// enabled after the next instruction (see ExecOne)
+		cpu.eiDelay = 2
+	}
+}
+
+func handler_reti(cpu *Z80Cpu) {
+	handler_ret(cpu)
+	// no delay, unlike EI
 	cpu.interruptsEnabled = true
+	cpu.eiDelay = 0
 }
 
 func handler_rst(cpu *Z80Cpu, val uint16) {
@@ -870,27 +1004,26 @@ func handler_ccf(cpu *Z80Cpu) {
 	cpu.flagWasSub = false
 }
 
-func handler_out(cpu *Z80Cpu, value uint8) {
-	// FIXME: do not ignore the port
-	_ = cpu.getPC8()
-	cpu.OutBuffer = append(cpu.OutBuffer, value)
-}
-
 func handler_halt(cpu *Z80Cpu) {
+	if !cpu.interruptsEnabled && cpu.IE&cpu.IF&interruptMask != 0 {
+		// HALT bug: the CPU does not halt, and fails to increment PC
+		// after fetching the next opcode
+		cpu.haltBug = true
+		return
+	}
 	cpu.IsHalted = true
 }
 
 func handler_nop() {}
 
 func handler_stop(cpu *Z80Cpu) {
-	// It should be a two byte opcode
+	// Two bytes opcode, the second one is ignored
 	_ = cpu.getPC8()
 	cpu.IsStopped = true
 }
 
-func handler_undefined(cpu *Z80Cpu, opcode uint8) {
-	fmt.Printf("Executing an undefined opcode: %02x, halting\n", opcode)
-	cpu.IsHalted = true
+func handler_undefined(cpu *Z80Cpu) {
+	cpu.IsLocked = true
 }
 
 var handlers = [256]func(*Z80Cpu){
@@ -918,7 +1051,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_dec_R_8(cpu, &cpu.D) },                                              // 15
 	func(cpu *Z80Cpu) { handler_ld_R_8(cpu, &cpu.D, cpu.getPC8()) },                                 // 16
 	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.A); cpu.flagWasZero = false /* rla */ },              // 17
-	func(cpu *Z80Cpu) { handler_jr(cpu, int8(cpu.Mem.Read(cpu.PC))) },                               // 18
+	func(cpu *Z80Cpu) { handler_jr(cpu, int8(cpu.fetch(cpu.PC))) },                                  // 18
 	func(cpu *Z80Cpu) { handler_add_R_16(cpu, &cpu.H, &cpu.L, pack_regcouple(cpu.D, cpu.E)) },       // 19
 	func(cpu *Z80Cpu) { handler_ld_R_MEM_8(cpu, &cpu.A, pack_regcouple(cpu.D, cpu.E)) },             // 1A
 	func(cpu *Z80Cpu) { handler_dec_R_16(cpu, &cpu.D, &cpu.E) },                                     // 1B
@@ -926,7 +1059,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_dec_R_8(cpu, &cpu.E) },                                              // 1D
 	func(cpu *Z80Cpu) { handler_ld_R_8(cpu, &cpu.E, cpu.getPC8()) },                                 // 1E
 	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.A); cpu.flagWasZero = false /* rra */ },              // 1F
-	func(cpu *Z80Cpu) { handler_jr_IF(cpu, int8(cpu.Mem.Read(cpu.PC)), !cpu.flagWasZero) },          // 20
+	func(cpu *Z80Cpu) { handler_jr_IF(cpu, int8(cpu.fetch(cpu.PC)), !cpu.flagWasZero) },             // 20
 	func(cpu *Z80Cpu) { handler_ld_R_16(cpu, &cpu.H, &cpu.L, cpu.getPC16()) },                       // 21
 	func(cpu *Z80Cpu) { handler_ldi_MEM_R(cpu, pack_regcouple(cpu.H, cpu.L), cpu.A) },               // 22
 	func(cpu *Z80Cpu) { handler_inc_R_16(cpu, &cpu.H, &cpu.L) },                                     // 23
@@ -934,7 +1067,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_dec_R_8(cpu, &cpu.H) },                                              // 25
 	func(cpu *Z80Cpu) { handler_ld_R_8(cpu, &cpu.H, cpu.getPC8()) },                                 // 26
 	func(cpu *Z80Cpu) { handler_daa(cpu) },                                                          // 27
-	func(cpu *Z80Cpu) { handler_jr_IF(cpu, int8(cpu.Mem.Read(cpu.PC)), cpu.flagWasZero) },           // 28
+	func(cpu *Z80Cpu) { handler_jr_IF(cpu, int8(cpu.fetch(cpu.PC)), cpu.flagWasZero) },              // 28
 	func(cpu *Z80Cpu) { handler_add_R_16(cpu, &cpu.H, &cpu.L, pack_regcouple(cpu.H, cpu.L)) },       // 29
 	func(cpu *Z80Cpu) { handler_ldi_R_MEM(cpu, &cpu.A, pack_regcouple(cpu.H, cpu.L)) },              // 2A
 	func(cpu *Z80Cpu) { handler_dec_R_16(cpu, &cpu.H, &cpu.L) },                                     // 2B
@@ -942,7 +1075,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_dec_R_8(cpu, &cpu.L) },                                              // 2D
 	func(cpu *Z80Cpu) { handler_ld_R_8(cpu, &cpu.L, cpu.getPC8()) },                                 // 2E
 	func(cpu *Z80Cpu) { handler_cpl(cpu) },                                                          // 2F
-	func(cpu *Z80Cpu) { handler_jr_IF(cpu, int8(cpu.Mem.Read(cpu.PC)), !cpu.flagCarry) },            // 30
+	func(cpu *Z80Cpu) { handler_jr_IF(cpu, int8(cpu.fetch(cpu.PC)), !cpu.flagCarry) },               // 30
 	func(cpu *Z80Cpu) { handler_ld_R_16_2(cpu, &cpu.SP, cpu.getPC16()) },                            // 31
 	func(cpu *Z80Cpu) { handler_ldd_MEM_R(cpu, pack_regcouple(cpu.H, cpu.L), cpu.A) },               // 32
 	func(cpu *Z80Cpu) { handler_inc_R_16_2(cpu, &cpu.SP) },                                          // 33
@@ -950,7 +1083,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_dec_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },                        // 35
 	func(cpu *Z80Cpu) { handler_ld_MEM_8(cpu, pack_regcouple(cpu.H, cpu.L), cpu.getPC8()) },         // 36
 	func(cpu *Z80Cpu) { handler_scf(cpu) },                                                          // 37
-	func(cpu *Z80Cpu) { handler_jr_IF(cpu, int8(cpu.Mem.Read(cpu.PC)), cpu.flagCarry) },             // 38
+	func(cpu *Z80Cpu) { handler_jr_IF(cpu, int8(cpu.fetch(cpu.PC)), cpu.flagCarry) },                // 38
 	func(cpu *Z80Cpu) { handler_add_R_16(cpu, &cpu.H, &cpu.L, cpu.SP) },                             // 39
 	func(cpu *Z80Cpu) { handler_ldd_R_MEM(cpu, &cpu.A, pack_regcouple(cpu.H, cpu.L)) },              // 3A
 	func(cpu *Z80Cpu) { handler_dec_R_16_2(cpu, &cpu.SP) },                                          // 3B
@@ -1028,7 +1161,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_add_R_8(cpu, &cpu.A, cpu.E) },                                       // 83
 	func(cpu *Z80Cpu) { handler_add_R_8(cpu, &cpu.A, cpu.H) },                                       // 84
 	func(cpu *Z80Cpu) { handler_add_R_8(cpu, &cpu.A, cpu.L) },                                       // 85
-	func(cpu *Z80Cpu) { handler_add_R_8(cpu, &cpu.A, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) },  // 86
+	func(cpu *Z80Cpu) { handler_add_R_8(cpu, &cpu.A, cpu.read(pack_regcouple(cpu.H, cpu.L))) },      // 86
 	func(cpu *Z80Cpu) { handler_add_R_8(cpu, &cpu.A, cpu.A) },                                       // 87
 	func(cpu *Z80Cpu) { handler_adc_R_8(cpu, &cpu.A, cpu.B) },                                       // 88
 	func(cpu *Z80Cpu) { handler_adc_R_8(cpu, &cpu.A, cpu.C) },                                       // 89
@@ -1036,7 +1169,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_adc_R_8(cpu, &cpu.A, cpu.E) },                                       // 8B
 	func(cpu *Z80Cpu) { handler_adc_R_8(cpu, &cpu.A, cpu.H) },                                       // 8C
 	func(cpu *Z80Cpu) { handler_adc_R_8(cpu, &cpu.A, cpu.L) },                                       // 8D
-	func(cpu *Z80Cpu) { handler_adc_R_8(cpu, &cpu.A, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) },  // 8E
+	func(cpu *Z80Cpu) { handler_adc_R_8(cpu, &cpu.A, cpu.read(pack_regcouple(cpu.H, cpu.L))) },      // 8E
 	func(cpu *Z80Cpu) { handler_adc_R_8(cpu, &cpu.A, cpu.A) },                                       // 8F
 	func(cpu *Z80Cpu) { handler_sub_R_8(cpu, &cpu.A, cpu.B) },                                       // 90
 	func(cpu *Z80Cpu) { handler_sub_R_8(cpu, &cpu.A, cpu.C) },                                       // 91
@@ -1044,7 +1177,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_sub_R_8(cpu, &cpu.A, cpu.E) },                                       // 93
 	func(cpu *Z80Cpu) { handler_sub_R_8(cpu, &cpu.A, cpu.H) },                                       // 94
 	func(cpu *Z80Cpu) { handler_sub_R_8(cpu, &cpu.A, cpu.L) },                                       // 95
-	func(cpu *Z80Cpu) { handler_sub_R_8(cpu, &cpu.A, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) },  // 96
+	func(cpu *Z80Cpu) { handler_sub_R_8(cpu, &cpu.A, cpu.read(pack_regcouple(cpu.H, cpu.L))) },      // 96
 	func(cpu *Z80Cpu) { handler_sub_R_8(cpu, &cpu.A, cpu.A) },                                       // 97
 	func(cpu *Z80Cpu) { handler_sbc_R_8(cpu, &cpu.A, cpu.B) },                                       // 98
 	func(cpu *Z80Cpu) { handler_sbc_R_8(cpu, &cpu.A, cpu.C) },                                       // 99
@@ -1052,7 +1185,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_sbc_R_8(cpu, &cpu.A, cpu.E) },                                       // 9B
 	func(cpu *Z80Cpu) { handler_sbc_R_8(cpu, &cpu.A, cpu.H) },                                       // 9C
 	func(cpu *Z80Cpu) { handler_sbc_R_8(cpu, &cpu.A, cpu.L) },                                       // 9D
-	func(cpu *Z80Cpu) { handler_sbc_R_8(cpu, &cpu.A, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) },  // 9E
+	func(cpu *Z80Cpu) { handler_sbc_R_8(cpu, &cpu.A, cpu.read(pack_regcouple(cpu.H, cpu.L))) },      // 9E
 	func(cpu *Z80Cpu) { handler_sbc_R_8(cpu, &cpu.A, cpu.A) },                                       // 9F
 	func(cpu *Z80Cpu) { handler_and_R_8(cpu, &cpu.A, cpu.B) },                                       // A0
 	func(cpu *Z80Cpu) { handler_and_R_8(cpu, &cpu.A, cpu.C) },                                       // A1
@@ -1060,7 +1193,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_and_R_8(cpu, &cpu.A, cpu.E) },                                       // A3
 	func(cpu *Z80Cpu) { handler_and_R_8(cpu, &cpu.A, cpu.H) },                                       // A4
 	func(cpu *Z80Cpu) { handler_and_R_8(cpu, &cpu.A, cpu.L) },                                       // A5
-	func(cpu *Z80Cpu) { handler_and_R_8(cpu, &cpu.A, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) },  // A6
+	func(cpu *Z80Cpu) { handler_and_R_8(cpu, &cpu.A, cpu.read(pack_regcouple(cpu.H, cpu.L))) },      // A6
 	func(cpu *Z80Cpu) { handler_and_R_8(cpu, &cpu.A, cpu.A) },                                       // A7
 	func(cpu *Z80Cpu) { handler_xor_R_8(cpu, &cpu.A, cpu.B) },                                       // A8
 	func(cpu *Z80Cpu) { handler_xor_R_8(cpu, &cpu.A, cpu.C) },                                       // A9
@@ -1068,7 +1201,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_xor_R_8(cpu, &cpu.A, cpu.E) },                                       // AB
 	func(cpu *Z80Cpu) { handler_xor_R_8(cpu, &cpu.A, cpu.H) },                                       // AC
 	func(cpu *Z80Cpu) { handler_xor_R_8(cpu, &cpu.A, cpu.L) },                                       // AD
-	func(cpu *Z80Cpu) { handler_xor_R_8(cpu, &cpu.A, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) },  // AE
+	func(cpu *Z80Cpu) { handler_xor_R_8(cpu, &cpu.A, cpu.read(pack_regcouple(cpu.H, cpu.L))) },      // AE
 	func(cpu *Z80Cpu) { handler_xor_R_8(cpu, &cpu.A, cpu.A) },                                       // AF
 	func(cpu *Z80Cpu) { handler_or_R_8(cpu, &cpu.A, cpu.B) },                                        // B0
 	func(cpu *Z80Cpu) { handler_or_R_8(cpu, &cpu.A, cpu.C) },                                        // B1
@@ -1076,7 +1209,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_or_R_8(cpu, &cpu.A, cpu.E) },                                        // B3
 	func(cpu *Z80Cpu) { handler_or_R_8(cpu, &cpu.A, cpu.H) },                                        // B4
 	func(cpu *Z80Cpu) { handler_or_R_8(cpu, &cpu.A, cpu.L) },                                        // B5
-	func(cpu *Z80Cpu) { handler_or_R_8(cpu, &cpu.A, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) },   // B6
+	func(cpu *Z80Cpu) { handler_or_R_8(cpu, &cpu.A, cpu.read(pack_regcouple(cpu.H, cpu.L))) },       // B6
 	func(cpu *Z80Cpu) { handler_or_R_8(cpu, &cpu.A, cpu.A) },                                        // B7
 	func(cpu *Z80Cpu) { handler_cp(cpu, cpu.A, cpu.B) },                                             // B8
 	func(cpu *Z80Cpu) { handler_cp(cpu, cpu.A, cpu.C) },                                             // B9
@@ -1084,7 +1217,7 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_cp(cpu, cpu.A, cpu.E) },                                             // BB
 	func(cpu *Z80Cpu) { handler_cp(cpu, cpu.A, cpu.H) },                                             // BC
 	func(cpu *Z80Cpu) { handler_cp(cpu, cpu.A, cpu.L) },                                             // BD
-	func(cpu *Z80Cpu) { handler_cp(cpu, cpu.A, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) },        // BE
+	func(cpu *Z80Cpu) { handler_cp(cpu, cpu.A, cpu.read(pack_regcouple(cpu.H, cpu.L))) },            // BE
 	func(cpu *Z80Cpu) { handler_cp(cpu, cpu.A, cpu.A) },                                             // BF
 	func(cpu *Z80Cpu) { handler_ret_IF(cpu, !cpu.flagWasZero) },                                     // C0
 	func(cpu *Z80Cpu) { cpu.B, cpu.C = unpack_regcouple(cpu.StackPop16()) },                         // C1
@@ -1105,40 +1238,40 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_ret_IF(cpu, !cpu.flagCarry) },                                       // D0
 	func(cpu *Z80Cpu) { cpu.D, cpu.E = unpack_regcouple(cpu.StackPop16()) },                         // D1
 	func(cpu *Z80Cpu) { handler_jp_IF(cpu, cpu.getPC16(), !cpu.flagCarry) },                         // D2
-	func(cpu *Z80Cpu) { handler_out(cpu, cpu.A) },                                                   // D3
+	func(cpu *Z80Cpu) { handler_undefined(cpu) },                                                    // D3
 	func(cpu *Z80Cpu) { handler_call_IF(cpu, !cpu.flagCarry) },                                      // D4
 	func(cpu *Z80Cpu) { cpu.StackPush16(pack_regcouple(cpu.D, cpu.E)) },                             // D5
 	func(cpu *Z80Cpu) { handler_sub_R_8(cpu, &cpu.A, cpu.getPC8()) },                                // D6
 	func(cpu *Z80Cpu) { handler_rst(cpu, 16) },                                                      // D7
 	func(cpu *Z80Cpu) { handler_ret_IF(cpu, cpu.flagCarry) },                                        // D8
-	func(cpu *Z80Cpu) { handler_ret(cpu); handler_ei(cpu) },                                         // D9
+	func(cpu *Z80Cpu) { handler_reti(cpu) },                                                         // D9
 	func(cpu *Z80Cpu) { handler_jp_IF(cpu, cpu.getPC16(), cpu.flagCarry) },                          // DA
-	func(cpu *Z80Cpu) { handler_undefined(cpu, 0xdb) },                                              // DB
+	func(cpu *Z80Cpu) { handler_undefined(cpu) },                                                    // DB
 	func(cpu *Z80Cpu) { handler_call_IF(cpu, cpu.flagCarry) },                                       // DC
-	func(cpu *Z80Cpu) { handler_undefined(cpu, 0xdd) },                                              // DD
+	func(cpu *Z80Cpu) { handler_undefined(cpu) },                                                    // DD
 	func(cpu *Z80Cpu) { handler_sbc_R_8(cpu, &cpu.A, cpu.getPC8()) },                                // DE
 	func(cpu *Z80Cpu) { handler_rst(cpu, 24) },                                                      // DF
 	func(cpu *Z80Cpu) { handler_ld_MEM_8(cpu, uint16(cpu.getPC8())+0xFF00, cpu.A) },                 // E0
 	func(cpu *Z80Cpu) { cpu.H, cpu.L = unpack_regcouple(cpu.StackPop16()) },                         // E1
 	func(cpu *Z80Cpu) { handler_ld_MEM_8(cpu, uint16(cpu.C)+0xFF00, cpu.A) },                        // E2
-	func(cpu *Z80Cpu) { handler_undefined(cpu, 0xe3) },                                              // E3
-	func(cpu *Z80Cpu) { handler_undefined(cpu, 0xe4) },                                              // E4
+	func(cpu *Z80Cpu) { handler_undefined(cpu) },                                                    // E3
+	func(cpu *Z80Cpu) { handler_undefined(cpu) },                                                    // E4
 	func(cpu *Z80Cpu) { cpu.StackPush16(pack_regcouple(cpu.H, cpu.L)) },                             // E5
 	func(cpu *Z80Cpu) { handler_and_R_8(cpu, &cpu.A, cpu.getPC8()) },                                // E6
 	func(cpu *Z80Cpu) { handler_rst(cpu, 32) },                                                      // E7
 	func(cpu *Z80Cpu) { handler_add_sp(cpu) },                                                       // E8
 	func(cpu *Z80Cpu) { handler_jp(cpu, pack_regcouple(cpu.H, cpu.L)) },                             // E9
 	func(cpu *Z80Cpu) { handler_ld_MEM_8(cpu, cpu.getPC16(), cpu.A) },                               // EA
-	func(cpu *Z80Cpu) { handler_undefined(cpu, 0xeb) },                                              // EB
-	func(cpu *Z80Cpu) { handler_undefined(cpu, 0xec) },                                              // EC
-	func(cpu *Z80Cpu) { handler_undefined(cpu, 0xed) },                                              // ED
+	func(cpu *Z80Cpu) { handler_undefined(cpu) },                                                    // EB
+	func(cpu *Z80Cpu) { handler_undefined(cpu) },                                                    // EC
+	func(cpu *Z80Cpu) { handler_undefined(cpu) },                                                    // ED
 	func(cpu *Z80Cpu) { handler_xor_R_8(cpu, &cpu.A, cpu.getPC8()) },                                // EE
 	func(cpu *Z80Cpu) { handler_rst(cpu, 40) },                                                      // EF
 	func(cpu *Z80Cpu) { handler_ld_R_MEM_8(cpu, &cpu.A, uint16(cpu.getPC8())+0xFF00) },              // F0
 	func(cpu *Z80Cpu) { a, f := unpack_regcouple(cpu.StackPop16()); cpu.A = a; cpu.UnpackFlags(f) }, // F1
 	func(cpu *Z80Cpu) { handler_ld_R_MEM_8(cpu, &cpu.A, uint16(cpu.C)+0xFF00) },                     // F2
 	func(cpu *Z80Cpu) { handler_di(cpu) },                                                           // F3
-	func(cpu *Z80Cpu) { handler_undefined(cpu, 0xf4) },                                              // F4
+	func(cpu *Z80Cpu) { handler_undefined(cpu) },                                                    // F4
 	func(cpu *Z80Cpu) { cpu.StackPush16(pack_regcouple(cpu.A, cpu.PackFlags())) },                   // F5
 	func(cpu *Z80Cpu) { handler_or_R_8(cpu, &cpu.A, cpu.getPC8()) },                                 // F6
 	func(cpu *Z80Cpu) { handler_rst(cpu, 48) },                                                      // F7
@@ -1146,269 +1279,269 @@ var handlers = [256]func(*Z80Cpu){
 	func(cpu *Z80Cpu) { handler_ld_R_16_2(cpu, &cpu.SP, pack_regcouple(cpu.H, cpu.L)) },             // F9
 	func(cpu *Z80Cpu) { handler_ld_R_MEM_8(cpu, &cpu.A, cpu.getPC16()) },                            // FA
 	func(cpu *Z80Cpu) { handler_ei(cpu) },                                                           // FB
-	func(cpu *Z80Cpu) { handler_undefined(cpu, 0xfc) },                                              // FC
-	func(cpu *Z80Cpu) { handler_undefined(cpu, 0xfd) },                                              // FD
+	func(cpu *Z80Cpu) { handler_undefined(cpu) },                                                    // FC
+	func(cpu *Z80Cpu) { handler_undefined(cpu) },                                                    // FD
 	func(cpu *Z80Cpu) { handler_cp(cpu, cpu.A, cpu.getPC8()) },                                      // FE
 	func(cpu *Z80Cpu) { handler_rst(cpu, 56) },                                                      // FF
 }
 
 var cb_handlers = [256]func(*Z80Cpu){
-	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.B) },                                      // 00
-	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.C) },                                      // 01
-	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.D) },                                      // 02
-	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.E) },                                      // 03
-	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.H) },                                      // 04
-	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.L) },                                      // 05
-	func(cpu *Z80Cpu) { handler_rlc_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },              // 06
-	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.A) },                                      // 07
-	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.B) },                                      // 08
-	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.C) },                                      // 09
-	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.D) },                                      // 0A
-	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.E) },                                      // 0B
-	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.H) },                                      // 0C
-	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.L) },                                      // 0D
-	func(cpu *Z80Cpu) { handler_rrc_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },              // 0E
-	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.A) },                                      // 0F
-	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.B) },                                       // 10
-	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.C) },                                       // 11
-	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.D) },                                       // 12
-	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.E) },                                       // 13
-	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.H) },                                       // 14
-	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.L) },                                       // 15
-	func(cpu *Z80Cpu) { handler_rl_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },               // 16
-	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.A) },                                       // 17
-	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.B) },                                       // 18
-	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.C) },                                       // 19
-	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.D) },                                       // 1A
-	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.E) },                                       // 1B
-	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.H) },                                       // 1C
-	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.L) },                                       // 1D
-	func(cpu *Z80Cpu) { handler_rr_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },               // 1E
-	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.A) },                                       // 1F
-	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.B) },                                      // 20
-	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.C) },                                      // 21
-	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.D) },                                      // 22
-	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.E) },                                      // 23
-	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.H) },                                      // 24
-	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.L) },                                      // 25
-	func(cpu *Z80Cpu) { handler_sla_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },              // 26
-	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.A) },                                      // 27
-	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.B) },                                      // 28
-	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.C) },                                      // 29
-	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.D) },                                      // 2A
-	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.E) },                                      // 2B
-	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.H) },                                      // 2C
-	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.L) },                                      // 2D
-	func(cpu *Z80Cpu) { handler_sra_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },              // 2E
-	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.A) },                                      // 2F
-	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.B) },                                     // 30
-	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.C) },                                     // 31
-	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.D) },                                     // 32
-	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.E) },                                     // 33
-	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.H) },                                     // 34
-	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.L) },                                     // 35
-	func(cpu *Z80Cpu) { handler_swap_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },             // 36
-	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.A) },                                     // 37
-	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.B) },                                      // 38
-	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.C) },                                      // 39
-	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.D) },                                      // 3A
-	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.E) },                                      // 3B
-	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.H) },                                      // 3C
-	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.L) },                                      // 3D
-	func(cpu *Z80Cpu) { handler_srl_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },              // 3E
-	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.A) },                                      // 3F
-	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.B) },                                      // 40
-	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.C) },                                      // 41
-	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.D) },                                      // 42
-	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.E) },                                      // 43
-	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.H) },                                      // 44
-	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.L) },                                      // 45
-	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) }, // 46
-	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.A) },                                      // 47
-	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.B) },                                      // 48
-	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.C) },                                      // 49
-	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.D) },                                      // 4A
-	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.E) },                                      // 4B
-	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.H) },                                      // 4C
-	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.L) },                                      // 4D
-	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) }, // 4E
-	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.A) },                                      // 4F
-	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.B) },                                      // 50
-	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.C) },                                      // 51
-	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.D) },                                      // 52
-	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.E) },                                      // 53
-	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.H) },                                      // 54
-	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.L) },                                      // 55
-	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) }, // 56
-	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.A) },                                      // 57
-	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.B) },                                      // 58
-	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.C) },                                      // 59
-	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.D) },                                      // 5A
-	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.E) },                                      // 5B
-	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.H) },                                      // 5C
-	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.L) },                                      // 5D
-	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) }, // 5E
-	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.A) },                                      // 5F
-	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.B) },                                      // 60
-	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.C) },                                      // 61
-	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.D) },                                      // 62
-	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.E) },                                      // 63
-	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.H) },                                      // 64
-	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.L) },                                      // 65
-	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) }, // 66
-	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.A) },                                      // 67
-	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.B) },                                      // 68
-	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.C) },                                      // 69
-	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.D) },                                      // 6A
-	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.E) },                                      // 6B
-	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.H) },                                      // 6C
-	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.L) },                                      // 6D
-	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) }, // 6E
-	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.A) },                                      // 6F
-	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.B) },                                      // 70
-	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.C) },                                      // 71
-	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.D) },                                      // 72
-	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.E) },                                      // 73
-	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.H) },                                      // 74
-	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.L) },                                      // 75
-	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) }, // 76
-	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.A) },                                      // 77
-	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.B) },                                      // 78
-	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.C) },                                      // 79
-	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.D) },                                      // 7A
-	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.E) },                                      // 7B
-	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.H) },                                      // 7C
-	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.L) },                                      // 7D
-	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.Mem.Read(pack_regcouple(cpu.H, cpu.L))) }, // 7E
-	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.A) },                                      // 7F
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.B) },                                   // 80
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.C) },                                   // 81
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.D) },                                   // 82
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.E) },                                   // 83
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.H) },                                   // 84
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.L) },                                   // 85
-	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 0, pack_regcouple(cpu.H, cpu.L)) },           // 86
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.A) },                                   // 87
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.B) },                                   // 88
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.C) },                                   // 89
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.D) },                                   // 8A
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.E) },                                   // 8B
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.H) },                                   // 8C
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.L) },                                   // 8D
-	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 1, pack_regcouple(cpu.H, cpu.L)) },           // 8E
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.A) },                                   // 8F
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.B) },                                   // 90
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.C) },                                   // 91
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.D) },                                   // 92
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.E) },                                   // 93
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.H) },                                   // 94
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.L) },                                   // 95
-	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 2, pack_regcouple(cpu.H, cpu.L)) },           // 96
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.A) },                                   // 97
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.B) },                                   // 98
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.C) },                                   // 99
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.D) },                                   // 9A
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.E) },                                   // 9B
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.H) },                                   // 9C
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.L) },                                   // 9D
-	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 3, pack_regcouple(cpu.H, cpu.L)) },           // 9E
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.A) },                                   // 9F
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.B) },                                   // A0
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.C) },                                   // A1
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.D) },                                   // A2
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.E) },                                   // A3
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.H) },                                   // A4
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.L) },                                   // A5
-	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 4, pack_regcouple(cpu.H, cpu.L)) },           // A6
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.A) },                                   // A7
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.B) },                                   // A8
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.C) },                                   // A9
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.D) },                                   // AA
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.E) },                                   // AB
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.H) },                                   // AC
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.L) },                                   // AD
-	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 5, pack_regcouple(cpu.H, cpu.L)) },           // AE
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.A) },                                   // AF
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.B) },                                   // B0
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.C) },                                   // B1
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.D) },                                   // B2
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.E) },                                   // B3
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.H) },                                   // B4
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.L) },                                   // B5
-	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 6, pack_regcouple(cpu.H, cpu.L)) },           // B6
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.A) },                                   // B7
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.B) },                                   // B8
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.C) },                                   // B9
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.D) },                                   // BA
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.E) },                                   // BB
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.H) },                                   // BC
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.L) },                                   // BD
-	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 7, pack_regcouple(cpu.H, cpu.L)) },           // BE
-	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.A) },                                   // BF
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.B) },                                   // C0
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.C) },                                   // C1
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.D) },                                   // C2
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.E) },                                   // C3
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.H) },                                   // C4
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.L) },                                   // C5
-	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 0, pack_regcouple(cpu.H, cpu.L)) },           // C6
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.A) },                                   // C7
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.B) },                                   // C8
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.C) },                                   // C9
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.D) },                                   // CA
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.E) },                                   // CB
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.H) },                                   // CC
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.L) },                                   // CD
-	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 1, pack_regcouple(cpu.H, cpu.L)) },           // CE
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.A) },                                   // CF
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.B) },                                   // D0
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.C) },                                   // D1
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.D) },                                   // D2
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.E) },                                   // D3
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.H) },                                   // D4
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.L) },                                   // D5
-	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 2, pack_regcouple(cpu.H, cpu.L)) },           // D6
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.A) },                                   // D7
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.B) },                                   // D8
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.C) },                                   // D9
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.D) },                                   // DA
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.E) },                                   // DB
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.H) },                                   // DC
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.L) },                                   // DD
-	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 3, pack_regcouple(cpu.H, cpu.L)) },           // DE
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.A) },                                   // DF
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.B) },                                   // E0
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.C) },                                   // E1
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.D) },                                   // E2
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.E) },                                   // E3
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.H) },                                   // E4
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.L) },                                   // E5
-	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 4, pack_regcouple(cpu.H, cpu.L)) },           // E6
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.A) },                                   // E7
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.B) },                                   // E8
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.C) },                                   // E9
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.D) },                                   // EA
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.E) },                                   // EB
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.H) },                                   // EC
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.L) },                                   // ED
-	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 5, pack_regcouple(cpu.H, cpu.L)) },           // EE
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.A) },                                   // EF
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.B) },                                   // F0
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.C) },                                   // F1
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.D) },                                   // F2
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.E) },                                   // F3
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.H) },                                   // F4
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.L) },                                   // F5
-	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 6, pack_regcouple(cpu.H, cpu.L)) },           // F6
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.A) },                                   // F7
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.B) },                                   // F8
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.C) },                                   // F9
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.D) },                                   // FA
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.E) },                                   // FB
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.H) },                                   // FC
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.L) },                                   // FD
-	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 7, pack_regcouple(cpu.H, cpu.L)) },           // FE
-	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.A) },                                   // FF
+	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.B) },                                  // 00
+	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.C) },                                  // 01
+	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.D) },                                  // 02
+	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.E) },                                  // 03
+	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.H) },                                  // 04
+	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.L) },                                  // 05
+	func(cpu *Z80Cpu) { handler_rlc_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },          // 06
+	func(cpu *Z80Cpu) { handler_rlc_R(cpu, &cpu.A) },                                  // 07
+	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.B) },                                  // 08
+	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.C) },                                  // 09
+	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.D) },                                  // 0A
+	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.E) },                                  // 0B
+	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.H) },                                  // 0C
+	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.L) },                                  // 0D
+	func(cpu *Z80Cpu) { handler_rrc_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },          // 0E
+	func(cpu *Z80Cpu) { handler_rrc_R(cpu, &cpu.A) },                                  // 0F
+	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.B) },                                   // 10
+	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.C) },                                   // 11
+	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.D) },                                   // 12
+	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.E) },                                   // 13
+	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.H) },                                   // 14
+	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.L) },                                   // 15
+	func(cpu *Z80Cpu) { handler_rl_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },           // 16
+	func(cpu *Z80Cpu) { handler_rl_R(cpu, &cpu.A) },                                   // 17
+	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.B) },                                   // 18
+	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.C) },                                   // 19
+	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.D) },                                   // 1A
+	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.E) },                                   // 1B
+	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.H) },                                   // 1C
+	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.L) },                                   // 1D
+	func(cpu *Z80Cpu) { handler_rr_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },           // 1E
+	func(cpu *Z80Cpu) { handler_rr_R(cpu, &cpu.A) },                                   // 1F
+	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.B) },                                  // 20
+	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.C) },                                  // 21
+	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.D) },                                  // 22
+	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.E) },                                  // 23
+	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.H) },                                  // 24
+	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.L) },                                  // 25
+	func(cpu *Z80Cpu) { handler_sla_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },          // 26
+	func(cpu *Z80Cpu) { handler_sla_R(cpu, &cpu.A) },                                  // 27
+	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.B) },                                  // 28
+	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.C) },                                  // 29
+	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.D) },                                  // 2A
+	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.E) },                                  // 2B
+	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.H) },                                  // 2C
+	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.L) },                                  // 2D
+	func(cpu *Z80Cpu) { handler_sra_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },          // 2E
+	func(cpu *Z80Cpu) { handler_sra_R(cpu, &cpu.A) },                                  // 2F
+	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.B) },                                 // 30
+	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.C) },                                 // 31
+	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.D) },                                 // 32
+	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.E) },                                 // 33
+	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.H) },                                 // 34
+	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.L) },                                 // 35
+	func(cpu *Z80Cpu) { handler_swap_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },         // 36
+	func(cpu *Z80Cpu) { handler_swap_R(cpu, &cpu.A) },                                 // 37
+	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.B) },                                  // 38
+	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.C) },                                  // 39
+	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.D) },                                  // 3A
+	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.E) },                                  // 3B
+	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.H) },                                  // 3C
+	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.L) },                                  // 3D
+	func(cpu *Z80Cpu) { handler_srl_MEM(cpu, pack_regcouple(cpu.H, cpu.L)) },          // 3E
+	func(cpu *Z80Cpu) { handler_srl_R(cpu, &cpu.A) },                                  // 3F
+	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.B) },                                  // 40
+	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.C) },                                  // 41
+	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.D) },                                  // 42
+	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.E) },                                  // 43
+	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.H) },                                  // 44
+	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.L) },                                  // 45
+	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.read(pack_regcouple(cpu.H, cpu.L))) }, // 46
+	func(cpu *Z80Cpu) { handler_bit(cpu, 0, cpu.A) },                                  // 47
+	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.B) },                                  // 48
+	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.C) },                                  // 49
+	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.D) },                                  // 4A
+	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.E) },                                  // 4B
+	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.H) },                                  // 4C
+	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.L) },                                  // 4D
+	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.read(pack_regcouple(cpu.H, cpu.L))) }, // 4E
+	func(cpu *Z80Cpu) { handler_bit(cpu, 1, cpu.A) },                                  // 4F
+	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.B) },                                  // 50
+	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.C) },                                  // 51
+	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.D) },                                  // 52
+	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.E) },                                  // 53
+	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.H) },                                  // 54
+	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.L) },                                  // 55
+	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.read(pack_regcouple(cpu.H, cpu.L))) }, // 56
+	func(cpu *Z80Cpu) { handler_bit(cpu, 2, cpu.A) },                                  // 57
+	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.B) },                                  // 58
+	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.C) },                                  // 59
+	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.D) },                                  // 5A
+	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.E) },                                  // 5B
+	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.H) },                                  // 5C
+	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.L) },                                  // 5D
+	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.read(pack_regcouple(cpu.H, cpu.L))) }, // 5E
+	func(cpu *Z80Cpu) { handler_bit(cpu, 3, cpu.A) },                                  // 5F
+	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.B) },                                  // 60
+	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.C) },                                  // 61
+	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.D) },                                  // 62
+	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.E) },                                  // 63
+	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.H) },                                  // 64
+	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.L) },                                  // 65
+	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.read(pack_regcouple(cpu.H, cpu.L))) }, // 66
+	func(cpu *Z80Cpu) { handler_bit(cpu, 4, cpu.A) },                                  // 67
+	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.B) },                                  // 68
+	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.C) },                                  // 69
+	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.D) },                                  // 6A
+	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.E) },                                  // 6B
+	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.H) },                                  // 6C
+	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.L) },                                  // 6D
+	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.read(pack_regcouple(cpu.H, cpu.L))) }, // 6E
+	func(cpu *Z80Cpu) { handler_bit(cpu, 5, cpu.A) },                                  // 6F
+	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.B) },                                  // 70
+	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.C) },                                  // 71
+	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.D) },                                  // 72
+	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.E) },                                  // 73
+	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.H) },                                  // 74
+	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.L) },                                  // 75
+	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.read(pack_regcouple(cpu.H, cpu.L))) }, // 76
+	func(cpu *Z80Cpu) { handler_bit(cpu, 6, cpu.A) },                                  // 77
+	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.B) },                                  // 78
+	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.C) },                                  // 79
+	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.D) },                                  // 7A
+	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.E) },                                  // 7B
+	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.H) },                                  // 7C
+	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.L) },                                  // 7D
+	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.read(pack_regcouple(cpu.H, cpu.L))) }, // 7E
+	func(cpu *Z80Cpu) { handler_bit(cpu, 7, cpu.A) },                                  // 7F
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.B) },                               // 80
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.C) },                               // 81
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.D) },                               // 82
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.E) },                               // 83
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.H) },                               // 84
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.L) },                               // 85
+	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 0, pack_regcouple(cpu.H, cpu.L)) },       // 86
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 0, &cpu.A) },                               // 87
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.B) },                               // 88
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.C) },                               // 89
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.D) },                               // 8A
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.E) },                               // 8B
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.H) },                               // 8C
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.L) },                               // 8D
+	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 1, pack_regcouple(cpu.H, cpu.L)) },       // 8E
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 1, &cpu.A) },                               // 8F
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.B) },                               // 90
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.C) },                               // 91
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.D) },                               // 92
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.E) },                               // 93
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.H) },                               // 94
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.L) },                               // 95
+	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 2, pack_regcouple(cpu.H, cpu.L)) },       // 96
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 2, &cpu.A) },                               // 97
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.B) },                               // 98
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.C) },                               // 99
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.D) },                               // 9A
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.E) },                               // 9B
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.H) },                               // 9C
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.L) },                               // 9D
+	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 3, pack_regcouple(cpu.H, cpu.L)) },       // 9E
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 3, &cpu.A) },                               // 9F
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.B) },                               // A0
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.C) },                               // A1
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.D) },                               // A2
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.E) },                               // A3
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.H) },                               // A4
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.L) },                               // A5
+	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 4, pack_regcouple(cpu.H, cpu.L)) },       // A6
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 4, &cpu.A) },                               // A7
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.B) },                               // A8
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.C) },                               // A9
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.D) },                               // AA
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.E) },                               // AB
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.H) },                               // AC
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.L) },                               // AD
+	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 5, pack_regcouple(cpu.H, cpu.L)) },       // AE
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 5, &cpu.A) },                               // AF
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.B) },                               // B0
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.C) },                               // B1
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.D) },                               // B2
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.E) },                               // B3
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.H) },                               // B4
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.L) },                               // B5
+	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 6, pack_regcouple(cpu.H, cpu.L)) },       // B6
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 6, &cpu.A) },                               // B7
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.B) },                               // B8
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.C) },                               // B9
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.D) },                               // BA
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.E) },                               // BB
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.H) },                               // BC
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.L) },                               // BD
+	func(cpu *Z80Cpu) { handler_res_MEM(cpu, 7, pack_regcouple(cpu.H, cpu.L)) },       // BE
+	func(cpu *Z80Cpu) { handler_res_R(cpu, 7, &cpu.A) },                               // BF
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.B) },                               // C0
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.C) },                               // C1
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.D) },                               // C2
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.E) },                               // C3
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.H) },                               // C4
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.L) },                               // C5
+	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 0, pack_regcouple(cpu.H, cpu.L)) },       // C6
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 0, &cpu.A) },                               // C7
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.B) },                               // C8
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.C) },                               // C9
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.D) },                               // CA
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.E) },                               // CB
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.H) },                               // CC
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.L) },                               // CD
+	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 1, pack_regcouple(cpu.H, cpu.L)) },       // CE
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 1, &cpu.A) },                               // CF
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.B) },                               // D0
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.C) },                               // D1
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.D) },                               // D2
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.E) },                               // D3
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.H) },                               // D4
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.L) },                               // D5
+	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 2, pack_regcouple(cpu.H, cpu.L)) },       // D6
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 2, &cpu.A) },                               // D7
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.B) },                               // D8
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.C) },                               // D9
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.D) },                               // DA
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.E) },                               // DB
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.H) },                               // DC
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.L) },                               // DD
+	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 3, pack_regcouple(cpu.H, cpu.L)) },       // DE
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 3, &cpu.A) },                               // DF
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.B) },                               // E0
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.C) },                               // E1
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.D) },                               // E2
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.E) },                               // E3
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.H) },                               // E4
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.L) },                               // E5
+	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 4, pack_regcouple(cpu.H, cpu.L)) },       // E6
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 4, &cpu.A) },                               // E7
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.B) },                               // E8
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.C) },                               // E9
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.D) },                               // EA
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.E) },                               // EB
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.H) },                               // EC
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.L) },                               // ED
+	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 5, pack_regcouple(cpu.H, cpu.L)) },       // EE
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 5, &cpu.A) },                               // EF
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.B) },                               // F0
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.C) },                               // F1
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.D) },                               // F2
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.E) },                               // F3
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.H) },                               // F4
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.L) },                               // F5
+	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 6, pack_regcouple(cpu.H, cpu.L)) },       // F6
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 6, &cpu.A) },                               // F7
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.B) },                               // F8
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.C) },                               // F9
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.D) },                               // FA
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.E) },                               // FB
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.H) },                               // FC
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.L) },                               // FD
+	func(cpu *Z80Cpu) { handler_set_MEM(cpu, 7, pack_regcouple(cpu.H, cpu.L)) },       // FE
+	func(cpu *Z80Cpu) { handler_set_R(cpu, 7, &cpu.A) },                               // FF
 }
 
 var ticks_opcode = []uint8{
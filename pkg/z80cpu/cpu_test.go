@@ -279,6 +279,19 @@ func TestCallRet(t *testing.T) {
 	}
 }
 
+// Collects the bytes written to 0xFF01 (SB)
+type OutMemory struct {
+	TestMemory
+	out []byte
+}
+
+func (mem *OutMemory) Write(addr uint16, val uint8) {
+	if addr == 0xFF01 {
+		mem.out = append(mem.out, val)
+	}
+	mem.TestMemory.Write(addr, val)
+}
+
 func TestProgHLToHex(t *testing.T) {
 	var prog = []byte{
 		0x00, 0x00, 0x00, // 00: nop (x3)
@@ -286,9 +299,9 @@ func TestProgHLToHex(t *testing.T) {
 		0xcd, 0x08, 0x00, // 04: call 0x08
 		0x76,       //       07: halt
 		0x3e, 0x30, //       08: ld a, 0x30
-		0xd3, 0x01, //       0a: out (0x01), a
+		0xe0, 0x01, //       0a: ldh (0x01), a
 		0x3e, 0x78, //       0c: ld a, 0x78
-		0xd3, 0x01, //       0e: out (0x01), a
+		0xe0, 0x01, //       0e: ldh (0x01), a
 		0x4c,             // 10: ld c, h
 		0xcd, 0x19, 0x00, // 11: call 0x19
 		0x4d,             // 14: ld c, l
@@ -306,16 +319,17 @@ func TestProgHLToHex(t *testing.T) {
 		0x27,       //       26: daa
 		0xce, 0x40, //       27: adc a,0x40
 		0x27,       //       29: daa
-		0xd3, 0x01, //       2a: out (0x01), a
+		0xe0, 0x01, //       2a: ldh (0x01), a
 		0xc9} //             2c: ret
 
-	memory := &TestMemory{}
+	memory := &OutMemory{}
 	memory.WriteBuffer(0, prog)
 
 	cpu := MakeZ80Cpu(memory)
 
 	runProg := func(inp uint16) string {
 		cpu.Reset()
+		memory.out = nil
 
 		cpu.H = uint8(inp >> 8)
 		cpu.L = uint8(inp & 0xff)
@@ -323,7 +337,7 @@ func TestProgHLToHex(t *testing.T) {
 		for !cpu.IsHalted {
 			cpu.ExecOne()
 		}
-		return string(cpu.OutBuffer)
+		return string(memory.out)
 	}
 
 	out := runProg(0xdead)
@@ -336,3 +350,266 @@ func TestProgHLToHex(t *testing.T) {
 		t.Errorf("output=%s, expected 0xBEEF", out)
 	}
 }
+
+// Records the M-cycle of every memory write
+type TickingMemory struct {
+	TestMemory
+	cycles int
+	writes []int
+}
+
+func (mem *TickingMemory) Tick(cycles int) {
+	mem.cycles += cycles
+}
+
+func (mem *TickingMemory) Write(addr uint16, val uint8) {
+	mem.writes = append(mem.writes, mem.cycles)
+	mem.TestMemory.Write(addr, val)
+}
+
+func TestCycleAccounting(t *testing.T) {
+	undefined := map[uint8]bool{
+		0xD3: true, 0xDB: true, 0xDD: true, 0xE3: true, 0xE4: true, 0xEB: true,
+		0xEC: true, 0xED: true, 0xF4: true, 0xFC: true, 0xFD: true,
+		0x10: true, // STOP
+		0x76: true, // HALT
+	}
+	for i := 0; i < 512; i++ {
+		opcode := uint8(i)
+		if i >= 256 {
+			opcode = 0xCB
+		} else if undefined[opcode] {
+			continue
+		}
+		for _, flags := range []uint8{0x00, 0xF0} {
+			memory := &TickingMemory{}
+			memory.WriteBuffer(0x100, []byte{opcode, uint8(i), 0x00})
+			cpu := MakeZ80Cpu(memory)
+			cpu.PC = 0x100
+			cpu.SP = 0xFFF0
+			cpu.UnpackFlags(flags)
+
+			ticks := cpu.ExecOne()
+			if memory.cycles != ticks {
+				t.Errorf("opcode %02x %02x: %d cycles ticked, %d expected", opcode, uint8(i), memory.cycles, ticks)
+			}
+		}
+	}
+}
+
+func TestPushCallTiming(t *testing.T) {
+	var prog = []byte{
+		0xc5,             // push bc
+		0xcd, 0x00, 0x02, // call 0x200
+	}
+
+	memory := &TickingMemory{}
+	memory.WriteBuffer(0x100, prog)
+	cpu := MakeZ80Cpu(memory)
+	cpu.PC = 0x100
+	cpu.SP = 0xFFF0
+
+	// push: fetch, internal, write, write
+	cpu.ExecOne()
+	if len(memory.writes) != 2 || memory.writes[0] != 3 || memory.writes[1] != 4 {
+		t.Errorf("push writes at cycles %v (exp: [3 4])", memory.writes)
+	}
+
+	// call: fetch, read, read, internal, write, write
+	memory.cycles, memory.writes = 0, nil
+	cpu.ExecOne()
+	if len(memory.writes) != 2 || memory.writes[0] != 5 || memory.writes[1] != 6 {
+		t.Errorf("call writes at cycles %v (exp: [5 6])", memory.writes)
+	}
+}
+
+// Maps IE at 0xFFFF, like the Gameboy
+type IEMemory struct {
+	TestMemory
+	cpu *Z80Cpu
+}
+
+func (mem *IEMemory) Read(addr uint16) uint8 {
+	if addr == 0xFFFF {
+		return mem.cpu.IE
+	}
+	return mem.TestMemory.Read(addr)
+}
+
+func (mem *IEMemory) Write(addr uint16, val uint8) {
+	if addr == 0xFFFF {
+		mem.cpu.IE = val
+		return
+	}
+	mem.TestMemory.Write(addr, val)
+}
+
+func makeInterruptCPU(prog []byte) *Z80Cpu {
+	memory := &IEMemory{}
+	memory.WriteBuffer(0x200, prog)
+	cpu := MakeZ80Cpu(memory)
+	memory.cpu = cpu
+	cpu.RegisterInterrupt(Z80Interrupt{Name: "VBLANK", Mask: 1, Addr: 0x40})
+	cpu.RegisterInterrupt(Z80Interrupt{Name: "STAT", Mask: 2, Addr: 0x48})
+	cpu.PC = 0x200
+	cpu.SP = 0xFFF0
+	return cpu
+}
+
+func TestEIDelay(t *testing.T) {
+	var prog = []byte{
+		0xfb, // ei
+		0x00, // nop
+		0x00, // nop
+	}
+	cpu := makeInterruptCPU(prog)
+	cpu.IE = 1
+	cpu.IF = 1
+
+	cpu.ExecOne()
+	cpu.ExecOne()
+	if cpu.PC != 0x202 {
+		t.Errorf("cpu.PC=%04x (exp: 0x0202), the interrupt has been serviced right after EI", cpu.PC)
+	}
+	ticks := cpu.ExecOne()
+	if cpu.PC != 0x40 || ticks != 5 {
+		t.Errorf("cpu.PC=%04x (exp: 0x0040); ticks=%d (exp: 5)", cpu.PC, ticks)
+	}
+	if cpu.IF != 0 {
+		t.Errorf("cpu.IF=%02x (exp: 0)", cpu.IF)
+	}
+}
+
+func TestHaltBug(t *testing.T) {
+	var prog = []byte{
+		0x76, // halt
+		0x3c, // inc a
+	}
+	cpu := makeInterruptCPU(prog)
+	cpu.IE = 1
+	cpu.IF = 1
+
+	cpu.ExecOne()
+	if cpu.IsHalted {
+		t.Fatal("the CPU is halted")
+	}
+	cpu.ExecOne()
+	cpu.ExecOne()
+	if cpu.A != 2 || cpu.PC != 0x202 {
+		t.Errorf("cpu.A=%d (exp: 2); cpu.PC=%04x (exp: 0x0202)", cpu.A, cpu.PC)
+	}
+}
+
+func TestIEPushCancellation(t *testing.T) {
+	var prog = []byte{
+		0x00, // nop
+	}
+	cpu := makeInterruptCPU(prog)
+	cpu.interruptsEnabled = true
+	cpu.IE = 1
+	cpu.IF = 1
+	// the upper byte of PC (0x02) is pushed to IE, disabling VBlank
+	cpu.SP = 0x0000
+
+	cpu.ExecOne()
+	if cpu.PC != 0 || cpu.IE != 0x02 || cpu.IF != 1 {
+		t.Errorf("cpu.PC=%04x (exp: 0x0000); cpu.IE=%02x (exp: 0x02); cpu.IF=%02x (exp: 0x01)", cpu.PC, cpu.IE, cpu.IF)
+	}
+}
+
+func TestIllegalOpcodeLock(t *testing.T) {
+	var prog = []byte{
+		0xd3, // illegal
+		0x3c, // inc a
+	}
+	cpu := makeInterruptCPU(prog)
+	cpu.interruptsEnabled = true
+
+	cpu.ExecOne()
+	if !cpu.IsLocked {
+		t.Fatal("the CPU is not locked")
+	}
+	// not even interrupts wake up the CPU
+	cpu.IE = 1
+	cpu.IF = 1
+	for i := 0; i < 10; i++ {
+		if ticks := cpu.ExecOne(); ticks != 1 {
+			t.Errorf("ticks=%d (exp: 1)", ticks)
+		}
+	}
+	if cpu.PC != 0x201 || cpu.A != 0 {
+		t.Errorf("cpu.PC=%04x (exp: 0x0201); cpu.A=%d (exp: 0)", cpu.PC, cpu.A)
+	}
+}
+
+func TestDecodeLength(t *testing.T) {
+	for i := 0; i < 0x200; i++ {
+		data := []byte{uint8(i), 0x12, 0x34}
+		if i >= 0x100 {
+			data = []byte{0xCB, uint8(i), 0x34}
+		}
+		in, err := Decode(0x100, data)
+		if err != nil {
+			t.Fatalf("unable to decode %02x: %s", data[:2], err)
+		}
+		if in.Illegal || in.IsJump() || in.IsCall() || in.IsReturn() || in.Mnemonic == "HALT" || in.Mnemonic == "STOP" {
+			continue
+		}
+
+		memory := &TestMemory{}
+		memory.WriteBuffer(0x100, data)
+		cpu := Z80Cpu{Mem: memory, PC: 0x100, SP: 0xD000}
+		ticks := cpu.ExecOne()
+		if int(cpu.PC)-0x100 != in.Length || ticks != in.CyclesNotTaken {
+			t.Errorf("%s: length=%d cycles=%d (exp: %d, %d)", in, in.Length, in.CyclesNotTaken, cpu.PC-0x100, ticks)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		data  []byte
+		str   string
+		flags string
+	}{
+		{[]byte{0x20, 0xFE}, "JR NZ,$fe", "----"},
+		{[]byte{0x09}, "ADD HL,BC", "-0HC"},
+		{[]byte{0xE8, 0xFE}, "ADD SP,$fe", "00HC"},
+		{[]byte{0x34}, "INC (HL)", "Z0H-"},
+		{[]byte{0x03}, "INC BC", "----"},
+		{[]byte{0xF2}, "LDH A,(C)", "----"},
+		{[]byte{0xCB, 0x7C}, "BIT 7,H", "Z01-"},
+		{[]byte{0xDC, 0x00, 0x40}, "CALL C,$4000", "----"},
+		{[]byte{0xFF}, "RST 38", "----"},
+	}
+	for _, test := range tests {
+		in, _ := Decode(0x150, test.data)
+		if in.String() != test.str || in.Flags != test.flags {
+			t.Errorf("%x: %s %s (exp: %s %s)", test.data, in, in.Flags, test.str, test.flags)
+		}
+	}
+
+	in, _ := Decode(0x150, []byte{0x20, 0xFE})
+	if !in.IsConditional() || !in.HasTarget || in.Target != 0x150 || in.Cycles != 3 || in.CyclesNotTaken != 2 {
+		t.Errorf("unexpected JR: %+v", in)
+	}
+	in, _ = Decode(0x150, []byte{0xDC, 0x00, 0x40})
+	if !in.IsCall() || in.Target != 0x4000 || in.Operands[0].Kind != OperandCondition || in.Operands[0].Name != "C" {
+		t.Errorf("unexpected CALL: %+v", in)
+	}
+	if in, _ := Decode(0x150, []byte{0xD3}); !in.Illegal || !in.EndsFlow() {
+		t.Errorf("unexpected illegal opcode: %+v", in)
+	}
+	if _, err := Decode(0x150, []byte{0xC3, 0x00}); err == nil {
+		t.Errorf("truncated instruction decoded")
+	}
+
+	mem := &TestMemory{}
+	mem.WriteBuffer(0x150, []byte{0xC3, 0x50, 0x01, 0xD3})
+	if in := DecodeMemory(mem, 0x150); in.Mnemonic != "JP" || in.Length != 3 || in.Target != 0x150 {
+		t.Errorf("unexpected JP: %+v", in)
+	}
+	if in := DecodeMemory(mem, 0x153); !in.Illegal || in.Length != 1 {
+		t.Errorf("unexpected illegal opcode: %+v", in)
+	}
+}
@@ -0,0 +1,300 @@
+package z80cpu
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type OperandKind int
+
+const (
+	OperandRegister  OperandKind = iota // A, B, ..., AF, BC, DE, HL, SP
+	OperandIndirect                     // (BC), (DE), (HL) or (C), at 0xFF00+C
+	OperandCondition                    // NZ, Z, NC, C
+	OperandImm8                         // n
+	OperandImm16                        // nn
+	OperandAddr16                       // (nn)
+	OperandHighAddr                     // (n), at 0xFF00+n
+	OperandRelative                     // The signed offset of JR
+	OperandSPOffset                     // The signed offset of ADD SP and LDHL
+	OperandBit                          // The bit of BIT, RES and SET
+	OperandVector                       // The target of RST
+)
+
+type Operand struct {
+	Kind OperandKind
+	// The register (or condition) name
+	Name string
+	// The immediate value, address, signed offset, bit or RST vector
+	Value int
+}
+
+// Instruction is a decoded instruction. The flags are in the order Z, N,
+// H, C: "-" if the flag is not changed, "0" or "1" if it is reset or set,
+// the flag name if it depends on the result
+type Instruction struct {
+	Addr     uint16
+	Opcode   uint8
+	Prefixed bool // The CB instructions, Opcode follows the prefix
+	Illegal  bool
+
+	Mnemonic string
+	Operands []Operand
+	Length   int
+
+	// M-cycles, when the condition is true for the conditional
+	// instructions (and when it is false in CyclesNotTaken)
+	Cycles         int
+	CyclesNotTaken int
+
+	Flags string
+
+	// The target of JP nn, JR, CALL and RST
+	Target    uint16
+	HasTarget bool
+}
+
+// An instruction in opcodeTable or in cbOpcodeTable, with the operands
+// still to fill
+type decodeEntry struct {
+	mnemonic string
+	operands []Operand
+	length   int
+	flags    string
+}
+
+var mnemonicFlags = map[string]string{
+	"INC": "Z0H-", "DEC": "Z1H-",
+	"ADD": "Z0HC", "ADC": "Z0HC", "SUB": "Z1HC", "SBC": "Z1HC", "CP": "Z1HC",
+	"AND": "Z010", "XOR": "Z000", "OR": "Z000",
+	"RLCA": "000C", "RRCA": "000C", "RLA": "000C", "RRA": "000C",
+	"RLC": "Z00C", "RRC": "Z00C", "RL": "Z00C", "RR": "Z00C",
+	"SLA": "Z00C", "SRA": "Z00C", "SRL": "Z00C", "SWAP": "Z000",
+	"BIT": "Z01-", "DAA": "Z-0C", "CPL": "-11-", "SCF": "-001", "CCF": "-00C",
+	"LDHL": "00HC",
+}
+
+var conditions = map[string]bool{"NZ": true, "Z": true, "NC": true, "C": true}
+
+func parseOperand(mnemonic, s string, first bool) Operand {
+	isBranch := mnemonic == "JP" || mnemonic == "JR" || mnemonic == "CALL" || mnemonic == "RET"
+	switch {
+	case s == "n" && mnemonic == "JR":
+		return Operand{Kind: OperandRelative}
+	case s == "n":
+		return Operand{Kind: OperandImm8}
+	case s == "nn":
+		return Operand{Kind: OperandImm16}
+	case s == "(nn)":
+		return Operand{Kind: OperandAddr16}
+	case s == "(n)":
+		return Operand{Kind: OperandHighAddr}
+	case s == "d":
+		return Operand{Kind: OperandSPOffset}
+	case mnemonic == "RST":
+		v, _ := strconv.ParseUint(s, 16, 8)
+		return Operand{Kind: OperandVector, Value: int(v)}
+	case first && (mnemonic == "BIT" || mnemonic == "RES" || mnemonic == "SET"):
+		return Operand{Kind: OperandBit, Value: int(s[0] - '0')}
+	case first && isBranch && conditions[s]:
+		return Operand{Kind: OperandCondition, Name: s}
+	case strings.HasPrefix(s, "("):
+		return Operand{Kind: OperandIndirect, Name: strings.Trim(s, "()")}
+	}
+	return Operand{Kind: OperandRegister, Name: s}
+}
+
+func makeDecodeTable(table []string, prefixed bool) []decodeEntry {
+	res := make([]decodeEntry, len(table))
+	for i, s := range table {
+		mnemonic, args, _ := strings.Cut(s, " ")
+		e := decodeEntry{mnemonic: mnemonic, length: 1, flags: "----"}
+		if prefixed {
+			e.length = 2
+		}
+		if args != "" {
+			operands := strings.Split(args, ",")
+			for j, arg := range operands {
+				op := parseOperand(mnemonic, arg, j == 0 && len(operands) > 1 || mnemonic == "RET")
+				switch op.Kind {
+				case OperandImm8, OperandHighAddr, OperandRelative, OperandSPOffset:
+					e.length += 1
+				case OperandImm16, OperandAddr16:
+					e.length += 2
+				}
+				e.operands = append(e.operands, op)
+			}
+		}
+		if mnemonic == "STOP" {
+			// The second byte is ignored
+			e.length = 2
+		}
+
+		if flags, ok := mnemonicFlags[mnemonic]; ok {
+			e.flags = flags
+		}
+		ops := e.operands
+		switch {
+		case (mnemonic == "INC" || mnemonic == "DEC") && ops[0].Kind == OperandRegister && len(ops[0].Name) == 2:
+			e.flags = "----"
+		case mnemonic == "ADD" && ops[0].Name == "HL":
+			e.flags = "-0HC"
+		case mnemonic == "ADD" && ops[0].Name == "SP":
+			e.flags = "00HC"
+		case mnemonic == "POP" && ops[0].Name == "AF":
+			e.flags = "ZNHC"
+		}
+		res[i] = e
+	}
+	return res
+}
+
+var decodeTable = makeDecodeTable(opcodeTable, false)
+var cbDecodeTable = makeDecodeTable(cbOpcodeTable, true)
+
+func isIllegalOpcode(opcode uint8) bool {
+	return opcodeTable[opcode] == "XX"
+}
+
+// instructionLength returns the length of the instruction starting with
+// opcode
+func instructionLength(opcode uint8) int {
+	if opcode == 0xCB {
+		return 2
+	}
+	return decodeTable[opcode].length
+}
+
+// Decode decodes the instruction at addr, data starts with its bytes. The
+// illegal opcodes are decoded as 1 byte instructions
+func Decode(addr uint16, data []byte) (Instruction, error) {
+	if len(data) == 0 || len(data) < instructionLength(data[0]) {
+		return Instruction{}, Z80DisasError("Truncated instruction")
+	}
+
+	in := Instruction{Addr: addr, Opcode: data[0]}
+	e := decodeTable[in.Opcode]
+	in.Cycles = int(ticks_branched[in.Opcode])
+	in.CyclesNotTaken = int(ticks_opcode[in.Opcode])
+	if in.Opcode == 0xCB {
+		in.Opcode, in.Prefixed = data[1], true
+		e = cbDecodeTable[in.Opcode]
+		in.Cycles = int(ticks_cb[in.Opcode])
+		in.CyclesNotTaken = in.Cycles
+	}
+	in.Illegal = !in.Prefixed && isIllegalOpcode(in.Opcode)
+	in.Mnemonic, in.Length, in.Flags = e.mnemonic, e.length, e.flags
+
+	in.Operands = append([]Operand{}, e.operands...)
+	for i := range in.Operands {
+		op := &in.Operands[i]
+		switch op.Kind {
+		case OperandImm8, OperandHighAddr:
+			op.Value = int(data[1])
+		case OperandRelative, OperandSPOffset:
+			op.Value = int(int8(data[1]))
+		case OperandImm16, OperandAddr16:
+			op.Value = int(data[1]) | int(data[2])<<8
+		}
+
+		switch {
+		case op.Kind == OperandRelative:
+			in.Target, in.HasTarget = addr+uint16(in.Length)+uint16(op.Value), true
+		case op.Kind == OperandVector:
+			in.Target, in.HasTarget = uint16(op.Value), true
+		case op.Kind == OperandImm16 && (in.IsJump() || in.IsCall()):
+			in.Target, in.HasTarget = uint16(op.Value), true
+		}
+	}
+	return in, nil
+}
+
+// readInstruction reads the bytes of the instruction at addr in mem
+func readInstruction(mem Memory, addr uint16) []byte {
+	data := []byte{mem.Read(addr)}
+	for i := 1; i < instructionLength(data[0]); i++ {
+		data = append(data, mem.Read(addr+uint16(i)))
+	}
+	return data
+}
+
+// DecodeMemory decodes the instruction at addr in mem, reading only its
+// bytes. Unlike Decode it cannot fail: all the bytes of the instruction are
+// read, and the illegal opcodes are decoded (with Illegal set)
+func DecodeMemory(mem Memory, addr uint16) Instruction {
+	// Decode fails only for truncated instructions
+	in, _ := Decode(addr, readInstruction(mem, addr))
+	return in
+}
+
+func (in Instruction) IsJump() bool {
+	return in.Mnemonic == "JP" || in.Mnemonic == "JR"
+}
+
+// IsCall is true for CALL and RST
+func (in Instruction) IsCall() bool {
+	return in.Mnemonic == "CALL" || in.Mnemonic == "RST"
+}
+
+func (in Instruction) IsReturn() bool {
+	return in.Mnemonic == "RET" || in.Mnemonic == "RETI"
+}
+
+func (in Instruction) IsConditional() bool {
+	return len(in.Operands) > 0 && in.Operands[0].Kind == OperandCondition
+}
+
+// EndsFlow is true if the instruction never continues with the following
+// one: unconditional jumps and returns, and the illegal opcodes
+func (in Instruction) EndsFlow() bool {
+	return in.Illegal || (in.IsJump() || in.IsReturn()) && !in.IsConditional()
+}
+
+// format formats the instruction, symbolize (optional) names the addresses
+func (in Instruction) format(symbolize func(addr uint16) (string, bool)) string {
+	name := func(addr uint16, def string) string {
+		if symbolize != nil {
+			if label, ok := symbolize(addr); ok {
+				return label
+			}
+		}
+		return def
+	}
+
+	operands := make([]string, 0, len(in.Operands))
+	for _, op := range in.Operands {
+		var s string
+		switch op.Kind {
+		case OperandRegister, OperandCondition:
+			s = op.Name
+		case OperandIndirect:
+			s = "(" + op.Name + ")"
+		case OperandImm8:
+			s = fmt.Sprintf("$%02x", op.Value)
+		case OperandImm16:
+			s = name(uint16(op.Value), fmt.Sprintf("$%04x", op.Value))
+		case OperandAddr16:
+			s = "(" + name(uint16(op.Value), fmt.Sprintf("$%04x", op.Value)) + ")"
+		case OperandHighAddr:
+			s = "(" + name(0xFF00+uint16(op.Value), fmt.Sprintf("$%02x", op.Value)) + ")"
+		case OperandRelative:
+			s = name(in.Target, fmt.Sprintf("$%02x", uint8(op.Value)))
+		case OperandSPOffset:
+			s = fmt.Sprintf("$%02x", uint8(op.Value))
+		case OperandBit:
+			s = strconv.Itoa(op.Value)
+		case OperandVector:
+			s = fmt.Sprintf("%x", op.Value)
+		}
+		operands = append(operands, s)
+	}
+	if len(operands) == 0 {
+		return in.Mnemonic
+	}
+	return in.Mnemonic + " " + strings.Join(operands, ",")
+}
+
+func (in Instruction) String() string {
+	return in.format(nil)
+}